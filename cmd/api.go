@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/math280h/greydns/internal/audit"
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/providers"
+	"github.com/math280h/greydns/internal/records"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	// Credential holding the bearer token of the REST API
+	apiTokenKey = "api-token"
+)
+
+var (
+	apiToken atomic.Pointer[[]byte] //nolint:gochecknoglobals // Required for the REST API
+)
+
+// recordView is a managed record set as shown by the dashboard and the REST API.
+type recordView struct {
+	Zone     string    `json:"zone"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Contents []string  `json:"contents"`
+	TTL      int       `json:"ttl"`
+	Proxied  bool      `json:"proxied"`
+	Owner    string    `json:"owner,omitempty"`
+	LastSync time.Time `json:"lastSync,omitzero"`
+}
+
+// setAPIToken takes the REST API token from the credentials, without one the REST API refuses every request.
+func setAPIToken(
+	credentials cfg.Credentials,
+) {
+	token := credentials[apiTokenKey]
+	apiToken.Store(&token)
+}
+
+// startAPI serves the HTTP endpoints of the controller on api-addr when it is set. Services are
+// only resynced through the API while elected is closed, i.e. on the leader.
+func startAPI(
+	ctx context.Context,
+	reader client.Reader,
+	queues *zoneQueues,
+	elected <-chan struct{},
+) {
+	addr := cfg.GetConfigValue("api-addr", "")
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		// Browsers cannot send the bearer token, api-public-dashboard opts out of it for the
+		// read-only dashboard alone
+		if cfg.GetConfigValue("api-public-dashboard", "false") == "true" {
+			defer utils.Recover("api", nil)
+			serveDashboard(w, r)
+			return
+		}
+		authenticated(serveDashboard)(w, r)
+	})
+	mux.HandleFunc("GET /plan", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		servePlan(reader, w, r)
+	}))
+	mux.HandleFunc("GET /api/v1/records", authenticated(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, recordViews())
+	}))
+	mux.HandleFunc("GET /api/v1/records/{domain}", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		domain := pathDomain(r)
+		found := slices.DeleteFunc(recordViews(), func(record recordView) bool {
+			return !strings.EqualFold(record.Name, domain)
+		})
+		if len(found) == 0 {
+			writeJSON(w, http.StatusNotFound, apiError{Error: "no managed records for " + domain})
+			return
+		}
+		writeJSON(w, http.StatusOK, found)
+	}))
+	mux.HandleFunc("GET /api/v1/records/{domain}/history", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		domain := pathDomain(r)
+		events := audit.History(domain)
+		if len(events) == 0 {
+			writeJSON(w, http.StatusNotFound, apiError{Error: "no recorded changes for " + domain})
+			return
+		}
+		writeJSON(w, http.StatusOK, events)
+	}))
+	mux.HandleFunc("GET /api/v1/conflicts", authenticated(func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, records.Conflicts())
+	}))
+	mux.HandleFunc("POST /api/v1/services/{namespace}/{name}/resync", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		serveResync(reader, queues, elected, w, r)
+	}))
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		log.Info().Msgf("[API] Serving on %s", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("[API] Server stopped")
+		}
+	}()
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// authenticated only lets requests with the api-token of the greydns secret as bearer token through.
+func authenticated(
+	handler http.HandlerFunc,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer utils.Recover("api", nil)
+		token := apiToken.Load()
+		if token == nil || len(*token) == 0 {
+			writeJSON(w, http.StatusServiceUnavailable, apiError{Error: "the REST API requires the api-token credential"})
+			return
+		}
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), *token) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, apiError{Error: "invalid or missing bearer token"})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func writeJSON(
+	w http.ResponseWriter,
+	status int,
+	value interface{},
+) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Error().Err(err).Msg("[API] Failed to write response")
+	}
+}
+
+// serveResync queues a service for a reconcile that checks its records even without changes.
+func serveResync(
+	reader client.Reader,
+	queues *zoneQueues,
+	elected <-chan struct{},
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	select {
+	case <-elected:
+	default:
+		writeJSON(w, http.StatusServiceUnavailable, apiError{Error: "this replica is not the leader"})
+		return
+	}
+
+	name := types.NamespacedName{Namespace: r.PathValue("namespace"), Name: r.PathValue("name")}
+	if !namespaceAllowed(name.Namespace) {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "service " + name.String() + " is not managed"})
+		return
+	}
+	zone, ok := routeZone(r.Context(), reader, name)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "service " + name.String() + " not found"})
+		return
+	}
+	requestResync(name.String())
+	queues.add(zone, ctrl.Request{NamespacedName: name})
+	log.Info().Str("namespace", name.Namespace).Str("service", name.Name).Msg("[API] Resync requested")
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+}
+
+// recordViews returns the cached record sets with their owners, sorted by zone, name and type.
+func recordViews() []recordView {
+	existingRecords := cachedRecords()
+	stateLock.Lock()
+	lastSync := maps.Clone(lastReconciled)
+	stateLock.Unlock()
+	zoneNames := make(map[string]string)
+	for name, zoneID := range knownZones() {
+		zoneNames[zoneID] = name
+	}
+
+	views := make([]recordView, 0, len(existingRecords))
+	for key, recordSet := range existingRecords {
+		zoneID := providers.KeyZone(key)
+		zone, known := zoneNames[zoneID]
+		if !known {
+			zone = zoneID
+		}
+		view := recordView{
+			Zone:    zone,
+			Name:    recordSet[0].Name,
+			Type:    string(recordSet[0].Type),
+			TTL:     int(recordSet[0].TTL),
+			Proxied: recordSet[0].Proxied,
+		}
+		for _, record := range recordSet {
+			view.Contents = append(view.Contents, record.Content)
+		}
+		if namespace, name, ok := providers.OwnerService(recordSet[0].Comment); ok {
+			view.Owner = namespace + "/" + name
+			view.LastSync = lastSync[view.Owner]
+		}
+		views = append(views, view)
+	}
+	slices.SortFunc(views, func(a, b recordView) int {
+		return strings.Compare(a.Zone+"/"+a.Name+"/"+a.Type, b.Zone+"/"+b.Name+"/"+b.Type)
+	})
+
+	return views
+}
+
+// servePlan writes the changes a reconcile of every service would make against the cached records.
+func servePlan(
+	reader client.Reader,
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	state, err := desiredState(r.Context(), reader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err = records.WritePlan(w, records.Plan(cachedRecords(), state), zoneName); err != nil {
+		log.Error().Err(err).Msg("[API] Failed to write the plan")
+	}
+}
+
+// desiredState works out the records of every managed service, services that cannot be worked
+// out are logged and left out.
+func desiredState(
+	ctx context.Context,
+	reader client.Reader,
+) (records.State, error) {
+	var services v1.ServiceList
+	if err := reader.List(ctx, &services); err != nil {
+		return records.State{}, err
+	}
+	ingressDestination, err := cfg.GetRequiredConfigValue("ingress-destination")
+	if err != nil {
+		return records.State{}, err
+	}
+
+	managed := make([]v1.Service, 0, len(services.Items))
+	for _, service := range services.Items {
+		if namespaceAllowed(service.Namespace) {
+			managed = append(managed, service)
+		}
+	}
+	state, err := records.DesiredState(ctx, managed, ingressDestination, knownZones())
+	if err != nil {
+		log.Warn().Err(err).Msg("[API] Some services have no desired records")
+	}
+
+	return state, nil
+}
+
+// pathDomain returns the domain of a request the way record names are cached, Unicode names
+// are looked up by their punycode form.
+func pathDomain(
+	r *http.Request,
+) string {
+	domain := providers.NormalizeName(r.PathValue("domain"))
+	if converted, err := records.ASCIIDomain(domain); err == nil {
+		return converted
+	}
+
+	return domain
+}
+
+// zoneName returns the name of a known zone ID, or the ID itself.
+func zoneName(
+	zoneID string,
+) string {
+	for name, id := range knownZones() {
+		if id == zoneID {
+			return name
+		}
+	}
+
+	return zoneID
+}
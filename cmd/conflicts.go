@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/records"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	defaultConflictReportSeconds = 600
+)
+
+func conflictReportInterval() time.Duration {
+	seconds, err := strconv.Atoi(cfg.GetConfigValue("conflict-report-seconds", strconv.Itoa(defaultConflictReportSeconds)))
+	if err != nil || seconds < 0 {
+		log.Error().Msgf("[Core] conflict-report-seconds must be a non-negative integer, using %d", defaultConflictReportSeconds)
+		seconds = defaultConflictReportSeconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// watchConflicts logs the records claimed by several services until ctx is done.
+func watchConflicts(
+	ctx context.Context,
+) error {
+	for {
+		interval := conflictReportInterval()
+		if interval == 0 {
+			// Checked again in case the interval is changed at runtime
+			interval = defaultConflictReportSeconds * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+		if conflictReportInterval() > 0 {
+			reportConflicts()
+		}
+	}
+}
+
+func reportConflicts() {
+	defer utils.Recover("conflicts", nil)
+
+	conflicts := records.Conflicts()
+	if len(conflicts) == 0 {
+		return
+	}
+	log.Warn().Msgf("[Core] %d records are claimed by more than one service", len(conflicts))
+	for _, conflict := range conflicts {
+		log.Warn().
+			Str("zone", zoneName(conflict.ZoneID)).
+			Str("owner", conflict.Owner).
+			Strs("rejected", conflict.Rejected).
+			Msgf(
+				"[Core] %s record %s is owned by %s since %s, rejected for %s",
+				conflict.Type,
+				conflict.Name,
+				conflict.Owner,
+				conflict.Since.Format(time.RFC3339),
+				strings.Join(conflict.Rejected, ", "),
+			)
+	}
+}
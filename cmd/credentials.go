@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+// loadCredentials reads the provider credentials, retrying until the source can be read.
+func loadCredentials(
+	ctx context.Context,
+	source cfg.CredentialSource,
+) cfg.Credentials {
+	var credentials cfg.Credentials
+	err := utils.RetryStartup("load credentials", utils.Always, func() error {
+		var loadErr error
+		credentials, loadErr = source.Load(ctx)
+		return loadErr
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msgf("[Core] Failed to load the credentials from %s", source.Name())
+	}
+	log.Info().Msgf("[Core] Loaded the credentials from %s", source.Name())
+
+	return credentials
+}
+
+// watchCredentials reconnects the provider when the credentials are rotated.
+func watchCredentials(
+	ctx context.Context,
+	source cfg.CredentialSource,
+) {
+	source.Watch(ctx, func(credentials cfg.Credentials) {
+		log.Info().Msgf("[Core] Credentials in %s changed, reconnecting the provider", source.Name())
+		cf.Connect(credentials)
+		setAPIToken(credentials)
+		setSnapshotCredentials(credentials)
+	})
+}
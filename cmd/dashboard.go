@@ -0,0 +1,70 @@
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	//go:embed dashboard.html
+	dashboardHTML     string                                                          //nolint:gochecknoglobals // Required for the dashboard
+	dashboardTemplate = template.Must(template.New("dashboard").Parse(dashboardHTML)) //nolint:gochecknoglobals // Required for the dashboard
+)
+
+type dashboardZone struct {
+	Name    string
+	Records []recordView
+}
+
+type dashboardData struct {
+	Generated time.Time
+	// RecordsAsOf is when the cache of a replica that is not the leader was last refreshed, zero
+	// on the leader
+	RecordsAsOf time.Time
+	RecordCount int
+	Zones       []dashboardZone
+	Errors      []reconcileError
+}
+
+// serveDashboard renders the read-only overview of the managed records and the recent errors.
+func serveDashboard(
+	w http.ResponseWriter,
+	_ *http.Request,
+) {
+	data := dashboardSnapshot()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		log.Error().Err(err).Msg("[API] Failed to render the dashboard")
+	}
+}
+
+// dashboardSnapshot only reads copies of the reconcile state, records come from the snapshots the
+// zone workers publish, so rendering never waits for a reconcile in progress.
+func dashboardSnapshot() dashboardData {
+	stateLock.Lock()
+	recent := slices.Clone(recentErrors)
+	stateLock.Unlock()
+	slices.Reverse(recent)
+
+	data := dashboardData{
+		Generated:   time.Now(),
+		RecordsAsOf: followerCacheTime(),
+		Errors:      recent,
+	}
+	// Views are sorted by zone, so the records of a zone follow each other
+	for _, record := range recordViews() {
+		if len(data.Zones) == 0 || data.Zones[len(data.Zones)-1].Name != record.Zone {
+			data.Zones = append(data.Zones, dashboardZone{Name: record.Zone})
+		}
+		zone := &data.Zones[len(data.Zones)-1]
+		zone.Records = append(zone.Records, record)
+		data.RecordCount += len(record.Contents)
+	}
+
+	return data
+}
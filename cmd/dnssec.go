@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/metrics"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	defaultDNSSECCheckSeconds = 3600
+	dnssecActive              = "active"
+)
+
+// dnssecCheckInterval is how often the DNSSEC status of managed zones is checked, 0 disables it.
+func dnssecCheckInterval() time.Duration {
+	seconds, err := strconv.Atoi(cfg.GetConfigValue("dnssec-check-seconds", strconv.Itoa(defaultDNSSECCheckSeconds)))
+	if err != nil || seconds < 0 {
+		log.Error().Msgf("[Core] dnssec-check-seconds must be a non-negative integer, using %d", defaultDNSSECCheckSeconds)
+		seconds = defaultDNSSECCheckSeconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// watchDNSSEC checks the DNSSEC status of every zone with managed records until ctx is done.
+func watchDNSSEC(
+	ctx context.Context,
+) error {
+	statuses := make(map[string]string)
+	for {
+		interval := dnssecCheckInterval()
+		if interval > 0 {
+			checkDNSSEC(ctx, statuses)
+		} else {
+			// Checked again in case the interval is changed at runtime
+			interval = time.Duration(defaultDNSSECCheckSeconds) * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+func checkDNSSEC(
+	ctx context.Context,
+	statuses map[string]string,
+) {
+	defer utils.Recover("dnssec", nil)
+
+	for name, zoneID := range managedZones() {
+		status, err := cf.GetDNSSECStatus(ctx, zoneID)
+		if err != nil {
+			log.Error().Err(err).Msgf("[Core] Failed to get the DNSSEC status of %s", name)
+			continue
+		}
+		active := 0.0
+		if status == dnssecActive {
+			active = 1
+		}
+		metrics.ZoneDNSSEC.WithLabelValues(name).Set(active)
+
+		previous, known := statuses[zoneID]
+		statuses[zoneID] = status
+		if !known || previous != dnssecActive || status == dnssecActive {
+			continue
+		}
+		log.Warn().Msgf("[Core] DNSSEC of zone %s is no longer active, status is %s", name, status)
+		if cfg.GetConfigValue("dnssec-warnings", "false") != "true" {
+			continue
+		}
+		for _, service := range zoneServices(zoneID) {
+			utils.Recorder.Eventf(
+				service,
+				v1.EventTypeWarning,
+				"DNSSECInactive",
+				"DNSSEC of zone %s is no longer active, status is %s",
+				name,
+				status,
+			)
+		}
+	}
+}
+
+// managedZones maps the names of zones with managed records to their IDs.
+func managedZones() map[string]string {
+	zoneLock.Lock()
+	defer zoneLock.Unlock()
+	managed := make(map[string]string)
+	for name, zoneID := range zonesToNames {
+		if len(recordsByZone[zoneID]) > 0 {
+			managed[name] = zoneID
+		}
+	}
+
+	return managed
+}
+
+// zoneServices returns the reconciled services whose records live in a zone.
+func zoneServices(
+	zoneID string,
+) []*v1.Service {
+	stateLock.Lock()
+	services := make([]*v1.Service, 0, len(lastApplied))
+	for _, service := range lastApplied {
+		services = append(services, service)
+	}
+	stateLock.Unlock()
+
+	inZone := make([]*v1.Service, 0)
+	for _, service := range services {
+		if service.Annotations["greydns.io/dns"] == "true" && serviceZone(service) == zoneID {
+			inZone = append(inZone, service)
+		}
+	}
+
+	return inZone
+}
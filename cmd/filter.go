@@ -0,0 +1,29 @@
+package main
+
+import (
+	"slices"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+// namespaceAllowed applies watch-namespaces, exclude-namespaces and sharding, an empty watch list means all.
+func namespaceAllowed(
+	namespace string,
+) bool {
+	if !shardOwns(namespace) {
+		return false
+	}
+	if slices.Contains(utils.SplitList(cfg.GetConfigValue("exclude-namespaces", "")), namespace) {
+		return false
+	}
+	watched := utils.SplitList(cfg.GetConfigValue("watch-namespaces", ""))
+
+	return len(watched) == 0 || slices.Contains(watched, namespace)
+}
+
+func filterService(obj client.Object) bool {
+	return namespaceAllowed(obj.GetNamespace())
+}
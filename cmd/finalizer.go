@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/rs/zerolog"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/providers"
+	"github.com/math280h/greydns/internal/records"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	finalizerName = "greydns.io/cleanup"
+)
+
+func finalizersEnabled() bool {
+	return cfg.GetConfigValue("finalizers", "true") == "true"
+}
+
+// setFinalizer adds or removes the greydns finalizer, services already in the desired state are left alone.
+func setFinalizer(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	service *v1.Service,
+	enabled bool,
+) error {
+	if slices.Contains(service.Finalizers, finalizerName) == enabled {
+		return nil
+	}
+	if providers.DryRun() {
+		zerolog.Ctx(ctx).Info().Msgf("[Core] [dry-run] Would set finalizer to %t", enabled)
+		return nil
+	}
+
+	// The lister's copy is shared with the informer cache and must not be modified
+	updated := service.DeepCopy()
+	if enabled {
+		updated.Finalizers = append(updated.Finalizers, finalizerName)
+	} else {
+		updated.Finalizers = slices.DeleteFunc(updated.Finalizers, func(finalizer string) bool {
+			return finalizer == finalizerName
+		})
+	}
+
+	callCtx, cancel := providers.WithTimeout(ctx)
+	defer cancel()
+	_, err := clientset.CoreV1().Services(service.Namespace).Update(callCtx, updated, metav1.UpdateOptions{})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("[Core] Failed to update finalizer")
+		return err
+	}
+	zerolog.Ctx(ctx).Debug().Msgf("[Core] Finalizer set to %t", enabled)
+
+	return nil
+}
+
+// finalize deletes the records of a service that is being deleted and releases it afterwards.
+// Services without the finalizer are cleaned up once they are gone from the cache instead. A zone
+// that cannot be resolved has no records of the service to delete, so the service is released
+// rather than kept from being deleted.
+func finalize(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	key string,
+	service *v1.Service,
+	existingRecords map[string][]dns.RecordResponse,
+	zones map[string]string,
+) error {
+	if !slices.Contains(service.Finalizers, finalizerName) {
+		return nil
+	}
+
+	err := records.HandleDeletions(ctx, existingRecords, zones, service)
+	if errors.Is(err, records.ErrZoneNotFound) {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("[Core] Zone cannot be resolved, releasing the service without cleanup")
+		utils.Recorder.Eventf(
+			service,
+			v1.EventTypeWarning,
+			"ZoneNotFound",
+			"%v, the finalizer was removed without deleting records",
+			err,
+		)
+	} else if err != nil {
+		return err
+	}
+	if err := setFinalizer(ctx, clientset, service, false); err != nil {
+		return err
+	}
+	forgetService(key)
+
+	return nil
+}
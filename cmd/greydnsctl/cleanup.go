@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/math280h/greydns/internal/providers"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+	"github.com/math280h/greydns/internal/registry"
+)
+
+func runCleanup(
+	ctx context.Context,
+	args []string,
+) error {
+	flags, common := newFlagSet("cleanup")
+	orphans := flags.Bool(
+		"orphans",
+		false,
+		"Delete records owned by services that no longer exist",
+	)
+	zone := flags.String(
+		"zone",
+		"",
+		"Only clean up the records of this zone",
+	)
+	dryRun := flags.Bool(
+		"dry-run",
+		false,
+		"Print the records that would be deleted without deleting them",
+	)
+	_ = flags.Parse(args)
+	if !*orphans {
+		return errors.New("nothing to clean up, use -orphans")
+	}
+
+	s, err := connect(ctx, common)
+	if err != nil {
+		return err
+	}
+	existingRecords, err := s.records(ctx, *zone)
+	if err != nil {
+		return err
+	}
+	byZone, err := s.orphanedRecords(ctx, existingRecords)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	found, deleted := 0, 0
+	for _, zoneID := range slices.Sorted(maps.Keys(byZone)) {
+		recordSets := byZone[zoneID]
+		found += len(recordSets)
+		if *dryRun {
+			for _, recordSet := range recordSets {
+				fmt.Fprintf(os.Stdout, "would delete %s %s owned by %s\n", recordSet[0].Type, recordSet[0].Name, recordOwner(recordSet))
+			}
+			continue
+		}
+
+		count, deleteErr := cf.DeleteRecordSets(ctx, recordSets, zoneID)
+		for _, recordSet := range recordSets[:count] {
+			deleted++
+			fmt.Fprintf(os.Stdout, "deleted %s %s owned by %s\n", recordSet[0].Type, recordSet[0].Name, recordOwner(recordSet))
+			namespace, _, _ := strings.Cut(recordOwner(recordSet), "/")
+			if err = registry.Remove(ctx, namespace, recordSet[0].Name, string(recordSet[0].Type)); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to unregister %s %s: %v\n", recordSet[0].Type, recordSet[0].Name, err)
+			}
+		}
+		if errors.Is(deleteErr, providers.ErrDeletionLimit) {
+			deleteErr = fmt.Errorf("%w, raise it with -set max-deletions=<n> -set max-deletion-percent=<n>", deleteErr)
+		}
+		if deleteErr != nil {
+			errs = append(errs, deleteErr)
+		}
+	}
+	fmt.Fprintf(os.Stdout, "%d orphaned record sets found, %d deleted\n", found, deleted)
+
+	return errors.Join(errs...)
+}
+
+// orphanedRecords returns the record sets of this greydns instance whose service no longer exists, per zone ID.
+func (s *session) orphanedRecords(
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
+) (map[string][][]dns.RecordResponse, error) {
+	// Every service counts, a label selector must not make services look deleted
+	services, err := s.clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+	existing := make(map[string]bool, len(services.Items))
+	for _, service := range services.Items {
+		existing[service.Namespace+"/"+service.Name] = true
+	}
+
+	byZone := make(map[string][][]dns.RecordResponse)
+	for _, key := range slices.Sorted(maps.Keys(existingRecords)) {
+		recordSet := existingRecords[key]
+		namespace, name, ok := providers.OwnerService(recordSet[0].Comment)
+		if !ok || existing[namespace+"/"+name] || providers.IsRetained(recordSet[0].Comment) {
+			continue
+		}
+		byZone[providers.KeyZone(key)] = append(byZone[providers.KeyZone(key)], recordSet)
+	}
+
+	return byZone, nil
+}
+
+// recordOwner is the namespace/name of the service owning a record set of this instance.
+func recordOwner(
+	recordSet []dns.RecordResponse,
+) string {
+	namespace, name, _ := providers.OwnerService(recordSet[0].Comment)
+
+	return namespace + "/" + name
+}
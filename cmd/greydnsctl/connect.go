@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/rs/zerolog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/discovery"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+	"github.com/math280h/greydns/internal/records"
+	"github.com/math280h/greydns/internal/registry"
+)
+
+// session holds the clients of a command, connected with the same credentials and config as greydns.
+type session struct {
+	clientset    *kubernetes.Clientset
+	zonesToNames map[string]string
+}
+
+// commandFlags are the flags every command shares.
+type commandFlags struct {
+	kubeconfig *string
+	verbose    *bool
+}
+
+func newFlagSet(
+	name string,
+) (*flag.FlagSet, commandFlags) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	common := commandFlags{
+		kubeconfig: flags.String(
+			"kubeconfig",
+			"",
+			"Path to a kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config",
+		),
+		verbose: flags.Bool(
+			"v",
+			false,
+			"Log what greydns does while loading zones and records",
+		),
+	}
+	cfg.RegisterFlags(flags)
+
+	return flags, common
+}
+
+// connect loads the greydns config and credentials from the cluster, connects the registry and lists the zones.
+func connect(
+	ctx context.Context,
+	common commandFlags,
+) (*session, error) {
+	if *common.verbose {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = *common.kubeconfig
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cluster config: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clientset: %w", err)
+	}
+
+	if err = cfg.LoadConfigMap(clientset); err != nil {
+		return nil, err
+	}
+	source, err := cfg.NewCredentialSource(clientset)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := source.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the credentials from %s: %w", source.Name(), err)
+	}
+	cf.Connect(credentials)
+	discovery.ConnectLoadBalancers(ctx, clientset, nil)
+	switch cfg.GetConfigValue("registry", "") {
+	case "crd":
+		if err = registry.ConnectCRD(config); err != nil {
+			return nil, fmt.Errorf("failed to connect the registry: %w", err)
+		}
+	case "configmap":
+		registry.ConnectConfigMap(clientset)
+	}
+
+	zonesToNames, err := cf.GetZoneNames(ctx)
+	if err != nil {
+		if len(zonesToNames) == 0 {
+			return nil, fmt.Errorf("failed to list zones: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "warning: some zones are missing: %v\n", err)
+	}
+
+	return &session{
+		clientset:    clientset,
+		zonesToNames: zonesToNames,
+	}, nil
+}
+
+// records lists the managed records of every zone, or of a single zone when zoneName is set.
+func (s *session) records(
+	ctx context.Context,
+	zoneName string,
+) (map[string][]dns.RecordResponse, error) {
+	zonesToNames := s.zonesToNames
+	if zoneName != "" {
+		zoneID, ok := s.zonesToNames[zoneName]
+		if !ok {
+			return nil, errors.New("zone " + zoneName + " does not exist or is not accessible")
+		}
+		zonesToNames = map[string]string{zoneName: zoneID}
+	}
+
+	return cf.RefreshRecordsCache(ctx, zonesToNames)
+}
+
+// zoneName returns the name of a zone ID, or the ID for zones that were not listed.
+func (s *session) zoneName(
+	zoneID string,
+) string {
+	for name, id := range s.zonesToNames {
+		if id == zoneID {
+			return name
+		}
+	}
+
+	return zoneID
+}
+
+// desiredState works out the records the services of the cluster ask for, services that cannot
+// be worked out are printed as warnings.
+func (s *session) desiredState(
+	ctx context.Context,
+) (records.State, error) {
+	services, err := s.clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{
+		LabelSelector: cfg.GetConfigValue("service-label-selector", ""),
+	})
+	if err != nil {
+		return records.State{}, fmt.Errorf("failed to list services: %w", err)
+	}
+	ingressDestination, err := cfg.GetRequiredConfigValue("ingress-destination")
+	if err != nil {
+		return records.State{}, err
+	}
+
+	state, err := records.DesiredState(ctx, services.Items, ingressDestination, s.zonesToNames)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+
+	return state, nil
+}
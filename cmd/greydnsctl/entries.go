@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"sigs.k8s.io/yaml"
+
+	"github.com/math280h/greydns/internal/providers"
+)
+
+const (
+	formatYAML = "yaml"
+	formatJSON = "json"
+)
+
+// recordEntry is a single record in the export and import format. Owner is the ownership marker
+// without its prefix, e.g. default/my-service or cluster-a:default/my-service with an owner-id.
+type recordEntry struct {
+	Zone    string `json:"zone"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied"`
+	Owner   string `json:"owner,omitempty"`
+}
+
+// recordEntries converts the cached record sets to entries, sorted so exports of the same records are identical.
+func recordEntries(
+	existingRecords map[string][]dns.RecordResponse,
+	zoneName func(zoneID string) string,
+) []recordEntry {
+	entries := make([]recordEntry, 0, len(existingRecords))
+	for key, recordSet := range existingRecords {
+		for _, record := range recordSet {
+			owner, _ := providers.CommentOwner(record.Comment)
+			entries = append(entries, recordEntry{
+				Zone:    zoneName(providers.KeyZone(key)),
+				Name:    record.Name,
+				Type:    string(record.Type),
+				Content: record.Content,
+				TTL:     int(record.TTL),
+				Proxied: record.Proxied,
+				Owner:   owner,
+			})
+		}
+	}
+	slices.SortFunc(entries, func(a, b recordEntry) int {
+		return strings.Compare(
+			a.Zone+"/"+a.Name+"/"+a.Type+"/"+a.Content,
+			b.Zone+"/"+b.Name+"/"+b.Type+"/"+b.Content,
+		)
+	})
+
+	return entries
+}
+
+func writeEntries(
+	out io.Writer,
+	entries []recordEntry,
+	format string,
+) error {
+	var (
+		encoded []byte
+		err     error
+	)
+	switch format {
+	case formatYAML:
+		encoded, err = yaml.Marshal(entries)
+	case formatJSON:
+		encoded, err = json.MarshalIndent(entries, "", "  ")
+		encoded = append(encoded, '\n')
+	default:
+		return fmt.Errorf("unknown format %q, expected yaml or json", format)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(encoded)
+
+	return err
+}
+
+// readEntries reads a YAML or JSON list of entries, JSON being valid YAML.
+func readEntries(
+	in io.Reader,
+) ([]recordEntry, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	var entries []recordEntry
+	if err = yaml.UnmarshalStrict(data, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
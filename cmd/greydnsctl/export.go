@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+func runExport(
+	ctx context.Context,
+	args []string,
+) error {
+	flags, common := newFlagSet("export")
+	zone := flags.String(
+		"zone",
+		"",
+		"Only export the records of this zone",
+	)
+	format := flags.String(
+		"o",
+		formatYAML,
+		"Output format, yaml or json",
+	)
+	file := flags.String(
+		"file",
+		"",
+		"Write the export to this file instead of stdout",
+	)
+	_ = flags.Parse(args)
+
+	s, err := connect(ctx, common)
+	if err != nil {
+		return err
+	}
+	existingRecords, err := s.records(ctx, *zone)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *file != "" {
+		exportFile, createErr := os.Create(*file)
+		if createErr != nil {
+			return createErr
+		}
+		defer exportFile.Close()
+		out = exportFile
+	}
+
+	return writeEntries(out, recordEntries(existingRecords, s.zoneName), *format)
+}
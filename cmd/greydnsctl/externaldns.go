@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+
+	"github.com/math280h/greydns/internal/providers"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+)
+
+const (
+	externalDNSHeritage = "heritage=external-dns"
+	recordTypeTemplate  = "%{record_type}"
+)
+
+// txtRegistry names the TXT records external-dns keeps its ownership in, like its affix name mapper.
+type txtRegistry struct {
+	prefix              string
+	suffix              string
+	wildcardReplacement string
+}
+
+// names returns the TXT names external-dns uses for a record, the current format with the record
+// type first and the legacy format without it.
+func (r txtRegistry) names(
+	name string,
+	recordType string,
+) []string {
+	recordType = strings.ToLower(recordType)
+	label, rest, hasRest := strings.Cut(name, ".")
+	if r.wildcardReplacement != "" && label == "*" {
+		label = r.wildcardReplacement
+	}
+	prefix := strings.ReplaceAll(r.prefix, recordTypeTemplate, recordType)
+	suffix := strings.ReplaceAll(r.suffix, recordTypeTemplate, recordType)
+	affixTyped := strings.Contains(r.prefix+r.suffix, recordTypeTemplate)
+
+	txtName := func(label string) string {
+		if !hasRest {
+			return strings.ToLower(prefix + label + suffix)
+		}
+		return strings.ToLower(prefix + label + suffix + "." + rest)
+	}
+	if affixTyped {
+		return []string{txtName(label)}
+	}
+
+	return []string{txtName(recordType + "-" + label), txtName(label)}
+}
+
+// ownership is an external-dns TXT registry record, resource is e.g. service/shop/api.
+type ownership struct {
+	owner    string
+	resource string
+	record   dns.RecordResponse
+}
+
+// parseOwnership reads an external-dns TXT registry record, encrypted records are not understood.
+func parseOwnership(
+	record dns.RecordResponse,
+) (ownership, bool) {
+	content := strings.Trim(record.Content, `"`)
+	if !strings.HasPrefix(content, externalDNSHeritage+",") {
+		return ownership{}, false
+	}
+	parsed := ownership{record: record}
+	for _, label := range strings.Split(content, ",") {
+		key, value, _ := strings.Cut(label, "=")
+		switch key {
+		case "external-dns/owner":
+			parsed.owner = value
+		case "external-dns/resource":
+			parsed.resource = value
+		}
+	}
+
+	return parsed, true
+}
+
+func runMigrateExternalDNS(
+	ctx context.Context,
+	args []string,
+) error {
+	flags, common := newFlagSet("migrate-external-dns")
+	zone := flags.String(
+		"zone",
+		"",
+		"Only migrate the records of this zone",
+	)
+	ownerID := flags.String(
+		"txt-owner-id",
+		"default",
+		"Owner ID of the external-dns instance whose records are migrated, its --txt-owner-id",
+	)
+	prefix := flags.String(
+		"txt-prefix",
+		"",
+		"The --txt-prefix of external-dns",
+	)
+	suffix := flags.String(
+		"txt-suffix",
+		"",
+		"The --txt-suffix of external-dns",
+	)
+	wildcardReplacement := flags.String(
+		"txt-wildcard-replacement",
+		"",
+		"The --txt-wildcard-replacement of external-dns",
+	)
+	deleteTXT := flags.Bool(
+		"delete-txt",
+		false,
+		"Delete the TXT registry records of migrated records",
+	)
+	dryRun := flags.Bool(
+		"dry-run",
+		false,
+		"Print the records that would be migrated without changing them",
+	)
+	_ = flags.Parse(args)
+	providers.SetDryRun(*dryRun)
+	txtNames := txtRegistry{prefix: *prefix, suffix: *suffix, wildcardReplacement: *wildcardReplacement}
+
+	s, err := connect(ctx, common)
+	if err != nil {
+		return err
+	}
+	zonesToNames := s.zonesToNames
+	if *zone != "" {
+		zoneID, ok := s.zonesToNames[*zone]
+		if !ok {
+			return errors.New("zone " + *zone + " does not exist or is not accessible")
+		}
+		zonesToNames = map[string]string{*zone: zoneID}
+	}
+
+	verb := "migrated"
+	if providers.DryRun() {
+		verb = "would migrate"
+	}
+	var errs []error
+	migrated, skipped := 0, 0
+	for _, zoneName := range slices.Sorted(maps.Keys(zonesToNames)) {
+		zoneID := zonesToNames[zoneName]
+		records, listErr := cf.ListZoneRecords(ctx, zoneID)
+		if listErr != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", zoneName, listErr))
+			continue
+		}
+
+		// external-dns ownership by TXT name, record sets by cache key
+		owned := make(map[string]ownership)
+		recordSets := make(map[string][]dns.RecordResponse)
+		for _, record := range records {
+			if record.Type == dns.RecordResponseTypeTXT {
+				if parsed, ok := parseOwnership(record); ok && parsed.owner == *ownerID {
+					owned[strings.ToLower(record.Name)] = parsed
+				}
+				continue
+			}
+			key := providers.RecordKey(zoneID, record.Name, string(record.Type))
+			recordSets[key] = append(recordSets[key], record)
+		}
+
+		for _, key := range slices.Sorted(maps.Keys(recordSets)) {
+			recordSet := recordSets[key]
+			name, recordType := recordSet[0].Name, string(recordSet[0].Type)
+			var registryRecords []dns.RecordResponse
+			resource := ""
+			for _, txtName := range txtNames.names(name, recordType) {
+				if parsed, ok := owned[txtName]; ok {
+					resource = parsed.resource
+					registryRecords = append(registryRecords, parsed.record)
+				}
+			}
+			if resource == "" {
+				continue
+			}
+
+			kind, service, _ := strings.Cut(resource, "/")
+			namespace, serviceName, found := strings.Cut(service, "/")
+			switch {
+			case kind != "service" || !found:
+				fmt.Fprintf(os.Stderr, "skipping %s %s: owned by %s, greydns only manages services\n", recordType, name, resource)
+				skipped++
+				continue
+			case slices.ContainsFunc(recordSet, func(record dns.RecordResponse) bool {
+				_, managed := providers.CommentOwner(record.Comment)
+				return managed
+			}):
+				fmt.Fprintf(os.Stderr, "skipping %s %s: already managed by greydns\n", recordType, name)
+				skipped++
+				continue
+			}
+
+			comment := providers.OwnerComment(namespace, serviceName)
+			updated, commentErr := cf.SetRecordSetComment(ctx, recordSet, comment, zoneID)
+			if commentErr != nil {
+				errs = append(errs, fmt.Errorf("%s %s: %w", recordType, name, commentErr))
+				continue
+			}
+			migrated++
+			fmt.Fprintf(os.Stdout, "%s %s %s to %s/%s\n", verb, recordType, name, namespace, serviceName)
+			registerImport(ctx, providers.Record{
+				Name:     name,
+				Type:     recordType,
+				Contents: recordContents(updated),
+				TTL:      int(recordSet[0].TTL),
+				Proxied:  recordSet[0].Proxied,
+				Comment:  comment,
+			}, zoneID, updated)
+
+			if !*deleteTXT {
+				continue
+			}
+			for _, txtRecord := range registryRecords {
+				if deleteErr := cf.DeleteRecord(ctx, txtRecord.ID, zoneID); deleteErr != nil {
+					errs = append(errs, fmt.Errorf("TXT %s: %w", txtRecord.Name, deleteErr))
+				}
+			}
+		}
+	}
+	fmt.Fprintf(os.Stdout, "%d record sets %s, %d skipped\n", migrated, verb, skipped)
+	if migrated > 0 {
+		fmt.Fprintln(os.Stdout, "Annotate the owning services with greydns.io/dns and their domain before greydns reconciles them, otherwise the records are treated as orphaned.")
+	}
+
+	return errors.Join(errs...)
+}
+
+func recordContents(
+	recordSet []dns.RecordResponse,
+) []string {
+	contents := make([]string, 0, len(recordSet))
+	for _, record := range recordSet {
+		contents = append(contents, record.Content)
+	}
+
+	return contents
+}
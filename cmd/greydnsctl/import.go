@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+
+	"github.com/math280h/greydns/internal/providers"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+	"github.com/math280h/greydns/internal/registry"
+)
+
+func runImport(
+	ctx context.Context,
+	args []string,
+) error {
+	flags, common := newFlagSet("import")
+	file := flags.String(
+		"file",
+		"",
+		"YAML or JSON list of records to create, - reads stdin",
+	)
+	owner := flags.String(
+		"owner",
+		"",
+		"Service owning records without an owner in the file, as namespace/name",
+	)
+	dryRun := flags.Bool(
+		"dry-run",
+		false,
+		"Print the records that would be created without creating them",
+	)
+	_ = flags.Parse(args)
+	if *file == "" {
+		return errors.New("-file is required")
+	}
+	providers.SetDryRun(*dryRun)
+
+	var in io.Reader = os.Stdin
+	if *file != "-" {
+		importFile, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer importFile.Close()
+		in = importFile
+	}
+	entries, err := readEntries(in)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", *file, err)
+	}
+
+	s, err := connect(ctx, common)
+	if err != nil {
+		return err
+	}
+	byZone, err := s.importRecords(entries, *owner)
+	if err != nil {
+		return err
+	}
+	existingRecords, err := s.records(ctx, "")
+	if err != nil {
+		return err
+	}
+
+	verb := "created"
+	if providers.DryRun() {
+		verb = "would create"
+	}
+	var errs []error
+	created, skipped := 0, 0
+	for zoneID, records := range byZone {
+		missing := make([]providers.Record, 0, len(records))
+		for _, record := range records {
+			if _, exists := existingRecords[providers.RecordKey(zoneID, record.Name, record.Type)]; exists {
+				fmt.Fprintf(os.Stderr, "skipping %s %s: already managed by greydns\n", record.Type, record.Name)
+				skipped++
+				continue
+			}
+			// Records created by hand are left alone, greydns can adopt them instead
+			unmanaged, findErr := cf.FindUnmanagedRecords(ctx, record.Name, record.Type, zoneID)
+			if findErr != nil {
+				errs = append(errs, findErr)
+				continue
+			}
+			if len(unmanaged) > 0 {
+				fmt.Fprintf(os.Stderr, "skipping %s %s: an unmanaged record exists\n", record.Type, record.Name)
+				skipped++
+				continue
+			}
+			missing = append(missing, record)
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		recordSets, createErr := cf.CreateRecords(ctx, missing, zoneID)
+		for i, recordSet := range recordSets {
+			created++
+			fmt.Fprintf(os.Stdout, "%s %s %s\n", verb, missing[i].Type, missing[i].Name)
+			registerImport(ctx, missing[i], zoneID, recordSet)
+		}
+		if createErr != nil {
+			errs = append(errs, createErr)
+		}
+	}
+	fmt.Fprintf(os.Stdout, "%d record sets %s, %d skipped\n", created, verb, skipped)
+
+	return errors.Join(errs...)
+}
+
+// importRecords groups the entries of a file into record sets per zone ID, owned by their owner or defaultOwner.
+func (s *session) importRecords(
+	entries []recordEntry,
+	defaultOwner string,
+) (map[string][]providers.Record, error) {
+	byZone := make(map[string][]providers.Record)
+	sets := make(map[string]int)
+	for i, entry := range entries {
+		if entry.Zone == "" || entry.Name == "" || entry.Type == "" || entry.Content == "" {
+			return nil, fmt.Errorf("entry %d: zone, name, type and content are required", i+1)
+		}
+		zoneID, ok := s.zonesToNames[entry.Zone]
+		if !ok {
+			return nil, fmt.Errorf("entry %d: zone %s does not exist or is not accessible", i+1, entry.Zone)
+		}
+
+		comment := providers.CommentPrefix + entry.Owner
+		if entry.Owner == "" {
+			namespace, name, found := strings.Cut(defaultOwner, "/")
+			if !found || namespace == "" || name == "" {
+				return nil, fmt.Errorf("entry %d: no owner, set one in the file or with -owner namespace/name", i+1)
+			}
+			comment = providers.OwnerComment(namespace, name)
+		}
+
+		recordType := strings.ToUpper(entry.Type)
+		name := providers.NormalizeName(entry.Name)
+		key := providers.RecordKey(zoneID, name, recordType)
+		index, exists := sets[key]
+		if !exists {
+			sets[key] = len(byZone[zoneID])
+			byZone[zoneID] = append(byZone[zoneID], providers.Record{
+				Name:     name,
+				Type:     recordType,
+				Contents: []string{entry.Content},
+				TTL:      cf.ClampTTL(entry.TTL),
+				Proxied:  entry.Proxied,
+				Comment:  comment,
+			})
+			continue
+		}
+
+		// Entries sharing a name and type form one record set
+		record := &byZone[zoneID][index]
+		if record.Comment != comment {
+			return nil, fmt.Errorf("entry %d: %s %s has records with different owners", i+1, recordType, entry.Name)
+		}
+		record.Contents = append(record.Contents, entry.Content)
+	}
+
+	return byZone, nil
+}
+
+// registerImport adds an imported record set to the registry, records of another owner-id are not registered.
+func registerImport(
+	ctx context.Context,
+	record providers.Record,
+	zoneID string,
+	recordSet []dns.RecordResponse,
+) {
+	namespace, name, ok := providers.OwnerService(record.Comment)
+	if !ok || !registry.Enabled() || providers.DryRun() {
+		return
+	}
+
+	recordIDs := make([]string, 0, len(recordSet))
+	for _, dnsRecord := range recordSet {
+		recordIDs = append(recordIDs, dnsRecord.ID)
+	}
+	err := registry.Upsert(ctx, registry.Entry{
+		Namespace: namespace,
+		Service:   name,
+		Name:      record.Name,
+		Type:      record.Type,
+		ZoneID:    zoneID,
+		Contents:  record.Contents,
+		RecordIDs: recordIDs,
+		TTL:       record.TTL,
+		Proxied:   record.Proxied,
+		Comment:   record.Comment,
+		LastSync:  time.Now(),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to register %s %s: %v\n", record.Type, record.Name, err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const usage = `greydnsctl inspects and manages the DNS records of greydns with its credentials and config.
+
+Usage:
+  greydnsctl <command> [flags]
+
+Commands:
+  status    Print the managed records per zone, their owners and whether they match the services
+  export    Write the managed records as YAML or JSON, for backups, audits and migrations
+  import    Create the records of a YAML or JSON file with greydns ownership markers
+  plan      Print the changes greydns would make to bring the records in line with the services
+  cleanup   Delete records owned by services that no longer exist, with -orphans
+  validate  Check the greydns annotations of the services, or of a manifest, for mistakes
+  migrate-external-dns
+            Take over the records external-dns owns through its TXT registry
+
+Run greydnsctl <command> -h for the flags of a command.
+`
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr}) //nolint:reassign // Required for logging
+	// Only problems are logged, the output of a command goes to stdout
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	// Commands work out records like greydns, the events greydns would add to services are dropped
+	utils.Recorder = &record.FakeRecorder{}
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "status":
+		err = runStatus(ctx, os.Args[2:])
+	case "export":
+		err = runExport(ctx, os.Args[2:])
+	case "import":
+		err = runImport(ctx, os.Args[2:])
+	case "plan":
+		err = runPlan(ctx, os.Args[2:])
+	case "cleanup":
+		err = runCleanup(ctx, os.Args[2:])
+	case "validate":
+		err = runValidate(ctx, os.Args[2:])
+	case "migrate-external-dns":
+		err = runMigrateExternalDNS(ctx, os.Args[2:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "greydnsctl %s: %v\n", os.Args[1], err)
+		stop()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/math280h/greydns/internal/records"
+)
+
+func runPlan(
+	ctx context.Context,
+	args []string,
+) error {
+	flags, common := newFlagSet("plan")
+	zone := flags.String(
+		"zone",
+		"",
+		"Only plan the changes in this zone",
+	)
+	_ = flags.Parse(args)
+
+	s, err := connect(ctx, common)
+	if err != nil {
+		return err
+	}
+	existingRecords, err := s.records(ctx, *zone)
+	if err != nil {
+		return err
+	}
+	state, err := s.desiredState(ctx)
+	if err != nil {
+		return err
+	}
+
+	changes := records.Plan(existingRecords, state)
+	if *zone != "" {
+		zoneID := s.zonesToNames[*zone]
+		filtered := changes[:0]
+		for _, change := range changes {
+			if change.ZoneID == zoneID {
+				filtered = append(filtered, change)
+			}
+		}
+		changes = filtered
+	}
+
+	return records.WritePlan(os.Stdout, changes, s.zoneName)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+
+	"github.com/math280h/greydns/internal/providers"
+	"github.com/math280h/greydns/internal/records"
+)
+
+const (
+	statusInSync     = "in sync"
+	statusDrifted    = "drifted"
+	statusMissing    = "missing"
+	statusNotDesired = "not desired"
+	statusConflict   = "owned by another service"
+	statusForeign    = "other instance"
+)
+
+type statusRow struct {
+	zone       string
+	name       string
+	recordType string
+	contents   []string
+	owner      string
+	status     string
+}
+
+func runStatus(
+	ctx context.Context,
+	args []string,
+) error {
+	flags, common := newFlagSet("status")
+	zone := flags.String(
+		"zone",
+		"",
+		"Only show the records of this zone",
+	)
+	_ = flags.Parse(args)
+
+	s, err := connect(ctx, common)
+	if err != nil {
+		return err
+	}
+	existingRecords, err := s.records(ctx, *zone)
+	if err != nil {
+		return err
+	}
+	state, err := s.desiredState(ctx)
+	if err != nil {
+		return err
+	}
+
+	rows := slices.DeleteFunc(statusRows(existingRecords, state.Desired), func(row statusRow) bool {
+		return *zone != "" && s.zoneName(row.zone) != *zone
+	})
+	outOfSync := 0
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	currentZone := ""
+	for _, row := range rows {
+		if row.zone != currentZone {
+			if currentZone != "" {
+				fmt.Fprintln(writer)
+			}
+			currentZone = row.zone
+			fmt.Fprintf(writer, "ZONE %s\n", s.zoneName(row.zone))
+			fmt.Fprintln(writer, "NAME\tTYPE\tCONTENT\tOWNER\tSTATUS")
+		}
+		if row.status != statusInSync {
+			outOfSync++
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", row.name, row.recordType, strings.Join(row.contents, ","), row.owner, row.status)
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "\n%d records, %d not in sync\n", len(rows), outOfSync)
+
+	return nil
+}
+
+// statusRows compares the records at the provider with the desired records, sorted by zone, name and type.
+func statusRows(
+	existingRecords map[string][]dns.RecordResponse,
+	desired map[string]records.Desired,
+) []statusRow {
+	rows := make([]statusRow, 0, len(existingRecords))
+	for key, recordSet := range existingRecords {
+		row := statusRow{
+			zone:       providers.KeyZone(key),
+			name:       recordSet[0].Name,
+			recordType: string(recordSet[0].Type),
+			status:     statusInSync,
+		}
+		for _, record := range recordSet {
+			row.contents = append(row.contents, record.Content)
+		}
+		if namespace, name, owned := providers.OwnerService(recordSet[0].Comment); owned {
+			row.owner = namespace + "/" + name
+		} else {
+			// Records of another instance show their owner-id
+			row.owner, _ = providers.CommentOwner(recordSet[0].Comment)
+		}
+
+		want, ok := desired[key]
+		switch {
+		case !ownedHere(recordSet[0].Comment):
+			row.status = statusForeign
+		case !ok:
+			row.status = statusNotDesired
+		case want.Service != row.owner:
+			row.status = statusConflict
+		case records.Drifted(recordSet, want.Record):
+			row.status = statusDrifted
+		}
+		rows = append(rows, row)
+	}
+	for key, want := range desired {
+		if _, ok := existingRecords[key]; ok {
+			continue
+		}
+		rows = append(rows, statusRow{
+			zone:       providers.KeyZone(key),
+			name:       want.Record.Name,
+			recordType: want.Record.Type,
+			contents:   want.Record.Contents,
+			owner:      want.Service,
+			status:     statusMissing,
+		})
+	}
+
+	slices.SortFunc(rows, func(a, b statusRow) int {
+		return strings.Compare(a.zone+"/"+a.name+"/"+a.recordType, b.zone+"/"+b.name+"/"+b.recordType)
+	})
+
+	return rows
+}
+
+// ownedHere reports whether a record belongs to this greydns instance rather than one with another owner-id.
+func ownedHere(comment string) bool {
+	_, _, ok := providers.OwnerService(comment)
+
+	return ok
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/records"
+)
+
+func runValidate(
+	ctx context.Context,
+	args []string,
+) error {
+	flags, common := newFlagSet("validate")
+	file := flags.String(
+		"file",
+		"",
+		"Validate the services of a YAML or JSON manifest instead of the cluster, - reads stdin",
+	)
+	_ = flags.Parse(args)
+
+	var services []v1.Service
+	if *file != "" {
+		var in io.Reader = os.Stdin
+		if *file != "-" {
+			manifest, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer manifest.Close()
+			in = manifest
+		}
+		var err error
+		if services, err = readServices(in); err != nil {
+			return fmt.Errorf("failed to read %s: %w", *file, err)
+		}
+	}
+
+	s, err := connect(ctx, common)
+	if err != nil {
+		return err
+	}
+	if *file == "" {
+		list, listErr := s.clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{
+			LabelSelector: cfg.GetConfigValue("service-label-selector", ""),
+		})
+		if listErr != nil {
+			return fmt.Errorf("failed to list services: %w", listErr)
+		}
+		services = list.Items
+	}
+	existingRecords, err := s.records(ctx, "")
+	if err != nil {
+		return err
+	}
+	ingressDestination, err := cfg.GetRequiredConfigValue("ingress-destination")
+	if err != nil {
+		return err
+	}
+
+	problems := records.Validate(ctx, services, ingressDestination, s.zonesToNames, existingRecords)
+	errorCount := 0
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if len(problems) > 0 {
+		fmt.Fprintln(writer, "SERVICE\tSEVERITY\tPROBLEM")
+	}
+	for _, problem := range problems {
+		if problem.Severity == records.SeverityError {
+			errorCount++
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", problem.Service, problem.Severity, problem.Message)
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "\n%d services checked, %d errors, %d warnings\n", len(services), errorCount, len(problems)-errorCount)
+	if errorCount > 0 {
+		return errors.New("the annotations have errors")
+	}
+
+	return nil
+}
+
+// readServices decodes the services of a manifest with one or more documents, other kinds are
+// skipped and lists, e.g. from kubectl get -o yaml, are unpacked.
+func readServices(
+	in io.Reader,
+) ([]v1.Service, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(in, 4096)
+	var services []v1.Service
+	for {
+		var document json.RawMessage
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			return services, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		found, err := manifestServices(document)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, found...)
+	}
+}
+
+func manifestServices(
+	document json.RawMessage,
+) ([]v1.Service, error) {
+	var object struct {
+		Kind  string            `json:"kind"`
+		Items []json.RawMessage `json:"items"`
+	}
+	if len(document) == 0 || string(document) == "null" {
+		return nil, nil
+	}
+	if err := json.Unmarshal(document, &object); err != nil {
+		return nil, err
+	}
+
+	switch object.Kind {
+	case "Service":
+		var service v1.Service
+		if err := json.Unmarshal(document, &service); err != nil {
+			return nil, err
+		}
+		if service.Namespace == "" {
+			service.Namespace = metav1.NamespaceDefault
+		}
+		return []v1.Service{service}, nil
+	case "List", "ServiceList":
+		var services []v1.Service
+		for _, item := range object.Items {
+			found, err := manifestServices(item)
+			if err != nil {
+				return nil, err
+			}
+			services = append(services, found...)
+		}
+		return services, nil
+	default:
+		return nil, nil
+	}
+}
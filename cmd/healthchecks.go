@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/cloudflare/cloudflare-go/v4/healthchecks"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/metrics"
+	"github.com/math280h/greydns/internal/providers"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+	"github.com/math280h/greydns/internal/records"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	defaultHealthCheckSyncSeconds = 60
+)
+
+var (
+	healthCheckNamePattern = regexp.MustCompile(`[^a-zA-Z0-9_-]+`) //nolint:gochecknoglobals // Required for health checks
+)
+
+// healthCheckTarget is a health check a service asks for with its record.
+type healthCheckTarget struct {
+	spec    cf.HealthCheckSpec
+	service *v1.Service
+	record  string
+}
+
+// healthCheckState is what the health check sync remembers between rounds.
+type healthCheckState struct {
+	// Last status of every health check by ID, changes are reported as events
+	statuses map[string]healthchecks.HealthcheckStatus
+	// Zones holding health checks of greydns, checked until they hold none
+	zones map[string]bool
+}
+
+// healthCheckSyncInterval is how often health checks are provisioned and their status read, 0 disables it.
+func healthCheckSyncInterval() time.Duration {
+	seconds, err := strconv.Atoi(cfg.GetConfigValue("health-check-sync-seconds", strconv.Itoa(defaultHealthCheckSyncSeconds)))
+	if err != nil || seconds < 0 {
+		log.Error().Msgf("[Core] health-check-sync-seconds must be a non-negative integer, using %d", defaultHealthCheckSyncSeconds)
+		seconds = defaultHealthCheckSyncSeconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// watchHealthChecks keeps the health checks of services with greydns.io/health-check in line
+// with their records until ctx is done.
+func watchHealthChecks(
+	ctx context.Context,
+) error {
+	state := healthCheckState{
+		statuses: make(map[string]healthchecks.HealthcheckStatus),
+		zones:    make(map[string]bool),
+	}
+	for {
+		interval := healthCheckSyncInterval()
+		if interval > 0 {
+			syncHealthChecks(ctx, state)
+		} else {
+			// Checked again in case the interval is changed at runtime
+			interval = time.Duration(defaultHealthCheckSyncSeconds) * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+func syncHealthChecks(
+	ctx context.Context,
+	state healthCheckState,
+) {
+	defer utils.Recover("health checks", nil)
+
+	desired, owners := desiredHealthChecks()
+	for zoneID := range desired {
+		state.zones[zoneID] = true
+	}
+
+	type result struct {
+		zone, name, address string
+		healthy             bool
+	}
+	var results []result
+	for zoneID := range state.zones {
+		existing, err := cf.ListHealthChecks(ctx, zoneID)
+		if err != nil {
+			log.Error().Err(err).Msgf("[Core] Failed to list the health checks of %s", zoneName(zoneID))
+			continue
+		}
+		wanted := desired[zoneID]
+		for _, healthCheck := range existing {
+			target, ok := wanted[healthCheck.Name]
+			if !ok {
+				if healthCheckOrphaned(healthCheck, owners) {
+					if err = cf.DeleteHealthCheck(ctx, zoneID, healthCheck); err == nil {
+						delete(state.statuses, healthCheck.ID)
+					}
+				}
+				continue
+			}
+			delete(wanted, healthCheck.Name)
+			if !cf.HealthCheckMatches(healthCheck, target.spec) {
+				_ = cf.SaveHealthCheck(ctx, zoneID, healthCheck.ID, target.spec)
+			}
+			reportHealthCheck(state, healthCheck, target)
+			results = append(results, result{
+				zone:    zoneName(zoneID),
+				name:    target.record,
+				address: healthCheck.Address,
+				healthy: healthCheck.Status == healthchecks.HealthcheckStatusHealthy,
+			})
+		}
+		// The status of new health checks is read in the next round
+		for _, target := range wanted {
+			_ = cf.SaveHealthCheck(ctx, zoneID, "", target.spec)
+		}
+		if len(existing) == 0 && len(wanted) == 0 {
+			delete(state.zones, zoneID)
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	// Health checks that are gone stop being reported
+	metrics.HealthCheckHealthy.Reset()
+	for _, r := range results {
+		value := 0.0
+		if r.healthy {
+			value = 1
+		}
+		metrics.HealthCheckHealthy.WithLabelValues(r.zone, r.name, r.address).Set(value)
+	}
+}
+
+// desiredHealthChecks returns the health checks the reconciled services ask for, by zone ID
+// and health check name, and the namespace/name of every service owning a cached record.
+// Every target of a record gets a health check of its own.
+func desiredHealthChecks() (map[string]map[string]healthCheckTarget, map[string]bool) {
+	desired := make(map[string]map[string]healthCheckTarget)
+	owners := make(map[string]bool)
+	for key, recordSet := range cachedRecords() {
+		namespace, name, ok := providers.OwnerService(recordSet[0].Comment)
+		if !ok {
+			continue
+		}
+		owners[namespace+"/"+name] = true
+		if !healthChecked(recordSet[0].Type) {
+			continue
+		}
+		service, ok := appliedService(namespace + "/" + name)
+		if !ok || service.Annotations["greydns.io/health-check"] != "true" {
+			continue
+		}
+		check, err := records.ServiceHealthCheck(service)
+		if err != nil {
+			log.Warn().Err(err).Str("namespace", namespace).Str("service", name).Msg("[Core] Invalid health check annotations")
+			continue
+		}
+
+		zoneID := providers.KeyZone(key)
+		if desired[zoneID] == nil {
+			desired[zoneID] = make(map[string]healthCheckTarget)
+		}
+		contents := recordContents(recordSet)
+		slices.Sort(contents)
+		for i, address := range contents {
+			checkName := healthCheckName(recordSet[0].Name, string(recordSet[0].Type), i)
+			desired[zoneID][checkName] = healthCheckTarget{
+				spec: cf.HealthCheckSpec{
+					Name:        checkName,
+					Address:     address,
+					Description: providers.RecordComment(namespace, name, recordSet[0].Name),
+					Check:       *check,
+				},
+				service: service,
+				record:  recordSet[0].Name,
+			}
+		}
+	}
+
+	return desired, owners
+}
+
+// healthCheckOrphaned reports whether a health check nobody asks for can be deleted. Until its
+// service is reconciled, e.g. right after startup, it is kept while the service owns records.
+func healthCheckOrphaned(
+	healthCheck healthchecks.Healthcheck,
+	owners map[string]bool,
+) bool {
+	namespace, name, _ := providers.OwnerService(healthCheck.Description)
+	owner := namespace + "/" + name
+	if _, applied := appliedService(owner); applied {
+		return true
+	}
+
+	return !owners[owner]
+}
+
+func healthChecked(
+	recordType dns.RecordResponseType,
+) bool {
+	switch recordType {
+	case dns.RecordResponseTypeA, dns.RecordResponseTypeAAAA, dns.RecordResponseTypeCNAME:
+		return true
+	default:
+		return false
+	}
+}
+
+// healthCheckName names the health check of a target of a record, e.g. api-example-com-a or
+// api-example-com-a-2 for the second target.
+func healthCheckName(
+	name string,
+	recordType string,
+	index int,
+) string {
+	checkName := strings.ToLower(healthCheckNamePattern.ReplaceAllString(name+"-"+recordType, "-"))
+	if index > 0 {
+		checkName += "-" + strconv.Itoa(index+1)
+	}
+
+	return checkName
+}
+
+// reportHealthCheck emits an event on the service when its health check turns unhealthy or recovers.
+func reportHealthCheck(
+	state healthCheckState,
+	healthCheck healthchecks.Healthcheck,
+	target healthCheckTarget,
+) {
+	previous, known := state.statuses[healthCheck.ID]
+	state.statuses[healthCheck.ID] = healthCheck.Status
+	switch {
+	case healthCheck.Status == previous:
+	case healthCheck.Status == healthchecks.HealthcheckStatusUnhealthy:
+		log.Warn().Msgf("[Core] Health check of %s for %s is unhealthy: %s", healthCheck.Address, target.record, healthCheck.FailureReason)
+		utils.Recorder.Eventf(
+			target.service,
+			v1.EventTypeWarning,
+			"HealthCheckUnhealthy",
+			"Health check of %s for %s is unhealthy: %s",
+			healthCheck.Address,
+			target.record,
+			healthCheck.FailureReason,
+		)
+	case known && previous == healthchecks.HealthcheckStatusUnhealthy && healthCheck.Status == healthchecks.HealthcheckStatusHealthy:
+		log.Info().Msgf("[Core] Health check of %s for %s is healthy again", healthCheck.Address, target.record)
+		utils.Recorder.Eventf(
+			target.service,
+			v1.EventTypeNormal,
+			"HealthCheckHealthy",
+			"Health check of %s for %s is healthy again",
+			healthCheck.Address,
+			target.record,
+		)
+	}
+}
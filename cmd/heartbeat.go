@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+// writeHeartbeat refreshes the heartbeat-record TXT record with the current time, so monitoring
+// outside the cluster can tell the controller is alive and can still write to the zone.
+func writeHeartbeat(
+	ctx context.Context,
+) {
+	defer utils.Recover("heartbeat", nil)
+
+	name := strings.TrimSuffix(cfg.GetConfigValue("heartbeat-record", ""), ".")
+	if name == "" {
+		return
+	}
+	zoneID, ok := heartbeatZone(name)
+	if !ok {
+		log.Error().Msgf("[Core] No zone found for heartbeat record %s", name)
+		return
+	}
+
+	content := "heartbeat=" + time.Now().UTC().Format(time.RFC3339)
+	if ownerID := cfg.GetConfigValue("owner-id", ""); ownerID != "" {
+		content += " owner-id=" + ownerID
+	}
+	if err := cf.WriteHeartbeat(ctx, name, content, zoneID); err != nil {
+		log.Error().Err(err).Msgf("[Core] Failed to write heartbeat record %s", name)
+	}
+}
+
+// heartbeatZone finds the most specific known zone the heartbeat record belongs to.
+func heartbeatZone(
+	name string,
+) (string, bool) {
+	zoneID, longest := "", 0
+	for zone, id := range knownZones() {
+		if (name == zone || strings.HasSuffix(name, "."+zone)) && len(zone) > longest {
+			zoneID, longest = id, len(zone)
+		}
+	}
+
+	return zoneID, zoneID != ""
+}
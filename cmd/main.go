@@ -2,39 +2,97 @@ package main
 
 import (
 	"context"
+	"flag"
 	"os"
+	"os/signal"
 	"strconv"
-	"strings"
+	"syscall"
 	"time"
 
-	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/go-logr/zerologr"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/informers"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	"github.com/math280h/greydns/internal/audit"
+	"github.com/math280h/greydns/internal/certificates"
 	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/discovery"
+	"github.com/math280h/greydns/internal/notify"
+	"github.com/math280h/greydns/internal/providers"
 	cf "github.com/math280h/greydns/internal/providers/cf"
-	"github.com/math280h/greydns/internal/records"
+	"github.com/math280h/greydns/internal/providers/coredns"
+	"github.com/math280h/greydns/internal/registry"
 	"github.com/math280h/greydns/internal/utils"
-)
-
-var (
-	ingressDestination string                                //nolint:gochecknoglobals // Required for ingress destination
-	zonesToNames       = make(map[string]string)             //nolint:gochecknoglobals // Required for zones
-	existingRecords    = make(map[string]dns.RecordResponse) //nolint:gochecknoglobals // Required for existing records
+	"github.com/math280h/greydns/internal/webhook"
 )
 
 func main() { //nolint:gocognit // Required for main function
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr}) //nolint:reassign // Required for logging
 
+	migrateOwnership := flag.Bool(
+		"migrate-ownership",
+		false,
+		"Rewrite ownership markers of existing records to include the owner-id, then exit",
+	)
+	dryRun := flag.Bool(
+		"dry-run",
+		false,
+		"Log and report provider changes without executing them",
+	)
+	once := flag.Bool(
+		"once",
+		false,
+		"Reconcile every service once and exit, with a non-zero status if any failed",
+	)
+	kubeconfig := flag.String(
+		"kubeconfig",
+		"",
+		"Path to a kubeconfig file, for running outside of the cluster",
+	)
+	pprofAddr := flag.String(
+		"pprof-addr",
+		"",
+		"Serve net/http/pprof on this address, e.g. localhost:6060. Disabled when empty",
+	)
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	providers.SetDryRun(*dryRun)
+	if *pprofAddr != "" {
+		startPprof(*pprofAddr)
+	}
+
+	// Stop gracefully when the pod is terminated
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	// Create Kubernetes client
-	config, err := rest.InClusterConfig()
+	var (
+		config *rest.Config
+		err    error
+	)
+	if *kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
 	if err != nil {
 		log.Fatal().Err(err).Msg("[Core] Failed to get cluster config")
 	}
@@ -44,115 +102,350 @@ func main() { //nolint:gocognit // Required for main function
 		log.Fatal().Err(err).Msg("[Core] Failed to create clientset")
 	}
 
-	cfg.LoadConfigMap(clientset)
+	if err = cfg.LoadConfigMap(clientset); err != nil {
+		log.Fatal().Err(err).Msg("[Config] Failed to load the config")
+	}
+	cfg.ConfigureLogging()
+	if err = audit.Open(); err != nil {
+		log.Fatal().Err(err).Msg("[Audit] Failed to open the audit log")
+	}
+	notify.Start(ctx)
 
-	secret, err := clientset.CoreV1().Secrets("default").Get(context.Background(), "greydns-secret", metav1.GetOptions{})
+	credentialSource, err := cfg.NewCredentialSource(clientset)
 	if err != nil {
-		log.Fatal().Err(err).Msg("[Core] Failed to get secret")
+		log.Fatal().Err(err).Msg("[Config] Invalid credential source")
 	}
+	credentials := loadCredentials(ctx, credentialSource)
+	setAPIToken(credentials)
+	setSnapshotCredentials(credentials)
 
-	ingressDestination = cfg.GetRequiredConfigValue("ingress-destination")
+	if providers.DryRun() {
+		log.Warn().Msg("[Core] Dry-run is enabled, no DNS records will be changed")
+	}
 
 	utils.StartBroadcaster(
 		clientset,
 	)
 
 	// TODO:: Support multiple providers
-	cf.Connect(secret)
-	zonesToNames = cf.GetZoneNames()
-	existingRecords = cf.RefreshRecordsCache(
-		zonesToNames,
-	)
-	go func() {
-		for {
-			sleepTime, strconvErr := strconv.ParseInt(cfg.GetRequiredConfigValue("cache-refresh-seconds"), 0, 64)
-			if strconvErr != nil {
-				log.Fatal().Err(strconvErr).Msg("[Core] Sleep time is not a valid integer")
-			}
-			time.Sleep(time.Duration(sleepTime) * time.Second)
-			existingRecords = cf.RefreshRecordsCache(
+	cf.Connect(credentials)
+	coredns.Connect(clientset)
+	err = utils.RetryStartup("list zones", func(err error) bool {
+		return !cf.IsAuthError(err)
+	}, func() error {
+		var listErr error
+		zonesToNames, listErr = cf.GetZoneNames(ctx)
+		return listErr
+	})
+	if err != nil {
+		// Tokens scoped to single zones may not be able to list zones, those rely on greydns.io/zone-id
+		log.Error().Err(err).Msg("[Core] Failed to list zones")
+	}
+	switch cfg.GetConfigValue("registry", "") {
+	case "crd":
+		if err = registry.ConnectCRD(config); err != nil {
+			log.Fatal().Err(err).Msg("[Registry] Failed to create dynamic client")
+		}
+	case "configmap":
+		registry.ConnectConfigMap(clientset)
+	}
+	if err = certificates.Connect(config); err != nil {
+		log.Fatal().Err(err).Msg("[Certificates] Failed to create dynamic client")
+	}
+
+	if *migrateOwnership {
+		runOwnershipMigration(ctx)
+		return
+	}
+
+	// With the registry enabled the cache is rebuilt from it instead of scanning every zone
+	// A partial cache would make records look missing, so greydns does not start without a full one
+	if registry.Enabled() {
+		var entries []registry.Entry
+		err = utils.RetryStartup("list managed records", utils.Always, func() error {
+			var listErr error
+			entries, listErr = registry.List(ctx)
+			return listErr
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("[Core] Failed to list managed records")
+		}
+		setRecordCache(cf.RestoreRecordsCache(entries))
+		for _, entry := range entries {
+			audit.RestoreHistory(entry.History)
+		}
+	} else {
+		err = utils.RetryStartup("load records", utils.Always, func() error {
+			existingRecords, refreshErr := cf.RefreshRecordsCache(
+				ctx,
 				zonesToNames,
 			)
+			setRecordCache(existingRecords)
+			return refreshErr
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("[Core] Failed to load records")
 		}
-	}()
-
-	// Set up informer to watch Service resources
-	factory := informers.NewSharedInformerFactory(clientset, 30*time.Second)
-	serviceInformer := factory.Core().V1().Services().Informer()
-
-	// Define event handlers
-	_, err = serviceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			service, ok := obj.(*v1.Service)
-			if !ok {
-				log.Error().Msg("[Core] Failed to cast object")
-				return
+	}
+	if *once {
+		collectOrphans(ctx, clientset)
+		failures := runOnce(ctx, clientset)
+		stop()
+		if failures > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	watchCredentials(ctx, credentialSource)
+	webhook.Start(ctx, clientset)
+
+	shutdownTimeout, err := strconv.Atoi(cfg.GetConfigValue("shutdown-timeout-seconds", "30"))
+	if err != nil {
+		log.Error().Err(err).Msg("[Core] Shutdown timeout is not a valid integer, using 30 seconds")
+		shutdownTimeout = 30
+	}
+	gracefulShutdown := time.Duration(shutdownTimeout) * time.Second
+	// Unchanged services are queued again on every resync, which also retries services that gave up
+	resync := 30 * time.Second
+
+	serviceCache := cache.ByObject{}
+	if selector := cfg.GetConfigValue("service-label-selector", ""); selector != "" {
+		parsed, selectorErr := labels.Parse(selector)
+		if selectorErr != nil {
+			log.Fatal().Err(selectorErr).Msg("[Core] Invalid service-label-selector")
+		}
+		// Filtering on the API server keeps unrelated services out of the cache entirely
+		serviceCache.Label = parsed
+	}
+	cacheOptions := cache.Options{
+		SyncPeriod: &resync,
+		ByObject: map[client.Object]cache.ByObject{
+			&v1.Service{}: serviceCache,
+		},
+	}
+	if watched := utils.SplitList(cfg.GetConfigValue("watch-namespaces", "")); len(watched) == 1 {
+		// A single namespace is watched directly instead of filtering every service in the cluster
+		cacheOptions.DefaultNamespaces = map[string]cache.Config{watched[0]: {}}
+	}
+
+	// Every shard elects its own leader
+	leaderElectionID := "greydns-leader"
+	if count := shardCount(); count > 1 {
+		index, shardErr := shardIndex()
+		if shardErr != nil {
+			log.Fatal().Err(shardErr).Msg("[Core] Invalid shard config")
+		}
+		if index >= count {
+			log.Fatal().Msgf("[Core] Shard index %d is out of range for %d shards", index, count)
+		}
+		log.Info().Msgf("[Core] Managing shard %d of %d", index, count)
+		leaderElectionID += "-" + strconv.FormatUint(uint64(index), 10)
+	}
+
+	ctrl.SetLogger(zerologr.New(&log.Logger))
+	mgr, err := ctrl.NewManager(config, ctrl.Options{
+		Cache: cacheOptions,
+		Metrics: metricsserver.Options{
+			BindAddress: cfg.GetConfigValue("metrics-addr", ":8080"),
+		},
+		HealthProbeBindAddress:  cfg.GetConfigValue("health-probe-addr", ":8081"),
+		LeaderElection:          cfg.GetConfigValue("leader-election", "false") == "true",
+		LeaderElectionID:        leaderElectionID,
+		LeaderElectionNamespace: cfg.GetConfigValue("leader-election-namespace", "default"),
+		GracefulShutdownTimeout: &gracefulShutdown,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("[Core] Failed to create the manager")
+	}
+	if err = mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		log.Fatal().Err(err).Msg("[Core] Failed to add the health check")
+	}
+	if err = mgr.AddReadyzCheck("ping", healthz.Ping); err != nil {
+		log.Fatal().Err(err).Msg("[Core] Failed to add the ready check")
+	}
+	if err = mgr.AddReadyzCheck("cache-refresh", refreshHealth.check); err != nil {
+		log.Fatal().Err(err).Msg("[Core] Failed to add the cache refresh check")
+	}
+
+	// Reconciles get their own context so work in progress can finish after a shutdown signal,
+	// it is only cancelled once the manager has stopped
+	workerCtx, cancelWorker := context.WithCancel(context.Background())
+	defer cancelWorker()
+
+	// The leader collects orphaned records and reconciles every existing service before the
+	// controller handles its events, the lock is taken before the cache syncs so the controller
+	// cannot go first. Like every RunnableFunc it only runs once elected, so replicas never
+	// delete the same orphans
+	err = mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		reconcileLock.Lock()
+		defer reconcileLock.Unlock()
+		collectOrphans(ctx, clientset)
+		if !mgr.GetCache().WaitForCacheSync(ctx) {
+			return nil
+		}
+		reconcileAll(workerCtx, clientset, mgr.GetClient())
+		return nil
+	}))
+	if err != nil {
+		log.Fatal().Err(err).Msg("[Core] Failed to add the startup reconcile")
+	}
+
+	// Only the elected leader refreshes the cache
+	err = mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(refreshHealth.next()):
 			}
-			records.HandleAnnotations(
-				existingRecords,
-				ingressDestination,
-				zonesToNames,
-				service,
+			// Zones that failed keep their previous records and are fetched again next time
+			refreshed, refreshErr := cf.RefreshChangedZones(
+				ctx,
+				knownZones(),
 			)
-		},
-		UpdateFunc: func(oldObj, newObj interface{}) {
-			service, ok := newObj.(*v1.Service)
-			if !ok {
-				log.Error().Msg("[Core] Failed to cast object during update")
-				return
+			refreshHealth.observe(refreshErr)
+			if refreshErr != nil {
+				log.Error().Err(refreshErr).Msg("[Core] Failed to refresh some zones")
 			}
+			reconcileLock.Lock()
+			replaceZoneRecords(refreshed)
+			reconcileLock.Unlock()
+			writeHeartbeat(ctx)
+		}
+	}))
+	if err != nil {
+		log.Fatal().Err(err).Msg("[Core] Failed to add the cache refresh")
+	}
 
-			oldService, ok := oldObj.(*v1.Service)
-			if !ok {
-				log.Error().Msg("[Core] Failed to cast old object during update")
-				return
-			}
+	// The leader keeps an eye on the DNSSEC status of the zones it manages
+	if err = mgr.Add(manager.RunnableFunc(watchDNSSEC)); err != nil {
+		log.Fatal().Err(err).Msg("[Core] Failed to add the DNSSEC check")
+	}
 
-			annotationsChanged := false
-			for key, value := range service.Annotations {
-				if !strings.Contains(key, "greydns.io") {
-					continue
-				}
-				if value != oldService.Annotations[key] {
-					annotationsChanged = true
-					break
-				}
-			}
+	if err = mgr.Add(manager.RunnableFunc(watchFailures)); err != nil {
+		log.Fatal().Err(err).Msg("[Core] Failed to add the failure summary")
+	}
+	if err = mgr.Add(manager.RunnableFunc(watchConflicts)); err != nil {
+		log.Fatal().Err(err).Msg("[Core] Failed to add the conflict report")
+	}
+	if err = mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		return watchPropagation(ctx, clientset)
+	})); err != nil {
+		log.Fatal().Err(err).Msg("[Core] Failed to add the propagation check")
+	}
+	if err = mgr.Add(manager.RunnableFunc(watchHealthChecks)); err != nil {
+		log.Fatal().Err(err).Msg("[Core] Failed to add the health check sync")
+	}
+	if err = mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		return watchSnapshots(ctx, clientset, mgr.GetClient())
+	})); err != nil {
+		log.Fatal().Err(err).Msg("[Core] Failed to add the state snapshots")
+	}
 
-			if annotationsChanged {
-				log.Info().Msgf("[Core] [%s] Annotations changed, updating records", service.Name)
-				records.HandleUpdates(
-					existingRecords,
-					ingressDestination,
-					zonesToNames,
-					service,
-					oldService,
-				)
-			}
-		},
-		DeleteFunc: func(obj interface{}) {
-			service, ok := obj.(*v1.Service)
-			if !ok {
-				log.Error().Msg("[Core] Failed to cast object during delete")
+	// Services are reconciled by one worker per zone, started and stopped with the leader
+	queues := newZoneQueues(workerCtx, clientset, mgr.GetClient())
+	if err = mgr.Add(queues); err != nil {
+		log.Fatal().Err(err).Msg("[Core] Failed to add the zone workers")
+	}
+
+	// Every service is reconciled against a new config, records that changed are corrected.
+	// Only the leader runs the controller reading the events, other replicas skip the resync
+	// so the callbacks, e.g. the configmap informer, never block on them
+	configEvents := make(chan event.TypedGenericEvent[*v1.Service])
+	resyncAll := func(reason string) {
+		configChangedAt.Store(time.Now().UnixNano())
+		select {
+		case <-mgr.Elected():
+		default:
+			return
+		}
+		var services v1.ServiceList
+		if listErr := mgr.GetCache().List(ctx, &services); listErr != nil {
+			log.Error().Err(listErr).Msgf("[Core] Failed to list services after %s", reason)
+			return
+		}
+		for i := range services.Items {
+			select {
+			case configEvents <- event.TypedGenericEvent[*v1.Service]{Object: &services.Items[i]}:
+			case <-ctx.Done():
 				return
 			}
-			records.HandleDeletions(
-				existingRecords,
-				zonesToNames,
-				service,
-			)
-		},
+		}
+	}
+	cfg.WatchConfigMap(ctx, clientset, func() {
+		cfg.ApplyLogLevel()
+		providers.ResetDeletionGuard()
+		resyncAll("a config change")
+	})
+
+	// The same goes for a new address of a discovered ingress-destination
+	discovery.ConnectLoadBalancers(ctx, clientset, func() {
+		resyncAll("a load balancer address change")
 	})
+	if err = mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		return watchPublicAddress(ctx, func() {
+			resyncAll("a public address change")
+		})
+	})); err != nil {
+		log.Fatal().Err(err).Msg("[Core] Failed to add the public address check")
+	}
+
+	err = ctrl.NewControllerManagedBy(mgr).
+		Named("service").
+		For(&v1.Service{}, builder.WithPredicates(predicate.NewPredicateFuncs(filterService))).
+		WatchesRawSource(source.Channel(configEvents, &handler.TypedEnqueueRequestForObject[*v1.Service]{})).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: 1,
+		}).
+		Complete(&serviceReconciler{
+			client: mgr.GetClient(),
+			queues: queues,
+		})
 	if err != nil {
-		log.Fatal().Err(err).Msg("[Core] Failed to add event handler")
-		return
+		log.Fatal().Err(err).Msg("[Core] Failed to create the service controller")
 	}
 
-	// Start the informer
-	stopCh := make(chan struct{})
-	defer close(stopCh)
-	factory.Start(stopCh)
+	// Replicas serve the API too, they keep their cache current until they are elected
+	go refreshAsFollower(ctx, mgr.Elected())
+	startAPI(ctx, mgr.GetClient(), queues, mgr.Elected())
+
+	// Keep running until asked to stop, the manager waits up to the shutdown timeout for reconciles
+	if err = mgr.Start(ctx); err != nil {
+		log.Error().Err(err).Msg("[Core] Manager stopped")
+	}
+	cancelWorker()
+	log.Info().Msg("[Core] Shutdown complete")
+}
 
-	// Keep running
-	select {}
+func runOwnershipMigration(ctx context.Context) {
+	ownerID, err := cfg.GetRequiredConfigValue("owner-id")
+	if err != nil {
+		log.Fatal().Err(err).Msg("[Migration] Ownership migration requires an owner-id")
+	}
+
+	migrated, err := cf.MigrateOwnership(ctx, zonesToNames, ownerID)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("[Migration] Failed after migrating %d records", migrated)
+	}
+	log.Info().Msgf("[Migration] Migrated %d records to owner-id %s", migrated, ownerID)
+
+	// Keep the registry in line with the new comments
+	if !registry.Enabled() {
+		return
+	}
+	entries, err := registry.List(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("[Migration] Failed to list managed records")
+	}
+	for _, entry := range entries {
+		comment, ok := providers.MigrateComment(entry.Comment, ownerID)
+		if !ok {
+			continue
+		}
+		entry.Comment = comment
+		if err = registry.Upsert(ctx, entry); err != nil {
+			log.Error().Err(err).Msgf("[Migration] Failed to update managed record %s", entry.Name)
+		}
+	}
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/math280h/greydns/internal/audit"
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/records"
+)
+
+// runOnce reconciles every service a single time and returns the number of services that failed.
+// Without a previous state to compare against records of deleted services are not removed.
+func runOnce(
+	ctx context.Context,
+	clientset *kubernetes.Clientset,
+) int {
+	services, err := clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{
+		LabelSelector: cfg.GetConfigValue("service-label-selector", ""),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("[Core] Failed to list services")
+		return 1
+	}
+
+	ingressDestination, err := cfg.GetRequiredConfigValue("ingress-destination")
+	if err != nil {
+		log.Error().Err(err).Msg("[Core] Failed to read the config")
+		return 1
+	}
+
+	failures := 0
+	for i := range services.Items {
+		service := &services.Items[i]
+		if !namespaceAllowed(service.Namespace) {
+			continue
+		}
+
+		serviceCtx := log.With().Str("namespace", service.Namespace).Str("service", service.Name).Logger().WithContext(ctx)
+		serviceCtx = audit.WithService(serviceCtx, service.Namespace, service.Name)
+		err = records.HandleAnnotations(
+			serviceCtx,
+			zoneRecords(serviceZone(service)),
+			ingressDestination,
+			zonesToNames,
+			service,
+		)
+		if err != nil {
+			zerolog.Ctx(serviceCtx).Error().Err(err).Msg("[Core] Reconcile failed")
+			failures++
+		}
+	}
+	log.Info().Msgf("[Core] Reconciled %d services, %d failed", len(services.Items), failures)
+
+	return failures
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/math280h/greydns/internal/audit"
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/providers"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+	"github.com/math280h/greydns/internal/registry"
+)
+
+const (
+	orphansIgnore = "ignore"
+	orphansReport = "report"
+	orphansDelete = "delete"
+)
+
+// collectOrphans finds records whose service was deleted while greydns was not running and
+// reports or deletes them according to orphan-policy. It runs on the leader before any
+// reconcile, the caller must hold the reconcile lock exclusively.
+func collectOrphans(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+) {
+	policy := cfg.GetConfigValue("orphan-policy", orphansIgnore)
+	switch policy {
+	case orphansIgnore:
+		return
+	case orphansReport, orphansDelete:
+	default:
+		log.Warn().Msgf("[Core] Unknown orphan-policy %q, only reporting orphaned records", policy)
+		policy = orphansReport
+	}
+	if policy == orphansDelete && !providers.DeletionsAllowed() {
+		log.Warn().Msgf("[Core] Policy is %s, only reporting orphaned records", providers.Policy())
+		policy = orphansReport
+	}
+
+	// The API server is asked directly, the label selector must not make services look deleted
+	services, err := clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Error().Err(err).Msg("[Core] Failed to list services, skipping orphaned record collection")
+		return
+	}
+	existing := make(map[string]bool, len(services.Items))
+	for _, service := range services.Items {
+		existing[service.Namespace+"/"+service.Name] = true
+	}
+
+	orphans := 0
+	for zoneID, existingRecords := range recordsByZone {
+		var keys []string
+		for key, recordSet := range existingRecords {
+			namespace, name, ok := providers.OwnerService(recordSet[0].Comment)
+			if !ok || existing[namespace+"/"+name] || !namespaceAllowed(namespace) {
+				continue
+			}
+			// Records kept with greydns.io/on-delete: retain outlive their service on purpose
+			if providers.IsRetained(recordSet[0].Comment) {
+				continue
+			}
+			orphans++
+			if policy == orphansReport {
+				record := recordSet[0]
+				zerolog.Ctx(ownerContext(ctx, namespace, name)).Warn().Msgf("[Core] %s record %s belongs to a service that no longer exists", record.Type, record.Name)
+				continue
+			}
+			keys = append(keys, key)
+		}
+		if len(keys) == 0 {
+			continue
+		}
+
+		// The orphans of a zone are deleted together in as few batches as possible
+		recordSets := make([][]dns.RecordResponse, 0, len(keys))
+		for _, key := range keys {
+			recordSets = append(recordSets, existingRecords[key])
+		}
+		deleted, deleteErr := cf.DeleteRecordSets(ctx, recordSets, zoneID)
+		if deleteErr != nil {
+			log.Error().Err(deleteErr).Msgf("[Core] Failed to delete %d orphaned records in zone %s", len(keys)-deleted, zoneID)
+		}
+		for _, key := range keys[:deleted] {
+			record := existingRecords[key][0]
+			namespace, name, _ := providers.OwnerService(record.Comment)
+			serviceCtx := ownerContext(ctx, namespace, name)
+			zerolog.Ctx(serviceCtx).Info().Msgf("[Core] Deleted orphaned %s record %s", record.Type, record.Name)
+			delete(existingRecords, key)
+			if err = registry.Remove(serviceCtx, namespace, record.Name, string(record.Type)); err != nil {
+				zerolog.Ctx(serviceCtx).Error().Err(err).Msgf("[Core] Failed to unregister orphaned %s record", record.Type)
+			}
+		}
+		snapshotZoneRecords(zoneID, existingRecords)
+	}
+	log.Info().Msgf("[Core] Found %d orphaned records", orphans)
+}
+
+// ownerContext logs and audits changes as made for the service that owned a record.
+func ownerContext(
+	ctx context.Context,
+	namespace string,
+	name string,
+) context.Context {
+	ctx = log.With().Str("namespace", namespace).Str("service", name).Logger().WithContext(ctx)
+
+	return audit.WithService(ctx, namespace, name)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// startPprof serves the runtime profiles on addr, e.g. localhost:6060, for diagnosing memory and
+// goroutine issues in long running deployments.
+func startPprof(
+	addr string,
+) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Info().Msgf("[Core] Serving pprof on %s", addr)
+		if err := server.ListenAndServe(); err != nil {
+			log.Error().Err(err).Msg("[Core] pprof server stopped")
+		}
+	}()
+}
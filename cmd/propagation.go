@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/metrics"
+	"github.com/math280h/greydns/internal/providers"
+	"github.com/math280h/greydns/internal/records"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	defaultPropagationCheckSeconds = 300
+	// Written by greydns, changing it is not a change of the service's records
+	propagationAnnotation = "greydns.io/propagation"
+	propagated            = "propagated"
+)
+
+// propagationResolvers returns the resolvers records are checked against as host:port, keyed by
+// the configured entry. Entries without a port use 53.
+func propagationResolvers() map[string]string {
+	resolvers := make(map[string]string)
+	for _, entry := range strings.Split(cfg.GetConfigValue("propagation-resolvers", ""), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(entry); err == nil {
+			resolvers[entry] = entry
+		} else {
+			resolvers[entry] = net.JoinHostPort(entry, "53")
+		}
+	}
+
+	return resolvers
+}
+
+func propagationCheckInterval() time.Duration {
+	seconds, err := strconv.Atoi(cfg.GetConfigValue("propagation-check-seconds", strconv.Itoa(defaultPropagationCheckSeconds)))
+	if err != nil || seconds <= 0 {
+		log.Error().Msgf("[Core] propagation-check-seconds must be a positive integer, using %d", defaultPropagationCheckSeconds)
+		seconds = defaultPropagationCheckSeconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// watchPropagation checks the managed records against propagation-resolvers until ctx is done.
+func watchPropagation(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+) error {
+	for {
+		// Read every round so resolvers can be added or removed at runtime
+		if resolvers := propagationResolvers(); len(resolvers) > 0 {
+			checkPropagation(ctx, clientset, resolvers)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(propagationCheckInterval()):
+		}
+	}
+}
+
+func checkPropagation(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	resolvers map[string]string,
+) {
+	defer utils.Recover("propagation", nil)
+
+	type result struct {
+		zone, name, recordType, resolver string
+		ok                               bool
+	}
+	var results []result
+	// Resolvers still missing a record of a service, by namespace/name
+	pending := make(map[string][]string)
+	for key, recordSet := range cachedRecords() {
+		namespace, name, ok := providers.OwnerService(recordSet[0].Comment)
+		if !ok || !propagationChecked(recordSet[0].Type) {
+			continue
+		}
+		owner := namespace + "/" + name
+		if _, known := pending[owner]; !known {
+			pending[owner] = nil
+		}
+		record := providers.Record{
+			Name:     recordSet[0].Name,
+			Type:     string(recordSet[0].Type),
+			Contents: recordContents(recordSet),
+			Proxied:  recordSet[0].Proxied,
+		}
+		for resolver, ok := range resolveAll(ctx, resolvers, record) {
+			results = append(results, result{
+				zone:       zoneName(providers.KeyZone(key)),
+				name:       record.Name,
+				recordType: record.Type,
+				resolver:   resolver,
+				ok:         ok,
+			})
+			if !ok && !slices.Contains(pending[owner], resolver) {
+				pending[owner] = append(pending[owner], resolver)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	// Records and resolvers that are gone stop being reported
+	metrics.RecordPropagated.Reset()
+	for _, r := range results {
+		value := 0.0
+		if r.ok {
+			value = 1
+		}
+		metrics.RecordPropagated.WithLabelValues(r.zone, r.name, r.recordType, r.resolver).Set(value)
+	}
+	for owner, missing := range pending {
+		status := propagated
+		if len(missing) > 0 {
+			slices.Sort(missing)
+			status = "pending on " + strings.Join(missing, ",")
+		}
+		if service, ok := appliedService(owner); ok {
+			setPropagationStatus(ctx, clientset, service, status)
+		}
+	}
+}
+
+func propagationChecked(
+	recordType dns.RecordResponseType,
+) bool {
+	switch recordType {
+	case dns.RecordResponseTypeA, dns.RecordResponseTypeAAAA, dns.RecordResponseTypeCNAME, dns.RecordResponseTypeTXT:
+		return true
+	default:
+		return false
+	}
+}
+
+// resolveAll asks every resolver for the record in parallel and reports which answered as expected.
+func resolveAll(
+	ctx context.Context,
+	resolvers map[string]string,
+	record providers.Record,
+) map[string]bool {
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		results = make(map[string]bool, len(resolvers))
+	)
+	for resolver, address := range resolvers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			answer, err := records.Resolve(ctx, address, record)
+			ok := err == nil && records.Resolved(answer, record)
+			if !ok {
+				log.Debug().Err(err).Msgf("[Core] %s record %s not propagated to %s, answer %v", record.Type, record.Name, resolver, answer)
+			}
+			lock.Lock()
+			results[resolver] = ok
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+func recordContents(
+	recordSet []dns.RecordResponse,
+) []string {
+	contents := make([]string, 0, len(recordSet))
+	for _, record := range recordSet {
+		contents = append(contents, record.Content)
+	}
+
+	return contents
+}
+
+// setPropagationStatus stores the propagation status in an annotation of the service when it changed.
+func setPropagationStatus(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	service *v1.Service,
+	status string,
+) {
+	if service.Annotations[propagationAnnotation] == status || providers.DryRun() {
+		return
+	}
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{propagationAnnotation: status},
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	callCtx, cancel := providers.WithTimeout(ctx)
+	defer cancel()
+	_, err = clientset.CoreV1().Services(service.Namespace).Patch(callCtx, service.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		log.Error().Err(err).Str("namespace", service.Namespace).Str("service", service.Name).Msg("[Core] Failed to set the propagation status")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/discovery"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	defaultPublicIPCheckSeconds = 300
+)
+
+// publicIPCheckInterval is how often the discovered public address is looked up again, 0 disables it.
+func publicIPCheckInterval() time.Duration {
+	seconds, err := strconv.Atoi(cfg.GetConfigValue("public-ip-check-seconds", strconv.Itoa(defaultPublicIPCheckSeconds)))
+	if err != nil || seconds < 0 {
+		log.Error().Msgf("[Core] public-ip-check-seconds must be a non-negative integer, using %d", defaultPublicIPCheckSeconds)
+		seconds = defaultPublicIPCheckSeconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// watchPublicAddress looks up the public address of ingress-destination: auto again until ctx
+// is done and calls changed when it moved, e.g. after the ISP assigned a new one.
+func watchPublicAddress(
+	ctx context.Context,
+	changed func(),
+) error {
+	for {
+		interval := publicIPCheckInterval()
+		if interval > 0 {
+			if checkPublicAddress(ctx) {
+				changed()
+			}
+		} else {
+			// Checked again in case the interval is changed at runtime
+			interval = time.Duration(defaultPublicIPCheckSeconds) * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+func checkPublicAddress(
+	ctx context.Context,
+) (changed bool) {
+	defer utils.Recover("public address", nil)
+
+	if !discovery.Refresh(ctx) {
+		return false
+	}
+	utils.Recorder.Eventf(
+		cfg.ConfigMapReference(),
+		v1.EventTypeNormal,
+		"PublicAddressChanged",
+		"The public address changed, records of ingress-destination: auto are updated",
+	)
+
+	return true
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/math280h/greydns/internal/audit"
+	"github.com/math280h/greydns/internal/certificates"
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/metrics"
+	"github.com/math280h/greydns/internal/notify"
+	"github.com/math280h/greydns/internal/providers"
+	"github.com/math280h/greydns/internal/records"
+	"github.com/math280h/greydns/internal/registry"
+)
+
+const (
+	defaultMaxRetries        = 15
+	defaultRetryBaseDelay    = 1 * time.Second
+	defaultRetryMaxDelaySecs = 300
+	maxRecentErrors          = 50
+)
+
+var (
+	// lastApplied holds the last successfully reconciled version of every service, it is what
+	// updates are compared against and what deletions clean up after.
+	lastApplied = make(map[string]*v1.Service) //nolint:gochecknoglobals // Required for the reconcile loop
+	// lastReconciled is when a service was last fully reconciled, used by the periodic full reconcile.
+	lastReconciled = make(map[string]time.Time) //nolint:gochecknoglobals // Required for the reconcile loop
+	// configChangedAt is when the configmap or the discovered public address last changed, services
+	// reconciled before it are reconciled again.
+	configChangedAt atomic.Int64 //nolint:gochecknoglobals // Required for the reconcile loop
+	// failures counts the consecutive failed reconciles of every service for max-retries.
+	failures = make(map[string]int) //nolint:gochecknoglobals // Required for the reconcile loop
+	// resyncRequested holds services whose records are checked on their next reconcile, even without changes.
+	resyncRequested = make(map[string]bool) //nolint:gochecknoglobals // Required for the reconcile loop
+	// failing holds the services whose last reconcile failed, with the time of their first failure
+	// in a row and the last error, for the failure summary.
+	failing = make(map[string]reconcileError) //nolint:gochecknoglobals // Required for the reconcile loop
+	// syncReported holds whether the registry entries of a service were last marked synced, so the
+	// registry is only written when the outcome of a reconcile changes.
+	syncReported = make(map[string]bool) //nolint:gochecknoglobals // Required for the reconcile loop
+	// movedFrom holds the zone a service that moved to another zone was already cleaned up in.
+	movedFrom = make(map[string]string) //nolint:gochecknoglobals // Required for the reconcile loop
+	// recentErrors holds the last failed reconciles, newest last, for the dashboard.
+	recentErrors []reconcileError //nolint:gochecknoglobals // Required for the reconcile loop
+	// stateLock guards the state above except configChangedAt, zone workers run concurrently.
+	stateLock sync.Mutex //nolint:gochecknoglobals // Required for the reconcile loop
+	// reconcileLock is held shared by every reconcile and exclusively by everything that replaces
+	// the record cache or has to run alone.
+	reconcileLock sync.RWMutex //nolint:gochecknoglobals // Required for the reconcile loop
+)
+
+type reconcileError struct {
+	At      time.Time
+	Service string
+	Message string
+}
+
+// serviceReconciler hands every service to the worker of its zone.
+type serviceReconciler struct {
+	client client.Client
+	queues *zoneQueues
+}
+
+// newRateLimiter retries failed services with exponential backoff from one second up to
+// retry-max-delay-seconds.
+func newRateLimiter() workqueue.TypedRateLimiter[ctrl.Request] {
+	maxDelay, err := strconv.Atoi(cfg.GetConfigValue("retry-max-delay-seconds", strconv.Itoa(defaultRetryMaxDelaySecs)))
+	if err != nil || maxDelay <= 0 {
+		log.Error().Msgf("[Core] retry-max-delay-seconds must be a positive integer, using %d", defaultRetryMaxDelaySecs)
+		maxDelay = defaultRetryMaxDelaySecs
+	}
+
+	return workqueue.NewTypedItemExponentialFailureRateLimiter[ctrl.Request](
+		defaultRetryBaseDelay,
+		time.Duration(maxDelay)*time.Second,
+	)
+}
+
+func maxRetries() int {
+	retries, err := strconv.Atoi(cfg.GetConfigValue("max-retries", strconv.Itoa(defaultMaxRetries)))
+	if err != nil || retries < 0 {
+		log.Error().Msgf("[Core] max-retries must be a non-negative integer, using %d", defaultMaxRetries)
+		return defaultMaxRetries
+	}
+
+	return retries
+}
+
+func annotationsChanged(
+	service *v1.Service,
+	oldService *v1.Service,
+) bool {
+	for key, value := range service.Annotations {
+		if !strings.Contains(key, "greydns.io") || key == propagationAnnotation {
+			continue
+		}
+		if value != oldService.Annotations[key] {
+			return true
+		}
+	}
+	// Removed annotations are changes as well
+	for key := range oldService.Annotations {
+		if !strings.Contains(key, "greydns.io") || key == propagationAnnotation {
+			continue
+		}
+		if _, ok := service.Annotations[key]; !ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fullReconcileDue reports whether an unchanged service should be reconciled again, so records
+// deleted or changed at the provider or affected by a config change are corrected without
+// waiting for a service event.
+func fullReconcileDue(
+	key string,
+) bool {
+	stateLock.Lock()
+	reconciled := lastReconciled[key]
+	requested := resyncRequested[key]
+	delete(resyncRequested, key)
+	stateLock.Unlock()
+	if requested || reconciled.UnixNano() < configChangedAt.Load() {
+		return true
+	}
+
+	seconds, err := strconv.Atoi(cfg.GetConfigValue("full-reconcile-seconds", "600"))
+	if err != nil {
+		log.Error().Err(err).Msg("[Core] Full reconcile interval is not a valid integer")
+		return false
+	}
+	if seconds <= 0 {
+		return false
+	}
+
+	return time.Since(reconciled) >= time.Duration(seconds)*time.Second
+}
+
+func appliedService(
+	key string,
+) (*v1.Service, bool) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	service, ok := lastApplied[key]
+
+	return service, ok
+}
+
+// setApplied records a reconciled service, reconciled is false when the records were not checked.
+func setApplied(
+	key string,
+	service *v1.Service,
+	reconciled bool,
+) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	lastApplied[key] = service
+	delete(movedFrom, key)
+	if reconciled {
+		lastReconciled[key] = time.Now()
+	}
+}
+
+func forgetService(
+	key string,
+) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	delete(lastApplied, key)
+	delete(lastReconciled, key)
+	delete(resyncRequested, key)
+	delete(failing, key)
+	delete(syncReported, key)
+	delete(movedFrom, key)
+}
+
+// movedZone returns the zone a service left while its records there are not cleaned up yet.
+func movedZone(
+	key string,
+	zone string,
+) (string, bool) {
+	oldService, ok := appliedService(key)
+	if !ok || oldService.Annotations["greydns.io/dns"] != "true" {
+		return "", false
+	}
+	oldZone := serviceZone(oldService)
+	stateLock.Lock()
+	defer stateLock.Unlock()
+
+	return oldZone, oldZone != zone && movedFrom[key] != oldZone
+}
+
+func setMovedFrom(
+	key string,
+	zone string,
+) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	movedFrom[key] = zone
+}
+
+// requestResync makes the next reconcile of a service check its records even without changes.
+func requestResync(
+	key string,
+) {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	resyncRequested[key] = true
+}
+
+// recordFailure tracks the consecutive failures of a service and reports whether it should be retried.
+func recordFailure(
+	key string,
+	err error,
+) bool {
+	stateLock.Lock()
+	defer stateLock.Unlock()
+	if err == nil {
+		delete(failures, key)
+		delete(failing, key)
+		return false
+	}
+
+	since := time.Now()
+	if previous, ok := failing[key]; ok {
+		since = previous.At
+	}
+	failing[key] = reconcileError{At: since, Service: key, Message: err.Error()}
+
+	recentErrors = append(recentErrors, reconcileError{At: time.Now(), Service: key, Message: err.Error()})
+	if len(recentErrors) > maxRecentErrors {
+		recentErrors = recentErrors[len(recentErrors)-maxRecentErrors:]
+	}
+	category := providers.Classify(err)
+	// Throttling says nothing about the service, it is retried until the provider accepts calls again
+	if category != providers.CategoryRateLimit {
+		failures[key]++
+	}
+	switch {
+	case category == providers.CategoryAuth || category == providers.CategoryConflict || category == providers.CategoryPermanent:
+		// Retrying cannot help until the credentials, the records or the service are changed
+		log.Error().Err(err).Str("key", key).Str("category", string(category)).Msg("[Core] Reconcile failed, not retrying until the next resync")
+	case failures[key] > maxRetries():
+		// The next resync queues the service again
+		log.Error().Err(err).Str("key", key).Msgf("[Core] Reconcile failed %d times, giving up until the next resync", failures[key])
+	default:
+		log.Warn().Err(err).Str("key", key).Str("category", string(category)).Msgf("[Core] Reconcile failed, retry %d", failures[key])
+		return true
+	}
+	notify.Publish(notify.Event{
+		Kind:  notify.KindFailure,
+		Name:  key,
+		Error: err.Error(),
+	})
+	delete(failures, key)
+
+	return false
+}
+
+// reportSynced marks the registry entries of a service synced or failed when the outcome of its
+// reconcile differs from the last one reported.
+func reportSynced(
+	ctx context.Context,
+	name types.NamespacedName,
+	err error,
+) {
+	if !registry.Enabled() {
+		return
+	}
+	key := name.String()
+	stateLock.Lock()
+	synced, reported := syncReported[key]
+	syncReported[key] = err == nil
+	stateLock.Unlock()
+	if reported && synced == (err == nil) {
+		return
+	}
+
+	if statusErr := registry.SetSynced(ctx, name.Namespace, name.Name, err); statusErr != nil {
+		log.Error().Err(statusErr).Str("key", key).Msg("[Registry] Failed to update the Synced condition")
+		stateLock.Lock()
+		delete(syncReported, key)
+		stateLock.Unlock()
+	}
+}
+
+// reconcileService brings the records of the service in line with its annotations, or removes
+// them once the service is gone. Only the records of the given zone are touched, services that
+// belong to another zone return errZoneChanged.
+func reconcileService(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	reader client.Reader,
+	name types.NamespacedName,
+	zone string,
+) error {
+	key := name.String()
+	ctx = log.With().Str("namespace", name.Namespace).Str("service", name.Name).Logger().WithContext(ctx)
+	ctx = audit.WithService(ctx, name.Namespace, name.Name)
+
+	existingRecords := zoneRecords(zone)
+	zones := knownZones()
+	defer rememberZones(zones)
+	defer observeZoneRecords(zone, existingRecords)
+	defer snapshotZoneRecords(zone, existingRecords)
+
+	service := &v1.Service{}
+	err := reader.Get(ctx, name, service)
+	if k8serrors.IsNotFound(err) {
+		oldService, ok := appliedService(key)
+		if !ok {
+			return nil
+		}
+		if serviceZone(oldService) != zone {
+			return errZoneChanged
+		}
+		if err = records.HandleDeletions(ctx, existingRecords, zones, oldService); err != nil {
+			return err
+		}
+		forgetService(key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current := serviceZone(service); current != zone {
+		// Records left behind in this zone are removed by its worker before the service moves on
+		if oldZone, moved := movedZone(key, current); moved && oldZone == zone {
+			if err = records.HandleZoneChange(ctx, existingRecords, zone, service); err != nil {
+				return err
+			}
+			setMovedFrom(key, zone)
+		}
+		return errZoneChanged
+	}
+
+	// The finalizer keeps a deleted service around until its records are gone
+	if !service.DeletionTimestamp.IsZero() {
+		return finalize(ctx, clientset, key, service, existingRecords, zones)
+	}
+	managed := service.Annotations["greydns.io/dns"] == "true"
+	ingressDestination, err := cfg.GetRequiredConfigValue("ingress-destination")
+	if err != nil {
+		return err
+	}
+
+	oldService, ok := appliedService(key)
+	switch {
+	case !ok:
+		err = records.HandleAnnotations(
+			ctx,
+			existingRecords,
+			ingressDestination,
+			zones,
+			service,
+		)
+	case annotationsChanged(service, oldService):
+		zerolog.Ctx(ctx).Info().Msg("[Core] Annotations changed, updating records")
+		err = records.HandleUpdates(
+			ctx,
+			existingRecords,
+			ingressDestination,
+			zones,
+			service,
+			oldService,
+		)
+	case fullReconcileDue(key):
+		zerolog.Ctx(ctx).Debug().Msg("[Core] Running full reconcile")
+		err = records.HandleAnnotations(
+			ctx,
+			existingRecords,
+			ingressDestination,
+			zones,
+			service,
+		)
+	default:
+		setApplied(key, service, false)
+		return setFinalizer(ctx, clientset, service, managed && finalizersEnabled())
+	}
+	if err != nil {
+		return err
+	}
+
+	setApplied(key, service, true)
+	metrics.ZoneLastReconcile.WithLabelValues(zoneName(zone)).SetToCurrentTime()
+	syncCertificate(ctx, service, oldService)
+	// The finalizer is only added once the records were reconciled, a failed first reconcile
+	// leaves nothing to clean up. A service that disabled DNS no longer has records to clean up
+	return setFinalizer(ctx, clientset, service, managed && finalizersEnabled())
+}
+
+// syncCertificate keeps the cert-manager Certificate of a service with greydns.io/certificate
+// in line with its domain, and removes it once the previous version of the service was the
+// last to ask for it.
+func syncCertificate(
+	ctx context.Context,
+	service *v1.Service,
+	oldService *v1.Service,
+) {
+	wantsCertificate := func(service *v1.Service) bool {
+		return service != nil && service.Annotations["greydns.io/dns"] == "true" && service.Annotations["greydns.io/certificate"] == "true"
+	}
+	wanted := wantsCertificate(service)
+	if !wanted && !wantsCertificate(oldService) {
+		return
+	}
+	domain := ""
+	if wanted {
+		var err error
+		if domain, err = records.ServiceDomain(ctx, service); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("[Core] Failed to get the domain of the certificate")
+			return
+		}
+	}
+	certificates.Sync(ctx, service, domain)
+}
+
+func (r *serviceReconciler) Reconcile(
+	ctx context.Context,
+	request ctrl.Request,
+) (ctrl.Result, error) {
+	if zone, ok := routeZone(ctx, r.client, request.NamespacedName); ok {
+		r.queues.add(zone, request)
+	}
+
+	return ctrl.Result{}, nil
+}
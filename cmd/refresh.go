@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/rs/zerolog/log"
+
+	"github.com/math280h/greydns/internal/audit"
+	cfg "github.com/math280h/greydns/internal/config"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+	"github.com/math280h/greydns/internal/registry"
+)
+
+const (
+	defaultRefreshSeconds = 300
+	defaultRefreshJitter  = 10
+	// Consecutive failed refreshes before the ready check fails
+	maxRefreshFailures = 3
+	// A throttled refresh doubles the interval, up to 2^maxRefreshBackoff times the configured one
+	maxRefreshBackoff = 4
+)
+
+var (
+	refreshHealth = &refreshState{} //nolint:gochecknoglobals // Required for the cache refresh
+	// followerRefreshedAt is when a replica that is not the leader last refreshed its cache, in
+	// Unix nanoseconds, and 0 on the leader, whose cache follows its own reconciles
+	followerRefreshedAt atomic.Int64 //nolint:gochecknoglobals // Required for the cache refresh
+)
+
+// refreshInterval reads cache-refresh-seconds, an invalid value is logged instead of stopping the refresh.
+func refreshInterval() time.Duration {
+	value, err := cfg.GetRequiredConfigValue("cache-refresh-seconds")
+	if err != nil {
+		log.Error().Err(err).Msgf("[Core] Using a cache refresh of %d seconds", defaultRefreshSeconds)
+		return defaultRefreshSeconds * time.Second
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		log.Error().Msgf("[Core] cache-refresh-seconds %q is not a positive integer, using %d", value, defaultRefreshSeconds)
+		return defaultRefreshSeconds * time.Second
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// refreshJitter is the share of the interval, in percent, the next refresh is randomly moved by,
+// so replicas started together do not refresh at the same time.
+func refreshJitter() int {
+	percent, err := strconv.Atoi(cfg.GetConfigValue("cache-refresh-jitter-percent", strconv.Itoa(defaultRefreshJitter)))
+	if err != nil || percent < 0 || percent > 100 {
+		log.Error().Msgf("[Core] cache-refresh-jitter-percent must be between 0 and 100, using %d", defaultRefreshJitter)
+		return defaultRefreshJitter
+	}
+
+	return percent
+}
+
+// refreshState tracks failed cache refreshes so they surface on the ready check and slow
+// the refresh down while the provider is throttling.
+type refreshState struct {
+	lock      sync.Mutex
+	failures  int
+	throttled int
+	lastErr   error
+}
+
+func (s *refreshState) observe(
+	err error,
+) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if cf.IsRateLimitError(err) {
+		s.throttled = min(s.throttled+1, maxRefreshBackoff)
+	} else {
+		s.throttled = 0
+	}
+	if err == nil {
+		s.failures = 0
+		s.lastErr = nil
+		return
+	}
+	s.failures++
+	s.lastErr = err
+}
+
+// next is the time until the next refresh, the interval backed off while throttled and jittered.
+func (s *refreshState) next() time.Duration {
+	s.lock.Lock()
+	throttled := s.throttled
+	s.lock.Unlock()
+
+	interval := refreshInterval() << throttled
+	if throttled > 0 {
+		log.Warn().Msgf("[Core] The provider is throttling, next cache refresh in %s", interval)
+	}
+	spread := int64(interval) * int64(refreshJitter()) / 100
+	if spread == 0 {
+		return interval
+	}
+
+	return interval + time.Duration(rand.Int64N(2*spread+1)-spread) //nolint:gosec // Jitter needs no secure randomness
+}
+
+func (s *refreshState) check(
+	_ *http.Request,
+) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.failures < maxRefreshFailures {
+		return nil
+	}
+
+	return fmt.Errorf("the last %d cache refreshes failed: %w", s.failures, s.lastErr)
+}
+
+// refreshAsFollower keeps the record cache of a replica that is not the leader current for the
+// API and the dashboard until it is elected, the leader refreshes its cache itself.
+func refreshAsFollower(
+	ctx context.Context,
+	elected <-chan struct{},
+) {
+	select {
+	case <-elected:
+		return
+	default:
+	}
+	// The cache was loaded on startup
+	followerRefreshedAt.Store(time.Now().UnixNano())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-elected:
+			followerRefreshedAt.Store(0)
+			return
+		case <-time.After(refreshHealth.next()):
+		}
+
+		existingRecords, err := followerRecords(ctx)
+		refreshHealth.observe(err)
+		if err != nil {
+			// A partial cache would make records look missing once this replica is elected
+			log.Error().Err(err).Msg("[Core] Failed to refresh the cache of this replica, keeping the previous records")
+			continue
+		}
+		reconcileLock.Lock()
+		select {
+		case <-elected:
+			// The cache belongs to the leader's reconciles now
+			reconcileLock.Unlock()
+			followerRefreshedAt.Store(0)
+			return
+		default:
+		}
+		setRecordCache(existingRecords)
+		reconcileLock.Unlock()
+		followerRefreshedAt.Store(time.Now().UnixNano())
+	}
+}
+
+// followerRecords reads the records the leader manages. With a registry they are rebuilt from it,
+// which the leader updates after every change, together with their change history. Otherwise
+// every zone is fetched from the provider.
+func followerRecords(
+	ctx context.Context,
+) (map[string][]dns.RecordResponse, error) {
+	if !registry.Enabled() {
+		return cf.RefreshRecordsCache(ctx, knownZones())
+	}
+
+	entries, err := registry.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		audit.RestoreHistory(entry.History)
+	}
+
+	return cf.RestoreRecordsCache(entries), nil
+}
+
+// followerCacheTime is when the cache of a replica that is not the leader was last refreshed, and
+// the zero time on the leader.
+func followerCacheTime() time.Time {
+	refreshedAt := followerRefreshedAt.Load()
+	if refreshedAt == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, refreshedAt)
+}
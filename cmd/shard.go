@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	cfg "github.com/math280h/greydns/internal/config"
+)
+
+// shardCount is the number of replicas services are sharded across by namespace, 1 disables sharding.
+func shardCount() uint32 {
+	count, err := strconv.ParseUint(cfg.GetConfigValue("shard-count", "1"), 10, 32)
+	if err != nil || count == 0 {
+		log.Error().Msg("[Core] shard-count must be a positive integer, disabling sharding")
+		return 1
+	}
+
+	return uint32(count)
+}
+
+// shardIndex is the shard of this replica, taken from shard-index or otherwise from the ordinal
+// of a StatefulSet pod name such as greydns-2.
+func shardIndex() (uint32, error) {
+	value := cfg.GetConfigValue("shard-index", "")
+	if value == "" {
+		hostname, _ := os.Hostname()
+		value = hostname[strings.LastIndex(hostname, "-")+1:]
+	}
+
+	index, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("failed to determine the shard index, set shard-index: %w", err)
+	}
+
+	return uint32(index), nil
+}
+
+// shardOwns reports whether this replica manages the services of a namespace.
+func shardOwns(
+	namespace string,
+) bool {
+	count := shardCount()
+	if count == 1 {
+		return true
+	}
+
+	// Sharding enabled at runtime without a usable index leaves every namespace alone
+	index, err := shardIndex()
+	if err != nil {
+		log.Error().Err(err).Msgf("[Core] Skipping namespace %s", namespace)
+		return false
+	}
+
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(namespace))
+
+	return hash.Sum32()%count == index
+}
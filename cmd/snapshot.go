@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/providers"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	defaultSnapshotSeconds = 3600
+	snapshotKeyKey         = "snapshot-signing-key"
+	snapshotTokenKey       = "snapshot-url-token"
+	snapshotConfigMapKey   = "snapshot.json"
+)
+
+var (
+	snapshotKey   atomic.Pointer[ed25519.PrivateKey] //nolint:gochecknoglobals // Required for snapshots
+	snapshotToken atomic.Pointer[[]byte]             //nolint:gochecknoglobals // Required for snapshots
+)
+
+type snapshotRecord struct {
+	Zone     string   `json:"zone"`
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Contents []string `json:"contents"`
+	TTL      int      `json:"ttl"`
+	Proxied  bool     `json:"proxied"`
+	Owner    string   `json:"owner"`
+	IDs      []string `json:"ids,omitempty"`
+}
+
+// stateSnapshot is what greydns wants the records to be and what they are at the provider.
+type stateSnapshot struct {
+	GeneratedAt time.Time        `json:"generatedAt"`
+	OwnerID     string           `json:"ownerId,omitempty"`
+	Desired     []snapshotRecord `json:"desired"`
+	Actual      []snapshotRecord `json:"actual"`
+}
+
+// signedSnapshot carries the snapshot as the exact JSON that was signed, Signature is empty without a key.
+// The public key is not included, a snapshot could be re-signed with any key otherwise. KeyID names
+// the key auditors verify with, the SHA-256 of its DER encoding as openssl prints it.
+type signedSnapshot struct {
+	Snapshot  string `json:"snapshot"`
+	Algorithm string `json:"algorithm,omitempty"`
+	KeyID     string `json:"keyId,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// setSnapshotCredentials takes the Ed25519 signing key, a PKCS #8 PEM block, and the upload
+// token from the credentials. Without a key snapshots are written unsigned.
+func setSnapshotCredentials(
+	credentials cfg.Credentials,
+) {
+	token := credentials[snapshotTokenKey]
+	snapshotToken.Store(&token)
+
+	content := credentials[snapshotKeyKey]
+	if len(content) == 0 {
+		snapshotKey.Store(nil)
+		return
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		log.Error().Msgf("[Core] %s is not a PEM block, snapshots are not signed", snapshotKeyKey)
+		snapshotKey.Store(nil)
+		return
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	key, ok := parsed.(ed25519.PrivateKey)
+	if err != nil || !ok {
+		log.Error().Err(err).Msgf("[Core] %s is not an Ed25519 private key, snapshots are not signed", snapshotKeyKey)
+		snapshotKey.Store(nil)
+		return
+	}
+	snapshotKey.Store(&key)
+}
+
+func snapshotInterval() time.Duration {
+	seconds, err := strconv.Atoi(cfg.GetConfigValue("state-snapshot-seconds", strconv.Itoa(defaultSnapshotSeconds)))
+	if err != nil || seconds <= 0 {
+		log.Error().Msgf("[Core] state-snapshot-seconds must be a positive integer, using %d", defaultSnapshotSeconds)
+		seconds = defaultSnapshotSeconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// watchSnapshots writes a signed snapshot of the desired and actual records to
+// state-snapshot-configmap and state-snapshot-url when it starts and then until ctx is done.
+func watchSnapshots(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	reader client.Reader,
+) error {
+	for {
+		// Read every round so targets can be added or removed at runtime
+		configMap := cfg.GetConfigValue("state-snapshot-configmap", "")
+		url := cfg.GetConfigValue("state-snapshot-url", "")
+		if configMap != "" || url != "" {
+			writeSnapshot(ctx, clientset, reader, configMap, url)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(snapshotInterval()):
+		}
+	}
+}
+
+func writeSnapshot(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	reader client.Reader,
+	configMap string,
+	url string,
+) {
+	defer utils.Recover("snapshot", nil)
+
+	content, err := buildSnapshot(ctx, reader)
+	if err != nil {
+		log.Error().Err(err).Msg("[Core] Failed to build the state snapshot")
+		return
+	}
+	if configMap != "" {
+		if err = storeSnapshot(ctx, clientset, configMap, content); err != nil {
+			log.Error().Err(err).Msgf("[Core] Failed to write the state snapshot to configmap %s", configMap)
+		}
+	}
+	if url != "" {
+		if err = uploadSnapshot(ctx, url, content); err != nil {
+			log.Error().Err(err).Msg("[Core] Failed to upload the state snapshot")
+		}
+	}
+}
+
+// buildSnapshot returns the signed snapshot as JSON.
+func buildSnapshot(
+	ctx context.Context,
+	reader client.Reader,
+) ([]byte, error) {
+	state, err := desiredState(ctx, reader)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := stateSnapshot{
+		GeneratedAt: time.Now().UTC(),
+		OwnerID:     cfg.GetConfigValue("owner-id", ""),
+		Desired:     make([]snapshotRecord, 0, len(state.Desired)),
+	}
+	for key, want := range state.Desired {
+		snapshot.Desired = append(snapshot.Desired, snapshotRecord{
+			Zone:     zoneName(providers.KeyZone(key)),
+			Name:     want.Record.Name,
+			Type:     want.Record.Type,
+			Contents: want.Record.Contents,
+			TTL:      want.Record.TTL,
+			Proxied:  want.Record.Proxied,
+			Owner:    want.Service,
+		})
+	}
+	existingRecords := cachedRecords()
+	snapshot.Actual = make([]snapshotRecord, 0, len(existingRecords))
+	for key, recordSet := range existingRecords {
+		owner, _ := providers.CommentOwner(recordSet[0].Comment)
+		record := snapshotRecord{
+			Zone:     zoneName(providers.KeyZone(key)),
+			Name:     recordSet[0].Name,
+			Type:     string(recordSet[0].Type),
+			Contents: recordContents(recordSet),
+			TTL:      int(recordSet[0].TTL),
+			Proxied:  recordSet[0].Proxied,
+			Owner:    owner,
+		}
+		for _, dnsRecord := range recordSet {
+			record.IDs = append(record.IDs, dnsRecord.ID)
+		}
+		snapshot.Actual = append(snapshot.Actual, record)
+	}
+	// Snapshots of the same records are identical apart from the time, and can be diffed
+	for _, records := range [][]snapshotRecord{snapshot.Desired, snapshot.Actual} {
+		slices.SortFunc(records, func(a, b snapshotRecord) int {
+			return strings.Compare(a.Zone+"/"+a.Name+"/"+a.Type, b.Zone+"/"+b.Name+"/"+b.Type)
+		})
+	}
+
+	payload, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	signed := signedSnapshot{Snapshot: string(payload)}
+	if key := snapshotKey.Load(); key != nil {
+		publicKey, keyErr := x509.MarshalPKIXPublicKey(key.Public())
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		keyID := sha256.Sum256(publicKey)
+		signed.Algorithm = "ed25519"
+		signed.KeyID = hex.EncodeToString(keyID[:])
+		signed.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(*key, payload))
+	}
+
+	return json.MarshalIndent(signed, "", "  ")
+}
+
+func storeSnapshot(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	name string,
+	content []byte,
+) error {
+	namespace := cfg.ConfigMapReference().Namespace
+	configMaps := clientset.CoreV1().ConfigMaps(namespace)
+	existing, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Data:       map[string]string{snapshotConfigMapKey: string(content)},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Data == nil {
+		existing.Data = make(map[string]string)
+	}
+	existing.Data[snapshotConfigMapKey] = string(content)
+	_, err = configMaps.Update(ctx, existing, metav1.UpdateOptions{})
+
+	return err
+}
+
+// uploadSnapshot PUTs the snapshot to object storage, e.g. a pre-signed bucket URL, with the
+// snapshot-url-token credential as bearer token when it is set.
+func uploadSnapshot(
+	ctx context.Context,
+	url string,
+	content []byte,
+) error {
+	callCtx, cancel := providers.WithTimeout(ctx)
+	defer cancel()
+	request, err := http.NewRequestWithContext(callCtx, http.MethodPut, url, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if token := snapshotToken.Load(); token != nil && len(*token) > 0 {
+		request.Header.Set("Authorization", "Bearer "+string(*token))
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/math280h/greydns/internal/utils"
+)
+
+// reconcileAll reconciles every annotated service once, in a fixed order, before the controller
+// handles any events. The caller must hold the reconcile lock exclusively.
+func reconcileAll(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	reader client.Reader,
+) {
+	var services v1.ServiceList
+	if err := reader.List(ctx, &services); err != nil {
+		log.Error().Err(err).Msg("[Core] Failed to list services for the startup reconcile")
+		return
+	}
+
+	names := make([]client.ObjectKey, 0, len(services.Items))
+	for _, service := range services.Items {
+		if service.Annotations["greydns.io/dns"] != "true" || !namespaceAllowed(service.Namespace) {
+			continue
+		}
+		names = append(names, client.ObjectKeyFromObject(&service))
+	}
+	slices.SortFunc(names, func(a, b client.ObjectKey) int {
+		return strings.Compare(a.String(), b.String())
+	})
+
+	failed := 0
+	for _, name := range names {
+		zone, _ := routeZone(ctx, reader, name)
+		err := func() (err error) {
+			defer utils.Recover("reconcile", &err)
+			return reconcileService(ctx, clientset, reader, name, zone)
+		}()
+		if err != nil {
+			// The controller retries the service when it handles its events
+			log.Warn().Err(err).Str("key", name.String()).Msg("[Core] Startup reconcile failed")
+			failed++
+		}
+	}
+	log.Info().Msgf("[Core] Reconciled %d services on startup, %d failed", len(names), failed)
+}
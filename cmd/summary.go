@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	defaultFailureSummarySeconds = 600
+	// Services listed in the summary event, events are limited to about 1 KiB
+	maxSummaryServices = 10
+)
+
+func failureSummaryInterval() time.Duration {
+	seconds, err := strconv.Atoi(cfg.GetConfigValue("failure-summary-seconds", strconv.Itoa(defaultFailureSummarySeconds)))
+	if err != nil || seconds < 0 {
+		log.Error().Msgf("[Core] failure-summary-seconds must be a non-negative integer, using %d", defaultFailureSummarySeconds)
+		seconds = defaultFailureSummarySeconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// watchFailures reports every service failing to sync in one log line and one event on the
+// greydns configmap until ctx is done.
+func watchFailures(
+	ctx context.Context,
+) error {
+	for {
+		interval := failureSummaryInterval()
+		if interval == 0 {
+			// Checked again in case the interval is changed at runtime
+			interval = defaultFailureSummarySeconds * time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+		if failureSummaryInterval() > 0 {
+			summarizeFailures()
+		}
+	}
+}
+
+func summarizeFailures() {
+	defer utils.Recover("summary", nil)
+
+	stateLock.Lock()
+	current := make([]reconcileError, 0, len(failing))
+	for _, failure := range failing {
+		current = append(current, failure)
+	}
+	stateLock.Unlock()
+	if len(current) == 0 {
+		return
+	}
+	slices.SortFunc(current, func(a, b reconcileError) int {
+		return strings.Compare(a.Service, b.Service)
+	})
+
+	lines := make([]string, 0, len(current))
+	for _, failure := range current {
+		lines = append(lines, fmt.Sprintf(
+			"%s failing for %s: %s",
+			failure.Service,
+			time.Since(failure.At).Round(time.Second),
+			failure.Message,
+		))
+	}
+	log.Warn().Strs("failures", lines).Msgf("[Core] %d services are failing to sync", len(current))
+
+	shown := lines[:min(len(lines), maxSummaryServices)]
+	for i, line := range shown {
+		// Provider errors can be long, the log line has them in full
+		if len(line) > 100 {
+			shown[i] = line[:100] + "..."
+		}
+	}
+	message := strings.Join(shown, "; ")
+	if len(lines) > len(shown) {
+		message += fmt.Sprintf("; and %d more", len(lines)-len(shown))
+	}
+	utils.Recorder.Eventf(
+		cfg.ConfigMapReference(),
+		v1.EventTypeWarning,
+		"SyncFailures",
+		"%d services are failing to sync: %s",
+		len(current),
+		message,
+	)
+}
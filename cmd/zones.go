@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"sync"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/math280h/greydns/internal/metrics"
+	"github.com/math280h/greydns/internal/providers"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+	"github.com/math280h/greydns/internal/records"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+var (
+	// zonesToNames maps zone names to IDs, reconciles work on a copy of it
+	zonesToNames = make(map[string]string) //nolint:gochecknoglobals // Required for zones
+	// recordsByZone is the record cache split by zone ID, the records of a zone are only changed
+	// by the worker of that zone
+	recordsByZone = make(map[string]map[string][]dns.RecordResponse) //nolint:gochecknoglobals // Required for existing records
+	// recordSnapshots holds a copy of the records of every zone as of the last reconcile in it, for
+	// readers that must not wait for the workers
+	recordSnapshots = make(map[string]map[string][]dns.RecordResponse) //nolint:gochecknoglobals // Required for existing records
+	// zoneLock guards zonesToNames, recordsByZone and recordSnapshots, not the records of a zone
+	zoneLock sync.Mutex //nolint:gochecknoglobals // Required for existing records
+
+	// errZoneChanged is returned for a service that has to be reconciled by the worker of another zone
+	errZoneChanged = errors.New("service moved to another zone")
+)
+
+// setRecordCache replaces the record cache, the caller must hold the reconcile lock exclusively.
+func setRecordCache(
+	existingRecords map[string][]dns.RecordResponse,
+) {
+	byZone := make(map[string]map[string][]dns.RecordResponse)
+	for key, recordSet := range existingRecords {
+		zoneID := providers.KeyZone(key)
+		if byZone[zoneID] == nil {
+			byZone[zoneID] = make(map[string][]dns.RecordResponse)
+		}
+		byZone[zoneID][key] = recordSet
+	}
+
+	zoneLock.Lock()
+	recordsByZone = byZone
+	recordSnapshots = make(map[string]map[string][]dns.RecordResponse)
+	zoneLock.Unlock()
+	for zoneID, zone := range byZone {
+		snapshotZoneRecords(zoneID, zone)
+		observeZoneRecords(zoneID, zone)
+	}
+	// Zones whose last managed record is gone report zero
+	for _, zoneID := range knownZones() {
+		if _, ok := byZone[zoneID]; !ok {
+			observeZoneRecords(zoneID, nil)
+		}
+	}
+}
+
+// replaceZoneRecords replaces the cached records of the refreshed zones and keeps those of every
+// other known zone, the caller must hold the reconcile lock exclusively so no reconcile changes a
+// zone between the check of its generation and the replace.
+func replaceZoneRecords(
+	refresh cf.ZoneRefresh,
+) {
+	refreshed := refresh.Current()
+	count := 0
+	zoneLock.Lock()
+	maps.Copy(recordsByZone, refreshed)
+	// Records of zones greydns no longer knows are dropped
+	known := make(map[string]bool, len(zonesToNames))
+	for _, zoneID := range zonesToNames {
+		known[zoneID] = true
+	}
+	maps.DeleteFunc(recordsByZone, func(zoneID string, _ map[string][]dns.RecordResponse) bool {
+		return !known[zoneID]
+	})
+	maps.DeleteFunc(recordSnapshots, func(zoneID string, _ map[string][]dns.RecordResponse) bool {
+		return !known[zoneID]
+	})
+	for _, zone := range recordsByZone {
+		for _, recordSet := range zone {
+			count += len(recordSet)
+		}
+	}
+	zoneLock.Unlock()
+	providers.SetManagedRecords(count)
+	for zoneID, zone := range refreshed {
+		snapshotZoneRecords(zoneID, zone)
+		observeZoneRecords(zoneID, zone)
+	}
+}
+
+// observeZoneRecords exports the number of managed records of a zone, only the worker of the
+// zone or a holder of the exclusive reconcile lock may call it.
+func observeZoneRecords(
+	zoneID string,
+	existingRecords map[string][]dns.RecordResponse,
+) {
+	count := 0
+	for _, recordSet := range existingRecords {
+		count += len(recordSet)
+	}
+	metrics.ZoneRecords.WithLabelValues(zoneName(zoneID)).Set(float64(count))
+}
+
+// zoneRecords returns the cached records of a zone.
+func zoneRecords(
+	zoneID string,
+) map[string][]dns.RecordResponse {
+	zoneLock.Lock()
+	defer zoneLock.Unlock()
+	if recordsByZone[zoneID] == nil {
+		recordsByZone[zoneID] = make(map[string][]dns.RecordResponse)
+	}
+
+	return recordsByZone[zoneID]
+}
+
+// snapshotZoneRecords publishes a copy of the records of a zone for cachedRecords, only the worker
+// of the zone or a holder of the exclusive reconcile lock may call it.
+func snapshotZoneRecords(
+	zoneID string,
+	existingRecords map[string][]dns.RecordResponse,
+) {
+	// Record sets are replaced and never changed in place, a shallow copy is enough
+	snapshot := maps.Clone(existingRecords)
+	zoneLock.Lock()
+	defer zoneLock.Unlock()
+	recordSnapshots[zoneID] = snapshot
+}
+
+// cachedRecords returns a copy of the records of every zone as of the last reconcile in it. It
+// never waits for reconciles in progress, changes they make show up once they are done.
+func cachedRecords() map[string][]dns.RecordResponse {
+	zoneLock.Lock()
+	defer zoneLock.Unlock()
+	existingRecords := make(map[string][]dns.RecordResponse)
+	for _, zone := range recordSnapshots {
+		maps.Copy(existingRecords, zone)
+	}
+
+	return existingRecords
+}
+
+// knownZones returns a copy of zonesToNames, zones found while reconciling are added back with rememberZones.
+func knownZones() map[string]string {
+	zoneLock.Lock()
+	defer zoneLock.Unlock()
+
+	return maps.Clone(zonesToNames)
+}
+
+func rememberZones(
+	zones map[string]string,
+) {
+	zoneLock.Lock()
+	defer zoneLock.Unlock()
+	maps.Copy(zonesToNames, zones)
+}
+
+func serviceZone(
+	service *v1.Service,
+) string {
+	zoneLock.Lock()
+	defer zoneLock.Unlock()
+
+	return records.ServiceZoneID(service, zonesToNames)
+}
+
+// routeZone is the zone a service is reconciled in, services that are gone are routed by the
+// version that was last applied and services that moved to another zone to their previous zone
+// first, to clean up there. It returns false for services that need no reconcile.
+func routeZone(
+	ctx context.Context,
+	reader client.Reader,
+	name types.NamespacedName,
+) (string, bool) {
+	service := &v1.Service{}
+	err := reader.Get(ctx, name, service)
+	if k8serrors.IsNotFound(err) {
+		oldService, ok := appliedService(name.String())
+		if !ok {
+			return "", false
+		}
+		return serviceZone(oldService), true
+	}
+	if err != nil {
+		// The worker runs into the same error and retries the service
+		return "", true
+	}
+	zone := serviceZone(service)
+	if oldZone, moved := movedZone(name.String(), zone); moved {
+		return oldZone, true
+	}
+
+	return zone, true
+}
+
+// zoneQueues reconciles the services of every zone in a separate worker with its own queue,
+// so a slow or throttled zone does not hold up the services of other zones.
+type zoneQueues struct {
+	lock      sync.Mutex
+	queues    map[string]workqueue.TypedRateLimitingInterface[ctrl.Request]
+	stopped   bool
+	workers   sync.WaitGroup
+	clientset kubernetes.Interface
+	reader    client.Reader
+	// workerCtx outlives the manager's context, so a reconcile in progress during a shutdown can
+	// finish. It is only cancelled once the shutdown timeout has passed.
+	workerCtx context.Context //nolint:containedctx // Required for graceful shutdown
+}
+
+func newZoneQueues(
+	workerCtx context.Context,
+	clientset kubernetes.Interface,
+	reader client.Reader,
+) *zoneQueues {
+	return &zoneQueues{
+		queues:    make(map[string]workqueue.TypedRateLimitingInterface[ctrl.Request]),
+		clientset: clientset,
+		reader:    reader,
+		workerCtx: workerCtx,
+	}
+}
+
+// add queues a service in its zone, starting a worker for zones that have none yet.
+func (q *zoneQueues) add(
+	zone string,
+	request ctrl.Request,
+) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.stopped {
+		return
+	}
+
+	queue, ok := q.queues[zone]
+	if !ok {
+		queue = workqueue.NewTypedRateLimitingQueueWithConfig(
+			newRateLimiter(),
+			workqueue.TypedRateLimitingQueueConfig[ctrl.Request]{Name: "zone-" + zone},
+		)
+		q.queues[zone] = queue
+		q.workers.Add(1)
+		go q.work(zone, queue)
+		log.Debug().Msgf("[Core] Started worker for zone %s", zone)
+	}
+	queue.Add(request)
+}
+
+// Start runs until the manager stops, then waits for the reconciles in progress.
+func (q *zoneQueues) Start(
+	ctx context.Context,
+) error {
+	<-ctx.Done()
+
+	q.lock.Lock()
+	q.stopped = true
+	for _, queue := range q.queues {
+		queue.ShutDown()
+	}
+	q.lock.Unlock()
+	q.workers.Wait()
+
+	return nil
+}
+
+func (q *zoneQueues) work(
+	zone string,
+	queue workqueue.TypedRateLimitingInterface[ctrl.Request],
+) {
+	defer q.workers.Done()
+	for {
+		request, shutdown := queue.Get()
+		if shutdown {
+			return
+		}
+		q.process(zone, queue, request)
+		queue.Done(request)
+	}
+}
+
+func (q *zoneQueues) process(
+	zone string,
+	queue workqueue.TypedRateLimitingInterface[ctrl.Request],
+	request ctrl.Request,
+) {
+	err := func() (err error) {
+		reconcileLock.RLock()
+		defer reconcileLock.RUnlock()
+		defer utils.Recover("reconcile", &err)
+		return reconcileService(q.workerCtx, q.clientset, q.reader, request.NamespacedName, zone)
+	}()
+
+	if errors.Is(err, errZoneChanged) {
+		queue.Forget(request)
+		if current, ok := routeZone(q.workerCtx, q.reader, request.NamespacedName); ok {
+			q.add(current, request)
+		}
+		return
+	}
+	reportSynced(q.workerCtx, request.NamespacedName, err)
+	if retry := recordFailure(request.String(), err); retry {
+		queue.AddRateLimited(request)
+		return
+	}
+	queue.Forget(request)
+}
@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/notify"
+	"github.com/math280h/greydns/internal/providers"
+)
+
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionComment = "comment"
+)
+
+type serviceKey struct{}
+
+var (
+	sinkLock sync.Mutex //nolint:gochecknoglobals // Required for the audit sink
+	sink     *os.File   //nolint:gochecknoglobals // Required for the audit sink
+)
+
+// Event describes a single change made at a DNS provider.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Provider  string    `json:"provider"`
+	Service   string    `json:"service,omitempty"`
+	ZoneID    string    `json:"zoneId,omitempty"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type,omitempty"`
+	Old       []string  `json:"old,omitempty"`
+	New       []string  `json:"new,omitempty"`
+	RecordIDs []string  `json:"recordIds,omitempty"`
+	DryRun    bool      `json:"dryRun,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Open appends audit events to audit-log-file when it is set, they are always logged as well.
+func Open() error {
+	path := cfg.GetConfigValue("audit-log-file", "")
+	if path == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	sinkLock.Lock()
+	sink = file
+	sinkLock.Unlock()
+	log.Info().Msgf("[Audit] Writing audit events to %s", path)
+
+	return nil
+}
+
+// WithService marks every change made with ctx as triggered by the service.
+func WithService(
+	ctx context.Context,
+	namespace string,
+	name string,
+) context.Context {
+	return context.WithValue(ctx, serviceKey{}, namespace+"/"+name)
+}
+
+// Record writes the event to the log and the audit sink, err is the provider response if the change failed.
+func Record(
+	ctx context.Context,
+	event Event,
+	err error,
+) {
+	event.Time = time.Now().UTC()
+	event.DryRun = providers.DryRun()
+	if service, ok := ctx.Value(serviceKey{}).(string); ok {
+		event.Service = service
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	// Audit events are logged regardless of the log level
+	zerolog.Ctx(ctx).Log().
+		Str("audit", event.Action).
+		Str("provider", event.Provider).
+		Str("zoneId", event.ZoneID).
+		Str("name", event.Name).
+		Str("type", event.Type).
+		Strs("old", event.Old).
+		Strs("new", event.New).
+		Strs("recordIds", event.RecordIDs).
+		Bool("dryRun", event.DryRun).
+		Str("error", event.Error).
+		Msgf("[Audit] %s %s %s", event.Action, event.Type, event.Name)
+
+	remember(event)
+	if event.Action != ActionComment {
+		notify.Publish(notify.Event{
+			Time:    event.Time,
+			Kind:    event.Action,
+			Service: event.Service,
+			Name:    event.Name,
+			Type:    event.Type,
+			Old:     event.Old,
+			New:     event.New,
+			DryRun:  event.DryRun,
+			Error:   event.Error,
+		})
+	}
+
+	sinkLock.Lock()
+	defer sinkLock.Unlock()
+	if sink == nil {
+		return
+	}
+	line, marshalErr := json.Marshal(event)
+	if marshalErr != nil {
+		log.Error().Err(marshalErr).Msg("[Audit] Failed to encode event")
+		return
+	}
+	if _, writeErr := sink.Write(append(line, '\n')); writeErr != nil {
+		log.Error().Err(writeErr).Msg("[Audit] Failed to write event")
+	}
+}
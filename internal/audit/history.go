@@ -0,0 +1,150 @@
+package audit
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/providers"
+)
+
+const (
+	defaultHistorySize = 20
+	// Records whose history is kept, the least recently changed ones are forgotten first
+	maxHistoryRecords = 10000
+)
+
+var (
+	historyLock sync.Mutex         //nolint:gochecknoglobals // Required for the change history
+	history     map[string][]Event //nolint:gochecknoglobals // Required for the change history
+)
+
+// HistorySize is the number of changes kept per record, history-size.
+func HistorySize() int {
+	size, err := strconv.Atoi(cfg.GetConfigValue("history-size", strconv.Itoa(defaultHistorySize)))
+	if err != nil || size < 0 {
+		log.Warn().Msgf("[Config] history-size must be a non-negative integer, using %d", defaultHistorySize)
+		return defaultHistorySize
+	}
+
+	return size
+}
+
+func historyKey(
+	name string,
+	recordType string,
+) string {
+	return providers.NormalizeName(name) + "/" + recordType
+}
+
+// remember keeps the last history-size events of every record.
+func remember(
+	event Event,
+) {
+	size := HistorySize()
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	if size == 0 {
+		history = nil
+		return
+	}
+	if history == nil {
+		history = make(map[string][]Event)
+	}
+
+	key := historyKey(event.Name, event.Type)
+	if _, known := history[key]; !known && len(history) >= maxHistoryRecords {
+		forgetOldest()
+	}
+	events := append(history[key], event)
+	if len(events) > size {
+		events = slices.Clone(events[len(events)-size:])
+	}
+	history[key] = events
+}
+
+// MergeHistory joins two change histories of a record, e.g. the one stored in the registry and
+// the one kept in memory, so a replica that started with an older copy drops no changes. Changes
+// found in both are kept once and only the last history-size changes remain, oldest first.
+func MergeHistory(
+	stored []Event,
+	recent []Event,
+) []Event {
+	merged := slices.Concat(stored, recent)
+	slices.SortStableFunc(merged, func(a, b Event) int {
+		return a.Time.Compare(b.Time)
+	})
+	merged = slices.CompactFunc(merged, func(a, b Event) bool {
+		return a.Time.Equal(b.Time) && a.Action == b.Action && a.Type == b.Type
+	})
+	if size := HistorySize(); len(merged) > size {
+		merged = slices.Clone(merged[len(merged)-size:])
+	}
+
+	return merged
+}
+
+// RestoreHistory adds the changes of a record persisted in the registry to the history kept in
+// memory, e.g. on startup.
+func RestoreHistory(
+	events []Event,
+) {
+	if len(events) == 0 || HistorySize() == 0 {
+		return
+	}
+	key := historyKey(events[0].Name, events[0].Type)
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	if history == nil {
+		history = make(map[string][]Event)
+	}
+	if _, known := history[key]; !known && len(history) >= maxHistoryRecords {
+		forgetOldest()
+	}
+	history[key] = MergeHistory(history[key], events)
+}
+
+// RecordHistory returns the recent changes of a single record set, oldest first.
+func RecordHistory(
+	name string,
+	recordType string,
+) []Event {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	return slices.Clone(history[historyKey(name, recordType)])
+}
+
+func forgetOldest() {
+	oldestKey := ""
+	for key, events := range history {
+		if oldestKey == "" || events[len(events)-1].Time.Before(history[oldestKey][len(history[oldestKey])-1].Time) {
+			oldestKey = key
+		}
+	}
+	delete(history, oldestKey)
+}
+
+// History returns the recent changes of every record type of a name, oldest first.
+func History(
+	name string,
+) []Event {
+	prefix := historyKey(name, "")
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	var events []Event
+	for key, recordEvents := range history {
+		if strings.HasPrefix(key, prefix) && !strings.Contains(key[len(prefix):], "/") {
+			events = append(events, recordEvents...)
+		}
+	}
+	slices.SortStableFunc(events, func(a, b Event) int {
+		return a.Time.Compare(b.Time)
+	})
+
+	return events
+}
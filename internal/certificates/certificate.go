@@ -0,0 +1,195 @@
+package certificates
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/rs/zerolog"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/providers"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	defaultIssuerKind = "ClusterIssuer"
+	managedByLabel    = "app.kubernetes.io/managed-by"
+	managedBy         = "greydns"
+)
+
+var (
+	certificateResource = schema.GroupVersionResource{ //nolint:gochecknoglobals // Required for certificates
+		Group:    "cert-manager.io",
+		Version:  "v1",
+		Resource: "certificates",
+	}
+	client dynamic.Interface //nolint:gochecknoglobals // Required for certificates
+)
+
+func Connect(
+	config *rest.Config,
+) error {
+	var err error
+	client, err = dynamic.NewForConfig(config)
+
+	return err
+}
+
+// certificateName is the name of the Certificate of a service and of the secret it issues into.
+func certificateName(
+	service *v1.Service,
+) string {
+	return service.Name + "-tls"
+}
+
+// Sync creates or updates the cert-manager Certificate of a service with greydns.io/certificate
+// for its domain, or deletes the Certificate greydns created once the service no longer asks
+// for it. Deleted services take their Certificate along through the owner reference. Problems
+// are reported as events, a missing certificate never holds back the records.
+func Sync(
+	ctx context.Context,
+	service *v1.Service,
+	domain string,
+) {
+	if client == nil {
+		return
+	}
+	wanted := service.Annotations["greydns.io/dns"] == "true" && service.Annotations["greydns.io/certificate"] == "true"
+	name := certificateName(service)
+	resource := client.Resource(certificateResource).Namespace(service.Namespace)
+
+	existing, err := resource.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case k8serrors.IsNotFound(err):
+		existing = nil
+	case err != nil:
+		if wanted {
+			fail(ctx, service, "failed to get Certificate %s: %v", name, err)
+		}
+		return
+	case existing.GetLabels()[managedByLabel] != managedBy:
+		if wanted {
+			fail(ctx, service, "Certificate %s exists and is not managed by greydns", name)
+		}
+		return
+	}
+
+	if !wanted {
+		if existing != nil {
+			remove(ctx, service, name)
+		}
+		return
+	}
+
+	issuer := service.Annotations["greydns.io/certificate-issuer"]
+	if issuer == "" {
+		issuer = cfg.GetConfigValue("certificate-issuer", "")
+	}
+	if issuer == "" {
+		fail(ctx, service, "no issuer, set greydns.io/certificate-issuer or certificate-issuer")
+		return
+	}
+	issuerKind := service.Annotations["greydns.io/certificate-issuer-kind"]
+	if issuerKind == "" {
+		issuerKind = cfg.GetConfigValue("certificate-issuer-kind", defaultIssuerKind)
+	}
+
+	certificate := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": certificateResource.GroupVersion().String(),
+		"kind":       "Certificate",
+		"spec": map[string]any{
+			"secretName": name,
+			"dnsNames":   []any{domain},
+			"issuerRef": map[string]any{
+				"name":  issuer,
+				"kind":  issuerKind,
+				"group": certificateResource.Group,
+			},
+		},
+	}}
+	certificate.SetName(name)
+	certificate.SetNamespace(service.Namespace)
+	certificate.SetLabels(map[string]string{
+		managedByLabel:       managedBy,
+		"greydns.io/service": service.Name,
+	})
+	certificate.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: "v1",
+		Kind:       "Service",
+		Name:       service.Name,
+		UID:        service.UID,
+	}})
+
+	if existing != nil && specUnchanged(existing, certificate) {
+		return
+	}
+	if providers.DryRun() {
+		zerolog.Ctx(ctx).Info().Msgf("[Certificates] [dry-run] Would save Certificate %s for %s", name, domain)
+		return
+	}
+	if existing == nil {
+		_, err = resource.Create(ctx, certificate, metav1.CreateOptions{})
+	} else {
+		certificate.SetResourceVersion(existing.GetResourceVersion())
+		_, err = resource.Update(ctx, certificate, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		fail(ctx, service, "failed to save Certificate %s: %v", name, err)
+		return
+	}
+	zerolog.Ctx(ctx).Info().Msgf("[Certificates] Saved Certificate %s for %s", name, domain)
+	utils.Recorder.Eventf(service, v1.EventTypeNormal, "CertificateSaved", "Certificate %s requested for %s from %s %s", name, domain, issuerKind, issuer)
+}
+
+// specUnchanged reports whether an existing Certificate already asks for the same, fields
+// greydns does not set are left out.
+func specUnchanged(
+	existing *unstructured.Unstructured,
+	certificate *unstructured.Unstructured,
+) bool {
+	for _, field := range []string{"secretName", "dnsNames", "issuerRef"} {
+		before, _, _ := unstructured.NestedFieldNoCopy(existing.Object, "spec", field)
+		after, _, _ := unstructured.NestedFieldNoCopy(certificate.Object, "spec", field)
+		if !reflect.DeepEqual(before, after) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func remove(
+	ctx context.Context,
+	service *v1.Service,
+	name string,
+) {
+	if providers.DryRun() {
+		zerolog.Ctx(ctx).Info().Msgf("[Certificates] [dry-run] Would delete Certificate %s", name)
+		return
+	}
+	err := client.Resource(certificateResource).Namespace(service.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		fail(ctx, service, "failed to delete Certificate %s: %v", name, err)
+		return
+	}
+	zerolog.Ctx(ctx).Info().Msgf("[Certificates] Deleted Certificate %s", name)
+}
+
+func fail(
+	ctx context.Context,
+	service *v1.Service,
+	format string,
+	args ...any,
+) {
+	message := fmt.Sprintf(format, args...)
+	zerolog.Ctx(ctx).Error().Msg("[Certificates] " + message)
+	utils.Recorder.Event(service, v1.EventTypeWarning, "CertificateFailed", message)
+}
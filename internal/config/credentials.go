@@ -0,0 +1,121 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// How often sources without change notifications are read again
+	credentialPollInterval = 60 * time.Second
+)
+
+var (
+	// Keys the env source reads, zone tokens need a source that allows dots in keys
+	envCredentialKeys = []string{"cloudflare", "cloudflare-api-key", "cloudflare-email", "api-token", "snapshot-signing-key", "snapshot-url-token"} //nolint:gochecknoglobals // Required for credentials
+)
+
+// Credentials holds the provider credentials by key, e.g. cloudflare or cloudflare.example.com,
+// with the same keys whatever source they come from.
+type Credentials map[string][]byte
+
+// CredentialSource loads the credentials of the providers and notices when they are rotated.
+type CredentialSource interface {
+	// Name identifies the source in logs
+	Name() string
+	Load(ctx context.Context) (Credentials, error)
+	// Watch calls changed with the new credentials whenever they change until ctx is done,
+	// sources whose credentials cannot change return right away.
+	Watch(ctx context.Context, changed func(Credentials))
+}
+
+// NewCredentialSource returns the source selected by credential-source. Without one the
+// credentials are read from the environment when GREYDNS_CLOUDFLARE is set, or the greydns secret.
+func NewCredentialSource(
+	clientset kubernetes.Interface,
+) (CredentialSource, error) {
+	source := GetConfigValue("credential-source", "")
+	if source == "" {
+		source = "secret"
+		if _, ok := os.LookupEnv(EnvName("cloudflare")); ok {
+			source = "env"
+		}
+	}
+
+	switch source {
+	case "secret":
+		return &secretSource{clientset: clientset}, nil
+	case "env":
+		return envSource{}, nil
+	case "file":
+		return &fileSource{dir: GetConfigValue("credential-dir", defaultCredentialDir)}, nil
+	case "vault":
+		return newVaultSource()
+	default:
+		return nil, fmt.Errorf("unknown credential-source %q, expected secret, env, file or vault", source)
+	}
+}
+
+// envSource reads the credentials from GREYDNS_ environment variables, e.g. GREYDNS_CLOUDFLARE.
+type envSource struct{}
+
+func (envSource) Name() string {
+	return "environment"
+}
+
+func (envSource) Load(
+	_ context.Context,
+) (Credentials, error) {
+	credentials := make(Credentials)
+	for _, key := range envCredentialKeys {
+		if value, ok := os.LookupEnv(EnvName(key)); ok {
+			credentials[key] = []byte(value)
+		}
+	}
+
+	return credentials, nil
+}
+
+func (envSource) Watch(
+	_ context.Context,
+	_ func(Credentials),
+) {
+}
+
+// pollCredentials loads the credentials of a source every credentialPollInterval and calls
+// changed when they differ from the previous ones.
+func pollCredentials(
+	ctx context.Context,
+	source CredentialSource,
+	changed func(Credentials),
+) {
+	last, err := source.Load(ctx)
+	if err != nil {
+		log.Error().Err(err).Msgf("[Config] Failed to read the credentials from %s", source.Name())
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(credentialPollInterval):
+		}
+		credentials, err := source.Load(ctx)
+		if err != nil {
+			// The previous credentials stay in use
+			log.Error().Err(err).Msgf("[Config] Failed to read the credentials from %s", source.Name())
+			continue
+		}
+		if maps.EqualFunc(last, credentials, bytes.Equal) {
+			continue
+		}
+		last = credentials
+		changed(credentials)
+	}
+}
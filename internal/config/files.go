@@ -0,0 +1,63 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	defaultCredentialDir = "/etc/greydns/credentials"
+)
+
+// fileSource reads the credentials from a directory with a file per key, e.g. a mounted secret,
+// a CSI secrets store volume or files rendered by the Vault agent injector.
+type fileSource struct {
+	dir string
+}
+
+func (s *fileSource) Name() string {
+	return "directory " + s.dir
+}
+
+func (s *fileSource) Load(
+	_ context.Context,
+) (Credentials, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	credentials := make(Credentials)
+	for _, entry := range entries {
+		// Mounted secrets keep their versions in hidden directories like ..data
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(s.dir, entry.Name())
+		if info, statErr := os.Stat(path); statErr != nil || info.IsDir() {
+			continue
+		}
+		value, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return nil, readErr
+		}
+		// Rendered files usually end with a newline that is not part of the token
+		credentials[entry.Name()] = bytes.TrimSpace(value)
+	}
+
+	return credentials, nil
+}
+
+func (s *fileSource) Watch(
+	ctx context.Context,
+	changed func(Credentials),
+) {
+	go func() {
+		defer utils.Recover("credential-watch", nil)
+		pollCredentials(ctx, s, changed)
+	}()
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// ConfigureLogging sets up the global logger from log-format and log-level.
+func ConfigureLogging() {
+	switch format := GetConfigValue("log-format", "console"); format {
+	case "json":
+		log.Logger = zerolog.New(os.Stderr).With().Timestamp().Logger() //nolint:reassign // Required for logging
+	case "console":
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr}) //nolint:reassign // Required for logging
+	default:
+		log.Warn().Msgf("[Config] Unknown log-format %s, using console", format)
+	}
+	// Reconciles without a logger in their context fall back to the global one
+	zerolog.DefaultContextLogger = &log.Logger
+
+	ApplyLogLevel()
+}
+
+// ApplyLogLevel sets the global log level from log-level, it can be changed at runtime.
+func ApplyLogLevel() {
+	level, err := zerolog.ParseLevel(GetConfigValue("log-level", "debug"))
+	if err != nil || level == zerolog.NoLevel {
+		log.Warn().Msg("[Config] Invalid log-level, using debug")
+		level = zerolog.DebugLevel
+	}
+	zerolog.SetGlobalLevel(level)
+}
@@ -2,35 +2,240 @@ package config
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 
 	"github.com/rs/zerolog/log"
 
 	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	configMapName      = "greydns-config"
+	configMapNamespace = "default"
+
+	serviceAccountNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
 )
 
 var (
-	ConfigMap *v1.ConfigMap //nolint:gochecknoglobals // Required for configmap
+	// data is replaced as a whole when the configmap changes, readers never see a partial update
+	data atomic.Pointer[map[string]string] //nolint:gochecknoglobals // Required for configmap
+
+	requiredKeys = []string{ //nolint:gochecknoglobals // Required for configmap
+		"record-ttl",
+		"record-type",
+		"proxy-enabled",
+		"cache-refresh-seconds",
+		"ingress-destination",
+	}
 )
 
-func GetRequiredConfigValue(key string) string {
-	value, ok := ConfigMap.Data[key]
+// ConfigMapReference refers to the greydns configmap, for events about the controller as a whole.
+func ConfigMapReference() *v1.ObjectReference {
+	return &v1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Namespace:  configMapNamespace,
+		Name:       configMapName,
+	}
+}
+
+// ControllerNamespace is the namespace greydns runs in, taken from its service account, or
+// default outside a cluster, e.g. for greydnsctl.
+func ControllerNamespace() string {
+	namespace, err := os.ReadFile(serviceAccountNamespacePath)
+	if err != nil || strings.TrimSpace(string(namespace)) == "" {
+		return configMapNamespace
+	}
+
+	return strings.TrimSpace(string(namespace))
+}
+
+// lookup resolves a key from the -set flag, env vars and finally the configmap.
+func lookup(key string) (string, bool) {
+	if value, ok := lookupOverride(key); ok {
+		return value, true
+	}
+
+	current := data.Load()
+	if current == nil {
+		return "", false
+	}
+	value, ok := (*current)[key]
+
+	return value, ok
+}
+
+// validate ensures every required key is present, so a bad edit is rejected instead of crashing later.
+func validate(values map[string]string) error {
+	var missing []string
+	for _, key := range requiredKeys {
+		if _, ok := lookupOverride(key); ok {
+			continue
+		}
+		if _, ok := values[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return errors.New("missing required keys: " + strings.Join(missing, ", "))
+	}
+
+	refresh, ok := lookupOverride("cache-refresh-seconds")
+	if !ok {
+		refresh = values["cache-refresh-seconds"]
+	}
+	if seconds, err := strconv.Atoi(refresh); err != nil || seconds <= 0 {
+		return fmt.Errorf("cache-refresh-seconds %q is not a positive integer", refresh)
+	}
+
+	return nil
+}
+
+// GetRequiredConfigValue returns an error instead of a fallback when the key is not set.
+func GetRequiredConfigValue(key string) (string, error) {
+	value, ok := lookup(key)
+	if !ok {
+		return "", fmt.Errorf("required key %s does not exist in configmap", key)
+	}
+
+	return value, nil
+}
+
+func GetConfigValue(key string, fallback string) string {
+	value, ok := lookup(key)
 	if !ok {
-		log.Fatal().Msgf("[Config] Required key %s does not exist in configmap", key)
+		return fallback
 	}
 
 	return value
 }
 
+// GetRequiredZoneConfigValue prefers a per-zone override such as example.com.record-ttl over the global key.
+func GetRequiredZoneConfigValue(zone string, key string) (string, error) {
+	if value, ok := lookup(zone + "." + key); ok {
+		return value, nil
+	}
+
+	return GetRequiredConfigValue(key)
+}
+
+func GetZoneConfigValue(zone string, key string, fallback string) string {
+	if value, ok := lookup(zone + "." + key); ok {
+		return value
+	}
+
+	return GetConfigValue(key, fallback)
+}
+
+// GetConfigMapping parses a comma separated list of key=value pairs.
+func GetConfigMapping(key string) map[string]string {
+	mapping := make(map[string]string)
+	for _, item := range utils.SplitList(GetConfigValue(key, "")) {
+		name, value, ok := strings.Cut(item, "=")
+		if !ok {
+			log.Warn().Msgf("[Config] Ignoring invalid entry %s in %s", item, key)
+			continue
+		}
+		mapping[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	return mapping
+}
+
 func LoadConfigMap(
 	clientset *kubernetes.Clientset,
+) error {
+	var configMap *v1.ConfigMap
+	err := utils.RetryStartup("get configmap", func(err error) bool {
+		return !k8serrors.IsNotFound(err)
+	}, func() error {
+		var getErr error
+		configMap, getErr = clientset.CoreV1().ConfigMaps(
+			configMapNamespace,
+		).Get(context.Background(), configMapName, metav1.GetOptions{})
+		return getErr
+	})
+	if k8serrors.IsNotFound(err) {
+		// Everything may be configured with flags and env vars instead
+		log.Info().Msg("[Config] No configmap found, using flags and env vars only")
+		configMap, err = &v1.ConfigMap{Data: map[string]string{}}, nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get configmap: %w", err)
+	}
+	if err = validate(configMap.Data); err != nil {
+		return fmt.Errorf("invalid configmap: %w", err)
+	}
+	data.Store(&configMap.Data)
+
+	return nil
+}
+
+// WatchConfigMap applies changes to the configmap at runtime, including a configmap created after
+// the start, and calls onChange after every accepted change. Changes missing required keys are
+// rejected and the previous config is kept, as it is when the configmap is deleted.
+func WatchConfigMap(
+	ctx context.Context,
+	clientset *kubernetes.Clientset,
+	onChange func(),
 ) {
-	var err error
-	ConfigMap, err = clientset.CoreV1().ConfigMaps(
-		"default",
-	).Get(context.Background(), "greydns-config", metav1.GetOptions{})
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		clientset,
+		0,
+		informers.WithNamespace(configMapNamespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = "metadata.name=" + configMapName
+		}),
+	)
+
+	apply := func(obj interface{}) {
+		defer utils.Recover("config-watch", nil)
+		configMap, ok := obj.(*v1.ConfigMap)
+		if !ok {
+			log.Error().Msg("[Config] Failed to cast configmap")
+			return
+		}
+		if current := data.Load(); current != nil && maps.Equal(*current, configMap.Data) {
+			return
+		}
+		if err := validate(configMap.Data); err != nil {
+			log.Error().Err(err).Msg("[Config] Ignoring invalid configmap change, keeping the previous config")
+			return
+		}
+
+		log.Info().Msg("[Config] Configmap changed, applying new config")
+		data.Store(&configMap.Data)
+		onChange()
+	}
+
+	// A configmap created after the start is applied like a change, the initial list of the
+	// informer matches the loaded config and is skipped
+	_, err := factory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: apply,
+		UpdateFunc: func(_, newObj interface{}) {
+			apply(newObj)
+		},
+		DeleteFunc: func(_ interface{}) {
+			log.Warn().Msg("[Config] Configmap deleted, keeping the last config until it is recreated")
+		},
+	})
 	if err != nil {
-		log.Fatal().Err(err).Msg("[Config] Failed to get configmap")
+		log.Error().Err(err).Msg("[Config] Failed to watch the configmap, changes require a restart")
+		return
 	}
+
+	factory.Start(ctx.Done())
 }
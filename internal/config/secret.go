@@ -0,0 +1,80 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"maps"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	SecretName      = "greydns-secret"
+	SecretNamespace = "default"
+)
+
+// secretSource reads the credentials from the greydns secret and watches it for rotations.
+type secretSource struct {
+	clientset kubernetes.Interface
+}
+
+func (s *secretSource) Name() string {
+	return "secret " + SecretNamespace + "/" + SecretName
+}
+
+func (s *secretSource) Load(
+	ctx context.Context,
+) (Credentials, error) {
+	secret, err := s.clientset.CoreV1().Secrets(SecretNamespace).Get(ctx, SecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return Credentials(maps.Clone(secret.Data)), nil
+}
+
+func (s *secretSource) Watch(
+	ctx context.Context,
+	changed func(Credentials),
+) {
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		s.clientset,
+		0,
+		informers.WithNamespace(SecretNamespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = "metadata.name=" + SecretName
+		}),
+	)
+
+	_, err := factory.Core().V1().Secrets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			defer utils.Recover("secret-watch", nil)
+			oldSecret, ok := oldObj.(*v1.Secret)
+			if !ok {
+				return
+			}
+			secret, ok := newObj.(*v1.Secret)
+			if !ok {
+				log.Error().Msg("[Config] Failed to cast secret")
+				return
+			}
+			if maps.EqualFunc(oldSecret.Data, secret.Data, bytes.Equal) {
+				return
+			}
+			changed(Credentials(maps.Clone(secret.Data)))
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("[Config] Failed to watch the greydns secret, token rotation requires a restart")
+		return
+	}
+
+	factory.Start(ctx.Done())
+}
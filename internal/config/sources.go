@@ -0,0 +1,57 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strings"
+)
+
+const (
+	envPrefix = "GREYDNS_"
+)
+
+var (
+	// overrides holds values set with -set on the command line, they take precedence over everything
+	overrides = make(map[string]string) //nolint:gochecknoglobals // Required for command line config
+)
+
+type overrideFlag struct{}
+
+func (overrideFlag) String() string {
+	return ""
+}
+
+func (overrideFlag) Set(value string) error {
+	key, setting, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return errors.New("expected key=value")
+	}
+	overrides[key] = setting
+
+	return nil
+}
+
+// RegisterFlags adds the -set flag, which can be repeated to set any config key.
+func RegisterFlags(flags *flag.FlagSet) {
+	flags.Var(
+		overrideFlag{},
+		"set",
+		"Set a config value as key=value, repeatable. Takes precedence over env vars and the configmap",
+	)
+}
+
+// EnvName is the environment variable of a config key, e.g. record-ttl is GREYDNS_RECORD_TTL
+// and example.com.record-ttl is GREYDNS_EXAMPLE_COM_RECORD_TTL.
+func EnvName(key string) string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+}
+
+// lookupOverride resolves a key from the sources above the configmap, flags first then env vars.
+func lookupOverride(key string) (string, bool) {
+	if value, ok := overrides[key]; ok {
+		return value, true
+	}
+
+	return os.LookupEnv(EnvName(key))
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token" //nolint:gosec // Path, not a credential
+	defaultVaultAuthPath    = "kubernetes"
+	vaultTimeout            = 10 * time.Second
+)
+
+var (
+	errVaultForbidden = errors.New("vault denied the request")
+)
+
+// vaultSource reads the credentials from a Vault KV secret. It logs in with the Kubernetes auth
+// method and the pod's service account, or uses VAULT_TOKEN when it is set.
+type vaultSource struct {
+	addr     string
+	path     string
+	role     string
+	authPath string
+	client   *http.Client
+
+	// The client token of the last login, reused until its lease runs out
+	tokenLock   sync.Mutex
+	clientToken string
+	tokenExpiry time.Time
+}
+
+func newVaultSource() (*vaultSource, error) {
+	source := &vaultSource{
+		addr:     strings.TrimSuffix(GetConfigValue("vault-addr", os.Getenv("VAULT_ADDR")), "/"),
+		path:     strings.Trim(GetConfigValue("vault-path", ""), "/"),
+		role:     GetConfigValue("vault-role", ""),
+		authPath: strings.Trim(GetConfigValue("vault-auth-path", defaultVaultAuthPath), "/"),
+		client:   &http.Client{Timeout: vaultTimeout},
+	}
+	if source.addr == "" || source.path == "" {
+		return nil, errors.New("the vault credential-source requires vault-addr and vault-path")
+	}
+
+	return source, nil
+}
+
+func (s *vaultSource) Name() string {
+	return "vault " + s.path
+}
+
+func (s *vaultSource) Load(
+	ctx context.Context,
+) (Credentials, error) {
+	token, err := s.token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to log in to vault: %w", err)
+	}
+
+	var response struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	err = s.call(ctx, http.MethodGet, "/v1/"+s.path, token, nil, &response)
+	if errors.Is(err, errVaultForbidden) && os.Getenv("VAULT_TOKEN") == "" {
+		// The token can be revoked before its lease runs out, a new login gets a valid one
+		s.forgetToken()
+		if token, err = s.token(ctx); err != nil {
+			return nil, fmt.Errorf("failed to log in to vault: %w", err)
+		}
+		err = s.call(ctx, http.MethodGet, "/v1/"+s.path, token, nil, &response)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// KV version 2 nests the values in data.data next to data.metadata
+	values := response.Data
+	if nested, ok := response.Data["data"]; ok {
+		if _, versioned := response.Data["metadata"]; versioned {
+			values = nil
+			if err = json.Unmarshal(nested, &values); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	credentials := make(Credentials, len(values))
+	for key, raw := range values {
+		var value string
+		if err = json.Unmarshal(raw, &value); err != nil {
+			return nil, fmt.Errorf("vault value %s is not a string", key)
+		}
+		credentials[key] = []byte(value)
+	}
+
+	return credentials, nil
+}
+
+func (s *vaultSource) Watch(
+	ctx context.Context,
+	changed func(Credentials),
+) {
+	go func() {
+		defer utils.Recover("credential-watch", nil)
+		pollCredentials(ctx, s, changed)
+	}()
+}
+
+// token returns the client token of the last login with the service account of the pod, and
+// logs in again once its lease is about to run out. Logging in on every load would leave a
+// lease behind every poll.
+func (s *vaultSource) token(
+	ctx context.Context,
+) (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	if s.role == "" {
+		return "", errors.New("vault-role is required without VAULT_TOKEN")
+	}
+
+	s.tokenLock.Lock()
+	defer s.tokenLock.Unlock()
+	if s.clientToken != "" && (s.tokenExpiry.IsZero() || time.Now().Before(s.tokenExpiry)) {
+		return s.clientToken, nil
+	}
+	jwt, err := os.ReadFile(serviceAccountTokenPath)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	login := map[string]string{"role": s.role, "jwt": strings.TrimSpace(string(jwt))}
+	if err = s.call(ctx, http.MethodPost, "/v1/auth/"+s.authPath+"/login", "", login, &response); err != nil {
+		return "", err
+	}
+	s.clientToken = response.Auth.ClientToken
+	// A lease of 0 never expires, other tokens are replaced a little early so no read races the expiry
+	s.tokenExpiry = time.Time{}
+	if response.Auth.LeaseDuration > 0 {
+		s.tokenExpiry = time.Now().Add(time.Duration(response.Auth.LeaseDuration) * time.Second * 9 / 10)
+	}
+
+	return s.clientToken, nil
+}
+
+// forgetToken drops the client token, the next load logs in again.
+func (s *vaultSource) forgetToken() {
+	s.tokenLock.Lock()
+	defer s.tokenLock.Unlock()
+	s.clientToken = ""
+}
+
+func (s *vaultSource) call(
+	ctx context.Context,
+	method string,
+	path string,
+	token string,
+	body any,
+	result any,
+) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+	request, err := http.NewRequestWithContext(ctx, method, s.addr+path, &payload)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		request.Header.Set("X-Vault-Token", token)
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	switch {
+	case response.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: vault answered %s for %s", errVaultForbidden, response.Status, path)
+	case response.StatusCode != http.StatusOK:
+		return fmt.Errorf("vault answered %s for %s", response.Status, path)
+	}
+
+	return json.NewDecoder(response.Body).Decode(result)
+}
@@ -0,0 +1,186 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/math280h/greydns/internal/providers"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	// ServicePrefix in a destination refers to the LoadBalancer address of a service, e.g.
+	// service:ingress-nginx/ingress-nginx-controller
+	ServicePrefix = "service:"
+)
+
+var (
+	lbClientset kubernetes.Interface //nolint:gochecknoglobals // Required for discovery
+	lbChanged   func()               //nolint:gochecknoglobals // Required for discovery
+	lbStop      <-chan struct{}      //nolint:gochecknoglobals // Required for discovery
+	// LoadBalancer addresses of referenced services by namespace/name, watched services stay cached
+	loadBalancers     = make(map[string][]string) //nolint:gochecknoglobals // Required for discovery
+	watched           = make(map[string]bool)     //nolint:gochecknoglobals // Required for discovery
+	loadBalancersLock sync.Mutex                  //nolint:gochecknoglobals // Required for discovery
+)
+
+// ConnectLoadBalancers lets destinations refer to services. Referenced services are watched
+// until ctx is done and changed is called when their address changes, a nil changed looks the
+// services up without watching them.
+func ConnectLoadBalancers(
+	ctx context.Context,
+	clientset kubernetes.Interface,
+	changed func(),
+) {
+	loadBalancersLock.Lock()
+	defer loadBalancersLock.Unlock()
+	lbClientset = clientset
+	lbChanged = changed
+	lbStop = ctx.Done()
+}
+
+// loadBalancerAddresses returns the LoadBalancer addresses of the family of a service reference.
+// Hostnames, e.g. of cloud load balancers, are returned with the IPv4 addresses and need
+// record-type CNAME. A failed lookup is not remembered, the next Resolve tries again.
+func loadBalancerAddresses(
+	reference string,
+	v6 bool,
+) ([]string, error) {
+	key := strings.TrimPrefix(reference, ServicePrefix)
+	namespace, name, ok := strings.Cut(key, "/")
+	if !ok {
+		log.Error().Msgf("[Discovery] %s must be given as %snamespace/name", reference, ServicePrefix)
+		return nil, nil
+	}
+
+	loadBalancersLock.Lock()
+	addresses, known := loadBalancers[key]
+	clientset := lbClientset
+	loadBalancersLock.Unlock()
+	if !known {
+		if clientset == nil {
+			log.Error().Msgf("[Discovery] Services cannot be looked up, %s is ignored", reference)
+			return nil, nil
+		}
+		watchLoadBalancer(namespace, name)
+		ctx, cancel := providers.WithTimeout(context.Background())
+		service, err := clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		cancel()
+		switch {
+		case k8serrors.IsNotFound(err):
+			// A missing service has no address until the watch sees it created
+			addresses = []string{}
+		case err != nil:
+			log.Error().Err(err).Msgf("[Discovery] Failed to get the load balancer service %s", key)
+			return nil, fmt.Errorf("%w: %s: %w", ErrUnresolved, reference, err)
+		default:
+			addresses = ingressAddresses(service)
+		}
+		loadBalancersLock.Lock()
+		if _, known = loadBalancers[key]; !known {
+			loadBalancers[key] = addresses
+		}
+		loadBalancersLock.Unlock()
+	}
+	if len(addresses) == 0 {
+		log.Warn().Msgf("[Discovery] Service %s has no load balancer address yet", key)
+	}
+
+	family := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if (ip != nil && (ip.To4() == nil) == v6) || (ip == nil && !v6) {
+			family = append(family, address)
+		}
+	}
+
+	return family, nil
+}
+
+// ingressAddresses returns the IPs and hostnames of the LoadBalancer status of a service, sorted.
+func ingressAddresses(
+	service *v1.Service,
+) []string {
+	addresses := make([]string, 0, len(service.Status.LoadBalancer.Ingress))
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		switch {
+		case ingress.IP != "":
+			addresses = append(addresses, ingress.IP)
+		case ingress.Hostname != "":
+			addresses = append(addresses, ingress.Hostname)
+		}
+	}
+	slices.Sort(addresses)
+
+	return addresses
+}
+
+// watchLoadBalancer keeps the cached address of a referenced service current, once per service.
+func watchLoadBalancer(
+	namespace string,
+	name string,
+) {
+	key := namespace + "/" + name
+	loadBalancersLock.Lock()
+	defer loadBalancersLock.Unlock()
+	if lbChanged == nil || watched[key] {
+		return
+	}
+	watched[key] = true
+
+	factory := informers.NewSharedInformerFactoryWithOptions(
+		lbClientset,
+		0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = "metadata.name=" + name
+		}),
+	)
+	update := func(addresses []string) {
+		defer utils.Recover("load-balancer-watch", nil)
+		loadBalancersLock.Lock()
+		previous, known := loadBalancers[key]
+		loadBalancers[key] = addresses
+		changed := lbChanged
+		loadBalancersLock.Unlock()
+		// The first sight of the service is what the lookup found, nothing changed
+		if !known || slices.Equal(previous, addresses) {
+			return
+		}
+		log.Info().Msgf("[Discovery] Load balancer address of %s changed from %v to %v", key, previous, addresses)
+		changed()
+	}
+	_, err := factory.Core().V1().Services().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if service, ok := obj.(*v1.Service); ok {
+				update(ingressAddresses(service))
+			}
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			if service, ok := newObj.(*v1.Service); ok {
+				update(ingressAddresses(service))
+			}
+		},
+		DeleteFunc: func(_ interface{}) {
+			update([]string{})
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("[Discovery] Failed to watch the load balancer service %s, address changes require a restart", key)
+		return
+	}
+
+	factory.Start(lbStop)
+}
@@ -0,0 +1,207 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	// Auto in ingress-destination or ingress-destination-v6 stands for the discovered public address
+	Auto = "auto"
+
+	defaultPublicIPServices = "https://api64.ipify.org,https://icanhazip.com,stun:stun.cloudflare.com:3478"
+	discoveryTimeout        = 10 * time.Second
+	maxAnswerSize           = 256
+)
+
+var (
+	// ErrUnresolved is a destination whose addresses could not be looked up, the records are kept
+	// as they are until a later reconcile resolves it
+	ErrUnresolved = errors.New("destination could not be resolved")
+
+	// Discovered addresses by family, a family is only looked up once a destination asks for it
+	discovered     = make(map[bool]string) //nolint:gochecknoglobals // Required for discovery
+	discoveredLock sync.Mutex              //nolint:gochecknoglobals // Required for discovery
+)
+
+// Resolve replaces auto in a comma separated destination with the public address of the
+// given family and service references with the LoadBalancer addresses of the service,
+// looking them up first if they are not known yet. A failed lookup returns ErrUnresolved
+// instead of a destination missing the entry.
+func Resolve(
+	destination string,
+	v6 bool,
+) (string, error) {
+	entries := utils.SplitList(destination)
+	resolved := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		switch {
+		case strings.EqualFold(entry, Auto):
+			address, err := publicAddress(v6)
+			if err != nil {
+				return "", fmt.Errorf("%w: %s: %w", ErrUnresolved, entry, err)
+			}
+			resolved = append(resolved, address)
+		case strings.HasPrefix(entry, ServicePrefix):
+			addresses, err := loadBalancerAddresses(entry, v6)
+			if err != nil {
+				return "", err
+			}
+			resolved = append(resolved, addresses...)
+		default:
+			resolved = append(resolved, entry)
+		}
+	}
+
+	return strings.Join(resolved, ","), nil
+}
+
+// publicAddress returns the discovered public address of the family, discovering it first if
+// it is not known yet. A failed lookup is not remembered, the next Resolve tries again.
+func publicAddress(
+	v6 bool,
+) (string, error) {
+	discoveredLock.Lock()
+	address, known := discovered[v6]
+	discoveredLock.Unlock()
+	if known {
+		return address, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	address, err := discover(ctx, v6)
+	if err != nil {
+		return "", err
+	}
+	discoveredLock.Lock()
+	discovered[v6] = address
+	discoveredLock.Unlock()
+
+	return address, nil
+}
+
+// Refresh looks up the public addresses destinations asked for again and reports whether one changed.
+func Refresh(
+	ctx context.Context,
+) bool {
+	discoveredLock.Lock()
+	families := make([]bool, 0, len(discovered))
+	for v6 := range discovered {
+		families = append(families, v6)
+	}
+	discoveredLock.Unlock()
+
+	changed := false
+	for _, v6 := range families {
+		address, err := discover(ctx, v6)
+		if err != nil {
+			// The previous address is kept, a failing lookup says nothing about the address
+			continue
+		}
+		discoveredLock.Lock()
+		previous := discovered[v6]
+		discovered[v6] = address
+		discoveredLock.Unlock()
+		if previous != address {
+			log.Info().Msgf("[Discovery] Public address changed from %q to %s", previous, address)
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// discover asks the services of public-ip-services in order for the public address, the first
+// valid answer of the family wins. Entries are HTTP(S) URLs answering with the address as plain
+// text, e.g. a router API, or STUN servers given as stun:host:port.
+func discover(
+	ctx context.Context,
+	v6 bool,
+) (string, error) {
+	network := "4"
+	if v6 {
+		network = "6"
+	}
+
+	var errs []error
+	for _, service := range utils.SplitList(cfg.GetConfigValue("public-ip-services", defaultPublicIPServices)) {
+		var (
+			address string
+			err     error
+		)
+		if server, ok := strings.CutPrefix(service, "stun:"); ok {
+			address, err = stunAddress(ctx, "udp"+network, server)
+		} else {
+			address, err = httpAddress(ctx, "tcp"+network, service)
+		}
+		if err == nil {
+			ip := net.ParseIP(address)
+			switch {
+			case ip == nil:
+				err = fmt.Errorf("answer %q is not an IP address", address)
+			case (ip.To4() == nil) != v6:
+				err = fmt.Errorf("answer %s is not an IPv%s address", address, network)
+			default:
+				log.Debug().Msgf("[Discovery] %s reports public address %s", service, ip)
+				return ip.String(), nil
+			}
+		}
+		log.Debug().Err(err).Msgf("[Discovery] Failed to get the public address from %s", service)
+		errs = append(errs, fmt.Errorf("%s: %w", service, err))
+	}
+	err := errors.Join(errs...)
+	if err == nil {
+		err = errors.New("public-ip-services is empty")
+	}
+	log.Error().Err(err).Msgf("[Discovery] Failed to discover the public IPv%s address", network)
+
+	return "", err
+}
+
+// httpAddress reads the address from a service answering with it as plain text, connecting
+// over the given network so the answer is of the wanted family.
+func httpAddress(
+	ctx context.Context,
+	network string,
+	url string,
+) (string, error) {
+	dialer := &net.Dialer{}
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _ string, address string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+		},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", response.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxAnswerSize))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
@@ -0,0 +1,123 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+const (
+	stunHeaderSize      = 20
+	stunMagicCookie     = 0x2112A442
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunMappedAddress   = 0x0001
+	stunXORMapped       = 0x0020
+	stunFamilyIPv4      = 0x01
+	stunFamilyIPv6      = 0x02
+	maxSTUNMessageSize  = 1500
+)
+
+// stunAddress sends a STUN binding request (RFC 5389) and returns the address the server saw it from.
+func stunAddress(
+	ctx context.Context,
+	network string,
+	server string,
+) (string, error) {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return "", err
+		}
+	}
+
+	request := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(request[0:], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:], stunMagicCookie)
+	if _, err = rand.Read(request[8:]); err != nil {
+		return "", err
+	}
+	if _, err = conn.Write(request); err != nil {
+		return "", err
+	}
+
+	response := make([]byte, maxSTUNMessageSize)
+	n, err := conn.Read(response)
+	if err != nil {
+		return "", err
+	}
+
+	return parseBindingResponse(response[:n], request[4:stunHeaderSize])
+}
+
+// parseBindingResponse returns the mapped address of a binding response, preferring the
+// XOR-MAPPED-ADDRESS attribute. cookieAndID are the magic cookie and transaction ID of the request.
+func parseBindingResponse(
+	message []byte,
+	cookieAndID []byte,
+) (string, error) {
+	if len(message) < stunHeaderSize ||
+		binary.BigEndian.Uint16(message[0:]) != stunBindingResponse ||
+		!bytes.Equal(message[4:stunHeaderSize], cookieAndID) {
+		return "", errors.New("not a binding response to the request")
+	}
+
+	var mapped net.IP
+	attributes := message[stunHeaderSize:]
+	for len(attributes) >= 4 {
+		attributeType := binary.BigEndian.Uint16(attributes[0:])
+		length := int(binary.BigEndian.Uint16(attributes[2:]))
+		if len(attributes) < 4+length {
+			break
+		}
+		value := attributes[4 : 4+length]
+		switch attributeType {
+		case stunXORMapped:
+			if ip := attributeIP(value, cookieAndID); ip != nil {
+				return ip.String(), nil
+			}
+		case stunMappedAddress:
+			mapped = attributeIP(value, nil)
+		}
+		// Attributes are padded to a multiple of 4 bytes
+		attributes = attributes[min(len(attributes), 4+(length+3)&^3):]
+	}
+	if mapped == nil {
+		return "", errors.New("binding response has no mapped address")
+	}
+
+	return mapped.String(), nil
+}
+
+// attributeIP reads the address of a (XOR-)MAPPED-ADDRESS value, XORed with mask unless it is nil.
+func attributeIP(
+	value []byte,
+	mask []byte,
+) net.IP {
+	size := 0
+	switch {
+	case len(value) >= 8 && value[1] == stunFamilyIPv4:
+		size = net.IPv4len
+	case len(value) >= 20 && value[1] == stunFamilyIPv6:
+		size = net.IPv6len
+	default:
+		return nil
+	}
+	ip := make(net.IP, size)
+	copy(ip, value[4:4+size])
+	if mask != nil {
+		for i := range ip {
+			ip[i] ^= mask[i]
+		}
+	}
+
+	return ip
+}
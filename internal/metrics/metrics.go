@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// Panics counts recovered panics by component
+	Panics = prometheus.NewCounterVec(prometheus.CounterOpts{ //nolint:gochecknoglobals // Required for metrics
+		Name: "greydns_panics_total",
+		Help: "Number of panics recovered, by component",
+	}, []string{"component"})
+	// ZoneDNSSEC is 1 for zones whose DNSSEC status is active
+	ZoneDNSSEC = prometheus.NewGaugeVec(prometheus.GaugeOpts{ //nolint:gochecknoglobals // Required for metrics
+		Name: "greydns_zone_dnssec_active",
+		Help: "Whether DNSSEC is active for a zone with managed records",
+	}, []string{"zone"})
+	// ZoneRecords is the number of managed records of a zone
+	ZoneRecords = prometheus.NewGaugeVec(prometheus.GaugeOpts{ //nolint:gochecknoglobals // Required for metrics
+		Name: "greydns_zone_managed_records",
+		Help: "Number of records greydns manages in a zone",
+	}, []string{"zone"})
+	// ZoneLastRefresh is when the records of a zone were last fetched from the provider
+	ZoneLastRefresh = prometheus.NewGaugeVec(prometheus.GaugeOpts{ //nolint:gochecknoglobals // Required for metrics
+		Name: "greydns_zone_last_refresh_timestamp_seconds",
+		Help: "Unix time of the last successful cache refresh of a zone",
+	}, []string{"zone"})
+	// ZoneLastReconcile is when a service in a zone last had its records checked successfully
+	ZoneLastReconcile = prometheus.NewGaugeVec(prometheus.GaugeOpts{ //nolint:gochecknoglobals // Required for metrics
+		Name: "greydns_zone_last_reconcile_timestamp_seconds",
+		Help: "Unix time of the last successful full reconcile of a service in a zone",
+	}, []string{"zone"})
+	// RecordPropagated is 1 for records a resolver of propagation-resolvers answers as expected
+	RecordPropagated = prometheus.NewGaugeVec(prometheus.GaugeOpts{ //nolint:gochecknoglobals // Required for metrics
+		Name: "greydns_record_propagated",
+		Help: "Whether a public or corporate resolver answers a managed record with its contents",
+	}, []string{"zone", "name", "type", "resolver"})
+	// HealthCheckHealthy is 1 for targets of records CloudFlare health checks report healthy
+	HealthCheckHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{ //nolint:gochecknoglobals // Required for metrics
+		Name: "greydns_health_check_healthy",
+		Help: "Whether the health check of a target of a managed record reports it healthy",
+	}, []string{"zone", "name", "address"})
+	// DomainConflicts is the number of services refused a record because another service owns it
+	DomainConflicts = prometheus.NewGaugeVec(prometheus.GaugeOpts{ //nolint:gochecknoglobals // Required for metrics
+		Name: "greydns_domain_conflicts",
+		Help: "Number of services claiming a record owned by another service",
+	}, []string{"name", "type", "owner"})
+	// ProviderRequests counts the calls to a DNS provider by operation and result
+	ProviderRequests = prometheus.NewCounterVec(prometheus.CounterOpts{ //nolint:gochecknoglobals // Required for metrics
+		Name: "greydns_provider_requests_total",
+		Help: "Number of DNS provider calls, by provider, operation and result",
+	}, []string{"provider", "operation", "result"})
+	// ProviderRequestDuration observes how long the calls to a DNS provider take
+	ProviderRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{ //nolint:gochecknoglobals // Required for metrics
+		Name:    "greydns_provider_request_duration_seconds",
+		Help:    "Duration of DNS provider calls, by provider and operation",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"provider", "operation"})
+	// ProviderRateLimitWait observes how long calls wait for the provider-rate-limit
+	ProviderRateLimitWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{ //nolint:gochecknoglobals // Required for metrics
+		Name:    "greydns_provider_rate_limit_wait_seconds",
+		Help:    "Time DNS provider calls waited for the client side rate limiter",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"provider"})
+)
+
+const (
+	ResultSuccess     = "success"
+	ResultError       = "error"
+	ResultRateLimited = "rate_limited"
+	ResultClientError = "client_error"
+	ResultServerError = "server_error"
+)
+
+// ObserveProviderCall records a provider call that started at start.
+func ObserveProviderCall(
+	provider string,
+	operation string,
+	result string,
+	start time.Time,
+) {
+	ProviderRequests.WithLabelValues(provider, operation, result).Inc()
+	ProviderRequestDuration.WithLabelValues(provider, operation).Observe(time.Since(start).Seconds())
+}
+
+// Metrics are served by the controller-runtime metrics endpoint on metrics-addr.
+func init() { //nolint:gochecknoinits // Required for metrics
+	metrics.Registry.MustRegister(
+		Panics,
+		ZoneDNSSEC,
+		ZoneRecords,
+		ZoneLastRefresh,
+		ZoneLastReconcile,
+		RecordPropagated,
+		HealthCheckHealthy,
+		DomainConflicts,
+		ProviderRequests,
+		ProviderRequestDuration,
+		ProviderRateLimitWait,
+	)
+}
@@ -0,0 +1,194 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	KindCreate  = "create"
+	KindUpdate  = "update"
+	KindDelete  = "delete"
+	KindFailure = "failure"
+
+	defaultEvents   = "create,update,delete,failure"
+	defaultTemplate = `{{ if .DryRun }}[dry-run] {{ end }}greydns {{ .Kind }}{{ if .Type }} {{ .Type }}{{ end }} {{ .Name }}` +
+		`{{ if .Service }} ({{ .Service }}){{ end }}{{ if .New }}: {{ join .New ", " }}{{ end }}{{ if .Error }} failed: {{ .Error }}{{ end }}`
+	queueSize   = 100
+	sendTimeout = 10 * time.Second
+)
+
+var (
+	queue   = make(chan Event, queueSize) //nolint:gochecknoglobals // Required for notifications
+	started atomic.Bool                   //nolint:gochecknoglobals // Required for notifications
+)
+
+// Event is a record change or a failed reconcile sent to the notification targets.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Service string    `json:"service,omitempty"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type,omitempty"`
+	Old     []string  `json:"old,omitempty"`
+	New     []string  `json:"new,omitempty"`
+	DryRun  bool      `json:"dryRun,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Message string    `json:"message"`
+}
+
+// target is a notification endpoint and how its payload is built from an event.
+type target struct {
+	key     string
+	payload func(event Event) interface{}
+}
+
+func targets() []target {
+	return []target{
+		{key: "notify-slack-url", payload: func(event Event) interface{} {
+			return map[string]string{"text": event.Message}
+		}},
+		{key: "notify-discord-url", payload: func(event Event) interface{} {
+			return map[string]string{"content": event.Message}
+		}},
+		{key: "notify-webhook-url", payload: func(event Event) interface{} {
+			return event
+		}},
+	}
+}
+
+// Start sends queued events until ctx is done. Events published before Start are dropped.
+func Start(
+	ctx context.Context,
+) {
+	started.Store(true)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-queue:
+				send(ctx, event)
+			}
+		}
+	}()
+}
+
+// Publish queues an event for every configured target without waiting for it to be sent,
+// events are dropped when the queue is full so a slow target never holds up reconciles.
+func Publish(
+	event Event,
+) {
+	if !started.Load() || !enabled(event.Kind) {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	select {
+	case queue <- event:
+	default:
+		log.Warn().Msgf("[Notify] Queue is full, dropping %s notification for %s", event.Kind, event.Name)
+	}
+}
+
+// enabled reports whether a kind of event is listed in notify-events and any target is configured.
+func enabled(
+	kind string,
+) bool {
+	if !slices.Contains(utils.SplitList(cfg.GetConfigValue("notify-events", defaultEvents)), kind) {
+		return false
+	}
+
+	return slices.ContainsFunc(targets(), func(t target) bool {
+		return cfg.GetConfigValue(t.key, "") != ""
+	})
+}
+
+func send(
+	ctx context.Context,
+	event Event,
+) {
+	defer utils.Recover("notify", nil)
+
+	message, err := render(event)
+	if err != nil {
+		log.Error().Err(err).Msg("[Notify] Failed to render notify-template, using the default")
+		message, _ = renderTemplate(defaultTemplate, event)
+	}
+	event.Message = message
+
+	for _, t := range targets() {
+		url := cfg.GetConfigValue(t.key, "")
+		if url == "" {
+			continue
+		}
+		if err = post(ctx, url, t.payload(event)); err != nil {
+			log.Error().Err(err).Msgf("[Notify] Failed to send %s notification to %s", event.Kind, t.key)
+		}
+	}
+}
+
+func render(
+	event Event,
+) (string, error) {
+	return renderTemplate(cfg.GetConfigValue("notify-template", defaultTemplate), event)
+}
+
+func renderTemplate(
+	text string,
+	event Event,
+) (string, error) {
+	tmpl, err := template.New("notify").Funcs(template.FuncMap{"join": strings.Join}).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var message strings.Builder
+	if err = tmpl.Execute(&message, event); err != nil {
+		return "", err
+	}
+
+	return message.String(), nil
+}
+
+func post(
+	ctx context.Context,
+	url string,
+	payload interface{},
+) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	return nil
+}
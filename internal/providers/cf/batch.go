@@ -0,0 +1,120 @@
+package providers
+
+import (
+	"context"
+	"strconv"
+
+	cloudflare "github.com/cloudflare/cloudflare-go/v4"
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/rs/zerolog/log"
+
+	"github.com/math280h/greydns/internal/audit"
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/providers"
+)
+
+const (
+	// CloudFlare accepts 200 changes per batch call on every plan
+	defaultBatchSize = 200
+)
+
+func batchSize() int {
+	size, err := strconv.Atoi(cfg.GetConfigValue("batch-size", strconv.Itoa(defaultBatchSize)))
+	if err != nil || size <= 0 {
+		log.Warn().Msgf("[Config] batch-size must be a positive integer, using %d", defaultBatchSize)
+		return defaultBatchSize
+	}
+
+	return size
+}
+
+// batchChunks groups record sets into batches of at most batch-size records, a record set
+// is never split so a larger one gets a batch of its own.
+func batchChunks(records []providers.Record) [][]providers.Record {
+	size := batchSize()
+	chunks := make([][]providers.Record, 0, 1)
+	var chunk []providers.Record
+	count := 0
+	for _, record := range records {
+		if len(chunk) > 0 && count+len(record.Contents) > size {
+			chunks = append(chunks, chunk)
+			chunk, count = nil, 0
+		}
+		chunk = append(chunk, record)
+		count += len(record.Contents)
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks
+}
+
+// DeleteRecordSets deletes several record sets of a zone with as few batch calls as possible
+// and returns how many of them were deleted, in order, before a batch failed.
+func DeleteRecordSets(
+	ctx context.Context,
+	recordSets [][]dns.RecordResponse,
+	zoneID string,
+) (int, error) {
+	total := 0
+	for _, recordSet := range recordSets {
+		total += len(recordSet)
+	}
+	if err := providers.ReserveDeletions(total); err != nil {
+		logger(ctx).Error().Err(err).Msgf("[CF Provider] Not deleting %d records", total)
+		return 0, err
+	}
+
+	deleted := 0
+	size := batchSize()
+	for deleted < len(recordSets) {
+		// Record sets are not split across batches, a batch holds at least one
+		chunk := recordSets[deleted : deleted+1]
+		count := len(chunk[0])
+		for end := deleted + 1; end < len(recordSets) && count+len(recordSets[end]) <= size; end++ {
+			count += len(recordSets[end])
+			chunk = recordSets[deleted : end+1]
+		}
+
+		deletes := make([]dns.RecordBatchParamsDelete, 0, count)
+		for _, recordSet := range chunk {
+			for _, record := range recordSet {
+				deletes = append(deletes, dns.RecordBatchParamsDelete{ID: cloudflare.F(record.ID)})
+			}
+		}
+
+		var err error
+		if providers.DryRun() {
+			logger(ctx).Info().Msgf("[CF Provider] [dry-run] Would delete %d records", len(deletes))
+		} else {
+			logger(ctx).Info().Msgf("[CF Provider] Attempting to delete %d records", len(deletes))
+			callCtx, cancel := providers.WithTimeout(ctx)
+			_, err = api(zoneID).DNS.Records.Batch(
+				callCtx,
+				dns.RecordBatchParams{
+					ZoneID:  cloudflare.F(zoneID),
+					Deletes: cloudflare.F(deletes),
+				},
+			)
+			cancel()
+			markZoneChanged(zoneID)
+		}
+		for _, recordSet := range chunk {
+			audit.Record(ctx, recordEvent(
+				audit.ActionDelete,
+				providers.Record{Name: recordSet[0].Name, Type: string(recordSet[0].Type)},
+				zoneID,
+				recordSet,
+				nil,
+			), err)
+		}
+		if err != nil {
+			logger(ctx).Error().Err(err).Msg("[CF Provider] Failed to delete records")
+			return deleted, providerError(err)
+		}
+		deleted += len(chunk)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,61 @@
+package providers
+
+import (
+	"maps"
+	"slices"
+	"sync"
+
+	cloudflare "github.com/cloudflare/cloudflare-go/v4"
+)
+
+const (
+	// Secret keys holding the API token of a single zone, e.g. cloudflare.example.com
+	zoneTokenPrefix = "cloudflare."
+)
+
+var (
+	zoneNamesLock sync.Mutex                //nolint:gochecknoglobals // Required for zone tokens
+	zoneNames     = make(map[string]string) //nolint:gochecknoglobals // Required for zone tokens
+)
+
+// rememberZoneName lets zone tokens given by name be used for calls that only know the zone ID.
+func rememberZoneName(
+	zoneID string,
+	name string,
+) {
+	zoneNamesLock.Lock()
+	defer zoneNamesLock.Unlock()
+	zoneNames[zoneID] = name
+}
+
+// api returns the client for a zone, its own token if there is one or the account credentials.
+func api(
+	zoneID string,
+) *cloudflare.Client {
+	clients := *zoneAPIs.Load()
+	if client, ok := clients[zoneID]; ok {
+		return client
+	}
+
+	zoneNamesLock.Lock()
+	name := zoneNames[zoneID]
+	zoneNamesLock.Unlock()
+
+	return apiForName(name, clients)
+}
+
+func apiForName(
+	name string,
+	clients map[string]*cloudflare.Client,
+) *cloudflare.Client {
+	if client, ok := clients[name]; ok {
+		return client
+	}
+
+	return cloudflareAPI.Load()
+}
+
+// tokenZones are the zones with a token of their own.
+func tokenZones() []string {
+	return slices.Sorted(maps.Keys(*zoneAPIs.Load()))
+}
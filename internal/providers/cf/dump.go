@@ -0,0 +1,124 @@
+package providers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go/v4/option"
+	"github.com/rs/zerolog"
+
+	cfg "github.com/math280h/greydns/internal/config"
+)
+
+const (
+	// Larger payloads, e.g. full record listings, are cut off in the log
+	maxDumpBytes = 64 << 10
+	redacted     = "[REDACTED]"
+)
+
+var (
+	// Headers carrying credentials, never logged
+	secretHeaders = []string{"Authorization", "X-Auth-Key", "X-Auth-Email", "X-Auth-User-Service-Key"} //nolint:gochecknoglobals // Required for the debug dump
+)
+
+// dumpDomains returns the domains whose provider calls are dumped, only read at the trace log level.
+func dumpDomains() []string {
+	if zerolog.GlobalLevel() > zerolog.TraceLevel {
+		return nil
+	}
+	var domains []string
+	for _, domain := range strings.Split(cfg.GetConfigValue("debug-dump-domains", ""), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+
+	return domains
+}
+
+// dumped wraps a call to log its request and response payloads when they mention one of the
+// debug-dump-domains, `*` dumps every call.
+func dumped(
+	next option.MiddlewareNext,
+) option.MiddlewareNext {
+	domains := dumpDomains()
+	if len(domains) == 0 {
+		return next
+	}
+
+	return func(req *http.Request) (*http.Response, error) {
+		var requestBody []byte
+		if req.GetBody != nil {
+			if body, err := req.GetBody(); err == nil {
+				requestBody, _ = io.ReadAll(body)
+				body.Close()
+			}
+		}
+		response, err := next(req)
+		var responseBody []byte
+		if response != nil && response.Body != nil {
+			responseBody, _ = io.ReadAll(response.Body)
+			response.Body.Close()
+			response.Body = io.NopCloser(bytes.NewReader(responseBody))
+		}
+		if !mentions(domains, req.URL.String(), requestBody, responseBody) {
+			return response, err
+		}
+
+		event := zerolog.Ctx(req.Context()).Trace().
+			Str("method", req.Method).
+			Str("url", req.URL.String()).
+			Interface("requestHeaders", sanitizedHeaders(req.Header)).
+			Str("request", truncated(requestBody))
+		if response != nil {
+			event = event.
+				Int("status", response.StatusCode).
+				Interface("responseHeaders", sanitizedHeaders(response.Header)).
+				Str("response", truncated(responseBody))
+		}
+		event.Err(err).Msg("[CF Provider] Provider call")
+
+		return response, err
+	}
+}
+
+func mentions(
+	domains []string,
+	url string,
+	requestBody []byte,
+	responseBody []byte,
+) bool {
+	payload := strings.ToLower(url + string(requestBody) + string(responseBody))
+	for _, domain := range domains {
+		if domain == "*" || strings.Contains(payload, domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sanitizedHeaders(
+	headers http.Header,
+) http.Header {
+	sanitized := headers.Clone()
+	for _, header := range secretHeaders {
+		if sanitized.Get(header) != "" {
+			sanitized.Set(header, redacted)
+		}
+	}
+
+	return sanitized
+}
+
+func truncated(
+	body []byte,
+) string {
+	if len(body) <= maxDumpBytes {
+		return string(body)
+	}
+
+	return string(body[:maxDumpBytes]) + "... (truncated)"
+}
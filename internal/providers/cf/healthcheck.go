@@ -0,0 +1,149 @@
+package providers
+
+import (
+	"context"
+
+	cloudflare "github.com/cloudflare/cloudflare-go/v4"
+	"github.com/cloudflare/cloudflare-go/v4/healthchecks"
+	"github.com/cloudflare/cloudflare-go/v4/packages/pagination"
+
+	"github.com/math280h/greydns/internal/providers"
+)
+
+const (
+	// Largest pages CloudFlare returns for health checks
+	healthChecksPerPage = 1000
+)
+
+// HealthCheckSpec is a CloudFlare health check greydns provisions for the target of a record.
+// The description carries the ownership marker of the service.
+type HealthCheckSpec struct {
+	Name        string
+	Address     string
+	Description string
+	Check       providers.HealthCheck
+}
+
+// ListHealthChecks returns the health checks of a zone owned by this greydns instance.
+func ListHealthChecks(
+	ctx context.Context,
+	zoneID string,
+) ([]healthchecks.Healthcheck, error) {
+	listCtx, cancel := providers.WithListTimeout(ctx)
+	defer cancel()
+	found, err := listPages(listCtx, func(
+		ctx context.Context,
+		page int,
+	) (*pagination.V4PagePaginationArray[healthchecks.Healthcheck], error) {
+		return api(zoneID).Healthchecks.List(ctx, healthchecks.HealthcheckListParams{
+			ZoneID:  cloudflare.F(zoneID),
+			Page:    cloudflare.F(float64(page)),
+			PerPage: cloudflare.F(float64(healthChecksPerPage)),
+		})
+	})
+	if err != nil {
+		logger(ctx).Error().Err(err).Msgf("[CF Provider] Failed to get health checks of zone %s", zoneID)
+		return nil, providerError(err)
+	}
+
+	owned := make([]healthchecks.Healthcheck, 0, len(found))
+	for _, healthCheck := range found {
+		if _, _, ok := providers.OwnerService(healthCheck.Description); ok {
+			owned = append(owned, healthCheck)
+		}
+	}
+
+	return owned, nil
+}
+
+// HealthCheckMatches reports whether an existing health check already checks what the spec asks for.
+func HealthCheckMatches(
+	existing healthchecks.Healthcheck,
+	spec HealthCheckSpec,
+) bool {
+	if existing.Address != spec.Address || existing.Type != spec.Check.Protocol || existing.Description != spec.Description {
+		return false
+	}
+	if spec.Check.Protocol == "TCP" {
+		return existing.TCPConfig.Port == int64(spec.Check.Port)
+	}
+
+	return existing.HTTPConfig.Port == int64(spec.Check.Port) && existing.HTTPConfig.Path == spec.Check.Path
+}
+
+// SaveHealthCheck creates the health check of the spec, or updates the health check with the
+// given ID when it is not empty.
+func SaveHealthCheck(
+	ctx context.Context,
+	zoneID string,
+	healthCheckID string,
+	spec HealthCheckSpec,
+) error {
+	if providers.DryRun() {
+		logger(ctx).Info().Msgf("[CF Provider] [%s] [dry-run] Would save health check of %s", spec.Name, spec.Address)
+		return nil
+	}
+	params := healthchecks.QueryHealthcheckParam{
+		Name:        cloudflare.F(spec.Name),
+		Address:     cloudflare.F(spec.Address),
+		Description: cloudflare.F(spec.Description),
+		Type:        cloudflare.F(spec.Check.Protocol),
+	}
+	if spec.Check.Protocol == "TCP" {
+		params.TCPConfig = cloudflare.F(healthchecks.TCPConfigurationParam{
+			Method: cloudflare.F(healthchecks.TCPConfigurationMethodConnectionEstablished),
+			Port:   cloudflare.F(int64(spec.Check.Port)),
+		})
+	} else {
+		params.HTTPConfig = cloudflare.F(healthchecks.HTTPConfigurationParam{
+			Path: cloudflare.F(spec.Check.Path),
+			Port: cloudflare.F(int64(spec.Check.Port)),
+		})
+	}
+
+	callCtx, cancel := providers.WithTimeout(ctx)
+	defer cancel()
+	var err error
+	if healthCheckID == "" {
+		_, err = api(zoneID).Healthchecks.New(callCtx, healthchecks.HealthcheckNewParams{
+			ZoneID:           cloudflare.F(zoneID),
+			QueryHealthcheck: params,
+		})
+	} else {
+		_, err = api(zoneID).Healthchecks.Update(callCtx, healthCheckID, healthchecks.HealthcheckUpdateParams{
+			ZoneID:           cloudflare.F(zoneID),
+			QueryHealthcheck: params,
+		})
+	}
+	if err != nil {
+		logger(ctx).Error().Err(err).Msgf("[CF Provider] [%s] Failed to save health check", spec.Name)
+		return providerError(err)
+	}
+	logger(ctx).Info().Msgf("[CF Provider] [%s] Saved health check of %s", spec.Name, spec.Address)
+
+	return nil
+}
+
+func DeleteHealthCheck(
+	ctx context.Context,
+	zoneID string,
+	healthCheck healthchecks.Healthcheck,
+) error {
+	if providers.DryRun() {
+		logger(ctx).Info().Msgf("[CF Provider] [%s] [dry-run] Would delete health check", healthCheck.Name)
+		return nil
+	}
+
+	callCtx, cancel := providers.WithTimeout(ctx)
+	defer cancel()
+	_, err := api(zoneID).Healthchecks.Delete(callCtx, healthCheck.ID, healthchecks.HealthcheckDeleteParams{
+		ZoneID: cloudflare.F(zoneID),
+	})
+	if err != nil {
+		logger(ctx).Error().Err(err).Msgf("[CF Provider] [%s] Failed to delete health check", healthCheck.Name)
+		return providerError(err)
+	}
+	logger(ctx).Info().Msgf("[CF Provider] [%s] Deleted health check", healthCheck.Name)
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package providers
+
+import (
+	"context"
+
+	cloudflare "github.com/cloudflare/cloudflare-go/v4"
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+
+	"github.com/math280h/greydns/internal/providers"
+)
+
+const (
+	heartbeatComment = "greydns heartbeat"
+	heartbeatTTL     = 60
+)
+
+// WriteHeartbeat creates or overwrites the heartbeat TXT record. It carries no ownership marker
+// so it is never cached, reconciled or cleaned up, and is left out of the audit log as it
+// changes on every cache refresh. Only a record with the heartbeat comment is overwritten, other
+// TXT records of the name, e.g. for domain verification, are left alone.
+func WriteHeartbeat(
+	ctx context.Context,
+	name string,
+	content string,
+	zoneID string,
+) error {
+	if providers.DryRun() {
+		logger(ctx).Debug().Msgf("[CF Provider] [%s] [dry-run] Would write heartbeat %s", name, content)
+		return nil
+	}
+	found, err := FindUnmanagedRecords(ctx, name, "TXT", zoneID)
+	if err != nil {
+		return err
+	}
+	heartbeatID := ""
+	for _, existing := range found {
+		if existing.Comment == heartbeatComment {
+			heartbeatID = existing.ID
+			break
+		}
+	}
+	record := dns.TXTRecordParam{
+		Type:    cloudflare.F(dns.TXTRecordTypeTXT),
+		Name:    cloudflare.F(name),
+		Content: cloudflare.F(content),
+		TTL:     cloudflare.F(dns.TTL(heartbeatTTL)),
+		Comment: cloudflare.F(heartbeatComment),
+	}
+
+	callCtx, cancel := providers.WithTimeout(ctx)
+	defer cancel()
+	if heartbeatID != "" {
+		_, err = api(zoneID).DNS.Records.Update(
+			callCtx,
+			heartbeatID,
+			dns.RecordUpdateParams{
+				ZoneID: cloudflare.F(zoneID),
+				Record: record,
+			},
+		)
+	} else {
+		_, err = api(zoneID).DNS.Records.New(
+			callCtx,
+			dns.RecordNewParams{
+				ZoneID: cloudflare.F(zoneID),
+				Record: record,
+			},
+		)
+	}
+	if err != nil {
+		logger(ctx).Error().Err(err).Msgf("[CF Provider] [%s] Failed to write heartbeat", name)
+		return err
+	}
+
+	return nil
+}
@@ -3,227 +3,1110 @@ package providers
 import (
 	"context"
 	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
 	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	cloudflare "github.com/cloudflare/cloudflare-go/v4"
 	"github.com/cloudflare/cloudflare-go/v4/dns"
 	"github.com/cloudflare/cloudflare-go/v4/option"
+	"github.com/cloudflare/cloudflare-go/v4/packages/pagination"
 	"github.com/cloudflare/cloudflare-go/v4/zones"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
 	v1 "k8s.io/api/core/v1"
 
+	"github.com/math280h/greydns/internal/audit"
 	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/metrics"
+	"github.com/math280h/greydns/internal/providers"
+	"github.com/math280h/greydns/internal/registry"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	// A TTL of 1 means automatic, everything else has to be within 60 and 86400 seconds
+	automaticTTL = 1
+	minTTL       = 60
+	maxTTL       = 86400
+
+	providerName = "cloudflare"
+
+	// Error codes of records refused because of an existing record
+	codeRecordExists          = 81053
+	codeIdenticalRecordExists = 81057
+	codeCNAMEConflict         = 81054
 )
 
 var (
-	cloudflareAPI  *cloudflare.Client //nolint:gochecknoglobals // Required for cloudflare
-	commentPattern = regexp.MustCompile(`^\[greydns - Do not manually edit].*$`)
+	// The clients are swapped when the credentials are rotated while reconciles may be running
+	cloudflareAPI         atomic.Pointer[cloudflare.Client]             //nolint:gochecknoglobals // Required for cloudflare
+	zoneAPIs              atomic.Pointer[map[string]*cloudflare.Client] //nolint:gochecknoglobals // Required for cloudflare
+	hasAccountCredentials atomic.Bool                                   //nolint:gochecknoglobals // Required for cloudflare
+	limiter               *rate.Limiter                                 //nolint:gochecknoglobals // Shared across reconnects
+	commentPattern        = regexp.MustCompile(`^\[greydns - Do not manually edit].*$`)
+	zoneIDPattern         = regexp.MustCompile(`^[0-9a-f]{32}$`)
 )
 
+// Connect creates the clients from the credentials. The account wide credential is either an
+// API token in cloudflare or a Global API key in cloudflare-api-key with cloudflare-email,
+// tokens for single zones are given as cloudflare.<zone name or ID>.
 func Connect(
-	secret *v1.Secret,
+	credentials cfg.Credentials,
 ) {
-	cloudflareAPI = cloudflare.NewClient(
-		option.WithAPIToken(string(secret.Data["cloudflare"])),
-	)
+	if limiter == nil {
+		limiter = providers.NewRateLimiter()
+	}
+
+	switch {
+	case len(credentials["cloudflare"]) > 0:
+		cloudflareAPI.Store(newClient(option.WithAPIToken(string(credentials["cloudflare"]))))
+		hasAccountCredentials.Store(true)
+	case len(credentials["cloudflare-api-key"]) > 0:
+		cloudflareAPI.Store(newClient(
+			option.WithAPIKey(string(credentials["cloudflare-api-key"])),
+			option.WithAPIEmail(string(credentials["cloudflare-email"])),
+		))
+		hasAccountCredentials.Store(true)
+	default:
+		// Zones without a token of their own fail with an authentication error
+		cloudflareAPI.Store(newClient())
+		hasAccountCredentials.Store(false)
+	}
+
+	clients := make(map[string]*cloudflare.Client)
+	for key, token := range credentials {
+		zone, ok := strings.CutPrefix(key, zoneTokenPrefix)
+		if !ok || zone == "" {
+			continue
+		}
+		clients[zone] = newClient(option.WithAPIToken(string(token)))
+	}
+	zoneAPIs.Store(&clients)
+	if len(clients) > 0 {
+		log.Info().Msgf("[CF Provider] Using zone tokens for %d zones", len(clients))
+	}
+}
+
+func newClient(
+	opts ...option.RequestOption,
+) *cloudflare.Client {
+	if base := baseURL(); base != "" {
+		opts = append(opts, option.WithBaseURL(base))
+	}
+
+	return cloudflare.NewClient(append(
+		opts,
+		// Every request, including each page of a listing, waits for a token
+		option.WithMiddleware(func(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+			waitStart := time.Now()
+			if err := limiter.Wait(req.Context()); err != nil {
+				return nil, err
+			}
+			metrics.ProviderRateLimitWait.WithLabelValues(providerName).Observe(time.Since(waitStart).Seconds())
+
+			start := time.Now()
+			response, err := dumped(next)(req)
+			metrics.ObserveProviderCall(providerName, operationName(req), callResult(response, err), start)
+			return response, err
+		}),
+	)...)
+}
+
+// operationName identifies the API operation of a request by its method and path, with the
+// IDs in the path replaced so every zone and record shares the same operation.
+func operationName(
+	req *http.Request,
+) string {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	for i, segment := range segments {
+		if zoneIDPattern.MatchString(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	path := strings.Join(segments, "/")
+	// The base URL prefix, e.g. client/v4, is not part of the operation
+	if index := strings.Index(path, "zones"); index > 0 {
+		path = path[index:]
+	}
+
+	return req.Method + " " + path
+}
+
+func callResult(
+	response *http.Response,
+	err error,
+) string {
+	switch {
+	case err != nil && response == nil:
+		return metrics.ResultError
+	case response.StatusCode == http.StatusTooManyRequests:
+		return metrics.ResultRateLimited
+	case response.StatusCode >= http.StatusInternalServerError:
+		return metrics.ResultServerError
+	case response.StatusCode >= http.StatusBadRequest:
+		return metrics.ResultClientError
+	default:
+		return metrics.ResultSuccess
+	}
+}
+
+// baseURL is the API endpoint set in cloudflare-base-url, e.g. a mock server or an API gateway.
+// It is empty for the SDK default or when the URL is invalid.
+func baseURL() string {
+	base := cfg.GetConfigValue("cloudflare-base-url", "")
+	if base == "" {
+		return ""
+	}
+	parsed, err := url.Parse(base)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		log.Error().Msgf("[Config] cloudflare-base-url %q is not an http or https URL, using the default endpoint", base)
+		return ""
+	}
+
+	return base
+}
+
+// IsAuthError reports whether CloudFlare rejected the API token.
+func IsAuthError(err error) bool {
+	var apiErr *cloudflare.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	return apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden
+}
+
+// IsRateLimitError reports whether CloudFlare throttled a request.
+func IsRateLimitError(err error) bool {
+	var apiErr *cloudflare.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	return apiErr.StatusCode == http.StatusTooManyRequests
+}
+
+// providerError wraps a failed call with the category of the CloudFlare response, calls that
+// got no response at all are transient.
+func providerError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var apiErr *cloudflare.Error
+	if !errors.As(err, &apiErr) {
+		return &providers.ProviderError{Category: providers.CategoryTransient, Err: err}
+	}
+
+	category := providers.CategoryPermanent
+	switch {
+	case IsAuthError(err):
+		category = providers.CategoryAuth
+	case IsRateLimitError(err):
+		category = providers.CategoryRateLimit
+	case apiErr.StatusCode == http.StatusNotFound:
+		category = providers.CategoryNotFound
+	case apiErr.StatusCode == http.StatusConflict || recordExists(apiErr):
+		category = providers.CategoryConflict
+	case apiErr.StatusCode >= http.StatusInternalServerError:
+		category = providers.CategoryTransient
+	}
+
+	return &providers.ProviderError{Category: category, Err: err}
+}
+
+// IsIdenticalRecordError reports whether CloudFlare refused to create a record because an
+// identical one exists, a create that can never succeed.
+func IsIdenticalRecordError(err error) bool {
+	var apiErr *cloudflare.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, detail := range apiErr.Errors {
+		if detail.Code == codeIdenticalRecordExists {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordExists reports whether CloudFlare refused a record because an identical or clashing one exists.
+func recordExists(apiErr *cloudflare.Error) bool {
+	for _, detail := range apiErr.Errors {
+		switch detail.Code {
+		case codeRecordExists, codeIdenticalRecordExists, codeCNAMEConflict:
+			return true
+		}
+	}
+
+	return false
+}
+
+// logger returns the logger of the reconcile in ctx, tagged with this provider.
+func logger(ctx context.Context) *zerolog.Logger {
+	providerLogger := zerolog.Ctx(ctx).With().Str("provider", providerName).Logger()
+	return &providerLogger
 }
 
 func CleanupRecords(
-	existingRecords map[string]dns.RecordResponse,
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
 	service *v1.Service,
-	name string,
+	records []providers.Record,
 	zoneID string,
-) {
-	// Check if namespace/service already has another record using comments, if so, delete it in existingRecords
-	for _, record := range existingRecords {
-		if record.Comment == "[greydns - Do not manually edit]"+service.Namespace+"/"+service.Name {
-			// Ensure its not the current record
-			if service.ObjectMeta.Annotations["greydns.io/domain"] == record.Name {
-				continue
-			}
-			log.Info().Msgf("[CF Provider] [%s] Found old record, cleaning up", name)
-			err := DeleteRecord(record.ID, zoneID)
-			if err != nil {
-				log.Error().Err(err).Msgf("[CF Provider] [%s] Failed to delete record", name)
-			}
-			delete(existingRecords, record.Name)
+) ([]dns.RecordResponse, error) {
+	removed := make([]dns.RecordResponse, 0)
+	var errs []error
+	desired := make(map[string]bool, len(records))
+	for _, record := range records {
+		desired[providers.RecordKey(zoneID, record.Name, record.Type)] = true
+	}
+
+	// Check if namespace/service owns records that are no longer desired, if so, delete them in existingRecords
+	staleKeys := make(map[string][]string)
+	for key, recordSet := range existingRecords {
+		if !providers.IsOwnedBy(recordSet[0].Comment, service.Namespace, service.Name) {
+			continue
+		}
+		// Ensure its not one of the current records
+		if desired[key] {
+			continue
 		}
+		logger(ctx).Info().Msgf("[CF Provider] [%s] Found old record, cleaning up", recordSet[0].Name)
+		// Records left behind in another zone are deleted from that zone
+		staleKeys[providers.KeyZone(key)] = append(staleKeys[providers.KeyZone(key)], key)
 	}
+
+	for staleZoneID, keys := range staleKeys {
+		recordSets := make([][]dns.RecordResponse, 0, len(keys))
+		for _, key := range keys {
+			recordSets = append(recordSets, existingRecords[key])
+		}
+		deleted, err := DeleteRecordSets(ctx, recordSets, staleZoneID)
+		// Records that failed stay cached so the cleanup is retried
+		for _, key := range keys[:deleted] {
+			removed = append(removed, existingRecords[key][0])
+			delete(existingRecords, key)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return removed, errors.Join(errs...)
 }
 
-func CreateRecord(
-	name string,
-	ingressDestination string,
-	ttl int,
-	zoneID string,
-	service *v1.Service,
-	existingRecords map[string]dns.RecordResponse,
-) (*dns.RecordResponse, error) {
-	recordType := cfg.GetRequiredConfigValue("record-type")
-	proxied := cfg.GetRequiredConfigValue("proxy-enabled") == "true"
+func ClampTTL(ttl int) int {
+	if ttl == automaticTTL {
+		return ttl
+	}
 
-	var record dns.RecordUnionParam
-	switch recordType {
+	return max(minTTL, min(ttl, maxTTL))
+}
+
+func recordParam(
+	record providers.Record,
+	content string,
+) (dns.RecordUnionParam, error) {
+	// Tags are always sent so removing the annotation clears them on update
+	tags := make([]dns.RecordTagsParam, 0, len(record.Tags)+2)
+	tags = append(tags, record.Tags...)
+	tags = append(tags, ownerTags(record.Comment)...)
+	comment := providerComment(record.Comment)
+
+	switch record.Type {
 	case "A":
-		record = dns.ARecordParam{
+		return dns.ARecordParam{
 			Type:    cloudflare.F(dns.ARecordType("A")),
-			Name:    cloudflare.F(name),
-			Content: cloudflare.F(ingressDestination),
-			TTL:     cloudflare.F(dns.TTL(ttl)),
-			Comment: cloudflare.F("[greydns - Do not manually edit]" + service.Namespace + "/" + service.Name),
-			Proxied: cloudflare.F(proxied),
-		}
+			Name:    cloudflare.F(record.Name),
+			Content: cloudflare.F(content),
+			TTL:     cloudflare.F(dns.TTL(record.TTL)),
+			Comment: cloudflare.F(comment),
+			Proxied: cloudflare.F(record.Proxied),
+			Tags:    cloudflare.F(tags),
+		}, nil
+	case "AAAA":
+		return dns.AAAARecordParam{
+			Type:    cloudflare.F(dns.AAAARecordType("AAAA")),
+			Name:    cloudflare.F(record.Name),
+			Content: cloudflare.F(content),
+			TTL:     cloudflare.F(dns.TTL(record.TTL)),
+			Comment: cloudflare.F(comment),
+			Proxied: cloudflare.F(record.Proxied),
+			Tags:    cloudflare.F(tags),
+		}, nil
 	case "CNAME":
-		record = dns.CNAMERecordParam{
+		return dns.CNAMERecordParam{
 			Type:    cloudflare.F(dns.CNAMERecordType("CNAME")),
-			Name:    cloudflare.F(name),
-			Content: cloudflare.F(ingressDestination),
-			TTL:     cloudflare.F(dns.TTL(ttl)),
-			Comment: cloudflare.F("[greydns - Do not manually edit]"),
-			Proxied: cloudflare.F(proxied),
-		}
+			Name:    cloudflare.F(record.Name),
+			Content: cloudflare.F(content),
+			TTL:     cloudflare.F(dns.TTL(record.TTL)),
+			Comment: cloudflare.F(comment),
+			Proxied: cloudflare.F(record.Proxied),
+			Tags:    cloudflare.F(tags),
+		}, nil
+	case "NS":
+		// NS records are used for delegation and can never be proxied
+		return dns.NSRecordParam{
+			Type:    cloudflare.F(dns.NSRecordType("NS")),
+			Name:    cloudflare.F(record.Name),
+			Content: cloudflare.F(content),
+			TTL:     cloudflare.F(dns.TTL(record.TTL)),
+			Comment: cloudflare.F(comment),
+			Tags:    cloudflare.F(tags),
+		}, nil
+	case "TXT":
+		// TXT records are never proxied either, their text is sent as quoted character strings
+		return dns.TXTRecordParam{
+			Type:    cloudflare.F(dns.TXTRecordType("TXT")),
+			Name:    cloudflare.F(record.Name),
+			Content: cloudflare.F(quoteTXT(content)),
+			TTL:     cloudflare.F(dns.TTL(record.TTL)),
+			Comment: cloudflare.F(comment),
+			Tags:    cloudflare.F(tags),
+		}, nil
+	default:
+		log.Error().Msgf("[CF Provider] Invalid record type: %s", record.Type)
+		return nil, errors.New("invalid record type")
+	}
+}
+
+func batchPutParam(
+	recordID string,
+	param dns.RecordUnionParam,
+) (dns.BatchPutUnionParam, error) {
+	switch typed := param.(type) {
+	case dns.ARecordParam:
+		return dns.BatchPutAParam{ID: cloudflare.F(recordID), ARecordParam: typed}, nil
+	case dns.AAAARecordParam:
+		return dns.BatchPutAAAAParam{ID: cloudflare.F(recordID), AAAARecordParam: typed}, nil
+	case dns.CNAMERecordParam:
+		return dns.BatchPutCNAMEParam{ID: cloudflare.F(recordID), CNAMERecordParam: typed}, nil
+	case dns.NSRecordParam:
+		return dns.BatchPutNSParam{ID: cloudflare.F(recordID), NSRecordParam: typed}, nil
+	case dns.TXTRecordParam:
+		return dns.BatchPutTXTParam{ID: cloudflare.F(recordID), TXTRecordParam: typed}, nil
 	default:
-		log.Error().Msgf("[CF Provider] Invalid record type: %s", recordType)
 		return nil, errors.New("invalid record type")
 	}
+}
 
-	CleanupRecords(existingRecords, service, name, zoneID)
+// dryRunRecordSet describes the records a mutation would have produced without calling the API.
+func dryRunRecordSet(
+	record providers.Record,
+) []dns.RecordResponse {
+	recordSet := make([]dns.RecordResponse, 0, len(record.Contents))
+	for _, content := range record.Contents {
+		recordSet = append(recordSet, dns.RecordResponse{
+			ID:      providers.DryRunID,
+			Name:    record.Name,
+			Type:    dns.RecordResponseType(record.Type),
+			Content: content,
+			TTL:     dns.TTL(record.TTL),
+			Proxied: record.Proxied,
+			Comment: record.Comment,
+		})
+	}
 
-	dnsRecord, err := cloudflareAPI.DNS.Records.New(
-		context.Background(),
-		dns.RecordNewParams{
-			ZoneID: cloudflare.F(zoneID),
-			Record: record,
-		},
-	)
-	if err != nil {
-		log.Error().Err(err).Msgf("[CF Provider] [%s] Failed to create record", name)
-	} else {
-		log.Info().Msgf("[CF Provider] [%s] Record created", name)
+	return recordSet
+}
+
+// recordEvent describes a change to a record set for the audit log,
+// result holds the records returned by the provider and is empty for failed changes.
+func recordEvent(
+	action string,
+	record providers.Record,
+	zoneID string,
+	old []dns.RecordResponse,
+	result []dns.RecordResponse,
+) audit.Event {
+	event := audit.Event{
+		Action:   action,
+		Provider: providerName,
+		ZoneID:   zoneID,
+		Name:     record.Name,
+		Type:     record.Type,
+		New:      record.Contents,
+	}
+	for _, existing := range old {
+		event.Old = append(event.Old, existing.Content)
+		if len(result) == 0 {
+			event.RecordIDs = append(event.RecordIDs, existing.ID)
+		}
+	}
+	for _, created := range result {
+		event.RecordIDs = append(event.RecordIDs, created.ID)
+	}
+
+	return event
+}
+
+// CreateRecords creates several record sets of a zone with as few batch calls as possible.
+// The created record sets are returned in the order of records, when a batch fails only the
+// record sets of the batches before it are returned.
+func CreateRecords(
+	ctx context.Context,
+	records []providers.Record,
+	zoneID string,
+) ([][]dns.RecordResponse, error) {
+	recordSets := make([][]dns.RecordResponse, 0, len(records))
+	for _, chunk := range batchChunks(records) {
+		posts := make([]dns.RecordUnionParam, 0, batchSize())
+		for _, record := range chunk {
+			for _, content := range record.Contents {
+				param, err := recordParam(record, content)
+				if err != nil {
+					return recordSets, err
+				}
+				posts = append(posts, param)
+			}
+		}
+
+		if providers.DryRun() {
+			for _, record := range chunk {
+				logger(ctx).Info().Msgf("[CF Provider] [%s] [dry-run] Would create %d %s records", record.Name, len(record.Contents), record.Type)
+				audit.Record(ctx, recordEvent(audit.ActionCreate, record, zoneID, nil, nil), nil)
+				recordSets = append(recordSets, dryRunRecordSet(record))
+			}
+			continue
+		}
+
+		// A record set is never split across batches so it is never partially applied
+		callCtx, cancel := providers.WithTimeout(ctx)
+		result, err := api(zoneID).DNS.Records.Batch(
+			callCtx,
+			dns.RecordBatchParams{
+				ZoneID: cloudflare.F(zoneID),
+				Posts:  cloudflare.F(posts),
+			},
+		)
+		cancel()
+		markZoneChanged(zoneID)
+		if err != nil {
+			for _, record := range chunk {
+				logger(ctx).Error().Err(err).Msgf("[CF Provider] [%s] Failed to create record", record.Name)
+				audit.Record(ctx, recordEvent(audit.ActionCreate, record, zoneID, nil, nil), err)
+			}
+			return recordSets, providerError(err)
+		}
+
+		created := normalizeRecords(result.Posts)
+		for _, record := range chunk {
+			recordSet := created[:len(record.Contents)]
+			created = created[len(record.Contents):]
+			logger(ctx).Info().Msgf("[CF Provider] [%s] Record created", record.Name)
+			audit.Record(ctx, recordEvent(audit.ActionCreate, record, zoneID, nil, recordSet), nil)
+			recordSets = append(recordSets, recordSet)
+		}
 	}
 
-	return dnsRecord, err
+	return recordSets, nil
 }
 
+// UpdateRecord rewrites a record set with the contents of record, surplus records are kept
+// under a policy without deletions. When the mass-deletion guard refuses to delete surplus
+// records the update is applied without them and ErrDeletionLimit is returned with the record
+// set, which still holds the surplus records.
 func UpdateRecord(
-	recordID string,
-	name string,
-	ingressDestination string,
-	ttl int,
+	ctx context.Context,
+	existing []dns.RecordResponse,
+	record providers.Record,
 	zoneID string,
-	service *v1.Service,
-) (*dns.RecordResponse, error) {
-	recordType := cfg.GetRequiredConfigValue("record-type")
-	proxied := cfg.GetRequiredConfigValue("proxy-enabled") == "true"
+) ([]dns.RecordResponse, error) {
+	params := dns.RecordBatchParams{
+		ZoneID: cloudflare.F(zoneID),
+	}
 
-	var record dns.RecordUnionParam
-	switch recordType {
-	case "A":
-		record = dns.ARecordParam{
-			Type:    cloudflare.F(dns.ARecordType("A")),
-			Name:    cloudflare.F(name),
-			Content: cloudflare.F(ingressDestination),
-			TTL:     cloudflare.F(dns.TTL(ttl)),
-			Comment: cloudflare.F("[greydns - Do not manually edit]" + service.Namespace + "/" + service.Name),
-			Proxied: cloudflare.F(proxied),
+	// Existing records are updated in place, surplus records are deleted and missing ones created
+	puts := make([]dns.BatchPutUnionParam, 0, len(existing))
+	posts := make([]dns.RecordUnionParam, 0, len(record.Contents))
+	for i, content := range record.Contents {
+		param, err := recordParam(record, content)
+		if err != nil {
+			return nil, err
 		}
-	case "CNAME":
-		record = dns.CNAMERecordParam{
-			Type:    cloudflare.F(dns.CNAMERecordType("CNAME")),
-			Name:    cloudflare.F(name),
-			Content: cloudflare.F(ingressDestination),
-			TTL:     cloudflare.F(dns.TTL(ttl)),
-			Comment: cloudflare.F("[greydns - Do not manually edit]"),
-			Proxied: cloudflare.F(proxied),
+		if i >= len(existing) {
+			posts = append(posts, param)
+			continue
+		}
+		put, err := batchPutParam(existing[i].ID, param)
+		if err != nil {
+			return nil, err
 		}
+		puts = append(puts, put)
+	}
+	// Surplus records are kept when the record policy does not allow deletions. Otherwise they
+	// count against the mass-deletion guard, when it refuses they are kept as well and the rest
+	// of the update is still applied
+	surplus := existing[len(puts):]
+	var kept []dns.RecordResponse
+	var deletionErr error
+	switch {
+	case len(surplus) == 0:
+	case !providers.DeletionsAllowed():
+		logger(ctx).Info().Msgf("[CF Provider] [%s] Keeping %d surplus records, the policy does not allow deletions", record.Name, len(surplus))
+		kept = surplus
+		surplus = nil
 	default:
-		log.Error().Msgf("[CF Provider] Invalid record type: %s", recordType)
-		return nil, errors.New("invalid record type")
+		if deletionErr = providers.ReserveDeletions(len(surplus)); deletionErr != nil {
+			logger(ctx).Error().Err(deletionErr).Msgf("[CF Provider] [%s] Not deleting %d surplus records", record.Name, len(surplus))
+			kept = surplus
+			surplus = nil
+		}
 	}
-	dnsRecord, err := cloudflareAPI.DNS.Records.Update(
-		context.Background(),
-		recordID,
-		dns.RecordUpdateParams{
-			ZoneID: cloudflare.F(zoneID),
-			Record: record,
-		},
+	deletes := make([]dns.RecordBatchParamsDelete, 0, len(surplus))
+	for _, dnsRecord := range surplus {
+		deletes = append(deletes, dns.RecordBatchParamsDelete{ID: cloudflare.F(dnsRecord.ID)})
+	}
+
+	if len(puts) > 0 {
+		params.Puts = cloudflare.F(puts)
+	}
+	if len(posts) > 0 {
+		params.Posts = cloudflare.F(posts)
+	}
+	if len(deletes) > 0 {
+		params.Deletes = cloudflare.F(deletes)
+	}
+
+	if providers.DryRun() {
+		logger(ctx).Info().Msgf(
+			"[CF Provider] [%s] [dry-run] Would update %d, create %d and delete %d %s records",
+			record.Name,
+			len(puts),
+			len(posts),
+			len(deletes),
+			record.Type,
+		)
+		audit.Record(ctx, recordEvent(audit.ActionUpdate, record, zoneID, existing, nil), nil)
+		return append(dryRunRecordSet(record), kept...), deletionErr
+	}
+
+	callCtx, cancel := providers.WithTimeout(ctx)
+	defer cancel()
+	result, err := api(zoneID).DNS.Records.Batch(
+		callCtx,
+		params,
 	)
+	markZoneChanged(zoneID)
 	if err != nil {
-		log.Error().Err(err).Msgf("[CF Provider] [%s] Failed to update record", name)
-	} else {
-		log.Info().Msgf("[CF Provider] [%s] Record updated", name)
+		logger(ctx).Error().Err(err).Msgf("[CF Provider] [%s] Failed to update record", record.Name)
+		audit.Record(ctx, recordEvent(audit.ActionUpdate, record, zoneID, existing, nil), err)
+		return nil, providerError(err)
 	}
+	logger(ctx).Info().Msgf("[CF Provider] [%s] Record updated", record.Name)
+	recordSet := append(normalizeRecords(append(result.Puts, result.Posts...)), kept...)
+	audit.Record(ctx, recordEvent(audit.ActionUpdate, record, zoneID, existing, recordSet), nil)
 
-	return dnsRecord, err
+	return recordSet, deletionErr
 }
 
 func DeleteRecord(
+	ctx context.Context,
 	recordID string,
 	zoneID string,
 ) error {
-	log.Info().Msgf("[CF Provider] Attempting to delete record %s", recordID)
-	_, err := cloudflareAPI.DNS.Records.Delete(
-		context.Background(),
+	if providers.DryRun() {
+		logger(ctx).Info().Msgf("[CF Provider] [dry-run] Would delete record %s", recordID)
+		return nil
+	}
+
+	logger(ctx).Info().Msgf("[CF Provider] Attempting to delete record %s", recordID)
+	callCtx, cancel := providers.WithTimeout(ctx)
+	defer cancel()
+	_, err := api(zoneID).DNS.Records.Delete(
+		callCtx,
 		recordID,
 		dns.RecordDeleteParams{
 			ZoneID: cloudflare.F(zoneID),
 		},
 	)
+	markZoneChanged(zoneID)
 	if err != nil {
-		log.Error().Err(err).Msgf("[CF Provider] Failed to delete record")
+		logger(ctx).Error().Err(err).Msgf("[CF Provider] Failed to delete record")
 	}
 
-	return err
+	return providerError(err)
 }
 
-func RefreshRecordsCache(zonesToNames map[string]string) map[string]dns.RecordResponse {
-	newExistingRecords := make(map[string]dns.RecordResponse)
-	for _, id := range zonesToNames {
-		recordsIter := cloudflareAPI.DNS.Records.ListAutoPaging(context.Background(), dns.RecordListParams{
-			ZoneID: cloudflare.F(id),
+func DeleteRecordSet(
+	ctx context.Context,
+	recordSet []dns.RecordResponse,
+	zoneID string,
+) error {
+	if err := providers.ReserveDeletions(len(recordSet)); err != nil {
+		logger(ctx).Error().Err(err).Msgf("[CF Provider] [%s] Not deleting %d records", recordSet[0].Name, len(recordSet))
+		return err
+	}
+
+	event := recordEvent(
+		audit.ActionDelete,
+		providers.Record{Name: recordSet[0].Name, Type: string(recordSet[0].Type)},
+		zoneID,
+		recordSet,
+		nil,
+	)
+	if len(recordSet) == 1 {
+		err := DeleteRecord(ctx, recordSet[0].ID, zoneID)
+		audit.Record(ctx, event, err)
+		return err
+	}
+
+	deletes := make([]dns.RecordBatchParamsDelete, 0, len(recordSet))
+	for _, record := range recordSet {
+		deletes = append(deletes, dns.RecordBatchParamsDelete{ID: cloudflare.F(record.ID)})
+	}
+
+	if providers.DryRun() {
+		logger(ctx).Info().Msgf("[CF Provider] [dry-run] Would delete %d records", len(deletes))
+		audit.Record(ctx, event, nil)
+		return nil
+	}
+
+	logger(ctx).Info().Msgf("[CF Provider] Attempting to delete %d records", len(deletes))
+	callCtx, cancel := providers.WithTimeout(ctx)
+	defer cancel()
+	_, err := api(zoneID).DNS.Records.Batch(
+		callCtx,
+		dns.RecordBatchParams{
+			ZoneID:  cloudflare.F(zoneID),
+			Deletes: cloudflare.F(deletes),
+		},
+	)
+	markZoneChanged(zoneID)
+	if err != nil {
+		logger(ctx).Error().Err(err).Msgf("[CF Provider] Failed to delete records")
+	}
+	audit.Record(ctx, event, err)
+
+	return providerError(err)
+}
+
+func LoadZoneRecords(
+	ctx context.Context,
+	zoneID string,
+	existingRecords map[string][]dns.RecordResponse,
+) error {
+	listCtx, cancel := providers.WithListTimeout(ctx)
+	defer cancel()
+	// Only records carrying the ownership marker are listed, unmanaged records are never cached
+	params := managedRecordFilter(dns.RecordListParams{
+		ZoneID:  cloudflare.F(zoneID),
+		PerPage: cloudflare.F(float64(recordsPerPage)),
+	})
+	records, err := listPages(listCtx, func(
+		ctx context.Context,
+		page int,
+	) (*pagination.V4PagePaginationArray[dns.RecordResponse], error) {
+		pageParams := params
+		pageParams.Page = cloudflare.F(float64(page))
+		return api(zoneID).DNS.Records.List(ctx, pageParams)
+	})
+	if err != nil {
+		logger(ctx).Error().Err(err).Msgf("[CF Provider] Failed to get records of zone %s", zoneID)
+		return providerError(err)
+	}
+	for _, record := range normalizeRecords(records) {
+		if commentPattern.MatchString(record.Comment) {
+			key := providers.RecordKey(zoneID, record.Name, string(record.Type))
+			existingRecords[key] = append(existingRecords[key], record)
+			logger(ctx).Debug().Msgf("[CF Provider] Refresh Found record: %s (ID: %s)", record.Name, record.ID)
+		}
+	}
+
+	return nil
+}
+
+// ListZoneRecords returns every record of a zone, with or without an ownership marker.
+func ListZoneRecords(
+	ctx context.Context,
+	zoneID string,
+) ([]dns.RecordResponse, error) {
+	listCtx, cancel := providers.WithListTimeout(ctx)
+	defer cancel()
+	records, err := listPages(listCtx, func(
+		ctx context.Context,
+		page int,
+	) (*pagination.V4PagePaginationArray[dns.RecordResponse], error) {
+		return api(zoneID).DNS.Records.List(ctx, dns.RecordListParams{
+			ZoneID:  cloudflare.F(zoneID),
+			Page:    cloudflare.F(float64(page)),
+			PerPage: cloudflare.F(float64(recordsPerPage)),
+		})
+	})
+	if err != nil {
+		logger(ctx).Error().Err(err).Msgf("[CF Provider] Failed to get records of zone %s", zoneID)
+		return nil, providerError(err)
+	}
+
+	return normalizeRecords(records), nil
+}
+
+// FindRecords returns the records of a name and type, with or without an ownership marker.
+func FindRecords(
+	ctx context.Context,
+	name string,
+	recordType string,
+	zoneID string,
+) ([]dns.RecordResponse, error) {
+	listCtx, cancel := providers.WithListTimeout(ctx)
+	defer cancel()
+	recordsIter := api(zoneID).DNS.Records.ListAutoPaging(listCtx, dns.RecordListParams{
+		ZoneID: cloudflare.F(zoneID),
+		Name: cloudflare.F(dns.RecordListParamsName{
+			Exact: cloudflare.F(name),
+		}),
+		Type: cloudflare.F(dns.RecordListParamsType(recordType)),
+	})
+
+	var found []dns.RecordResponse
+	for recordsIter.Next() {
+		found = append(found, normalizeRecord(recordsIter.Current()))
+	}
+
+	return found, providerError(recordsIter.Err())
+}
+
+// FindUnmanagedRecords returns the records of a name and type that carry no greydns ownership marker.
+func FindUnmanagedRecords(
+	ctx context.Context,
+	name string,
+	recordType string,
+	zoneID string,
+) ([]dns.RecordResponse, error) {
+	found, err := FindRecords(ctx, name, recordType, zoneID)
+	if err != nil {
+		return nil, err
+	}
+
+	var unmanaged []dns.RecordResponse
+	for _, record := range found {
+		if !commentPattern.MatchString(record.Comment) {
+			unmanaged = append(unmanaged, record)
+		}
+	}
+
+	return unmanaged, nil
+}
+
+// editComment changes only the ownership marker of a record, the rest of the record is sent as it
+// is with the param of its type.
+func editComment(
+	ctx context.Context,
+	record dns.RecordResponse,
+	comment string,
+	zoneID string,
+) (*dns.RecordResponse, error) {
+	param, err := recordParam(providers.Record{
+		Name:    record.Name,
+		Type:    string(record.Type),
+		TTL:     int(record.TTL),
+		Proxied: record.Proxied,
+		Comment: comment,
+		Tags:    RecordTags(record),
+	}, record.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	markZoneChanged(zoneID)
+	callCtx, cancel := providers.WithTimeout(ctx)
+	defer cancel()
+	response, err := api(zoneID).DNS.Records.Edit(
+		callCtx,
+		record.ID,
+		dns.RecordEditParams{
+			ZoneID: cloudflare.F(zoneID),
+			Record: param,
+		},
+	)
+	if err != nil {
+		return nil, providerError(err)
+	}
+	normalized := normalizeRecord(*response)
+
+	return &normalized, nil
+}
+
+// commentEvent describes an ownership change of a single record for the audit log.
+func commentEvent(
+	record dns.RecordResponse,
+	comment string,
+	zoneID string,
+) audit.Event {
+	return audit.Event{
+		Action:    audit.ActionComment,
+		Provider:  providerName,
+		ZoneID:    zoneID,
+		Name:      record.Name,
+		Type:      string(record.Type),
+		Old:       []string{record.Comment},
+		New:       []string{comment},
+		RecordIDs: []string{record.ID},
+	}
+}
+
+// SetRecordSetComment rewrites the comment of every record in a set without touching its content,
+// so ownership can change while the record keeps resolving.
+func SetRecordSetComment(
+	ctx context.Context,
+	recordSet []dns.RecordResponse,
+	comment string,
+	zoneID string,
+) ([]dns.RecordResponse, error) {
+	updated := make([]dns.RecordResponse, 0, len(recordSet))
+	for _, record := range recordSet {
+		event := commentEvent(record, comment, zoneID)
+		if providers.DryRun() {
+			logger(ctx).Info().Msgf("[CF Provider] [%s] [dry-run] Would set comment to %s", record.Name, comment)
+			audit.Record(ctx, event, nil)
+			record.Comment = comment
+			updated = append(updated, record)
+			continue
+		}
+		response, err := editComment(ctx, record, comment, zoneID)
+		audit.Record(ctx, event, err)
+		if err != nil {
+			return nil, err
+		}
+		updated = append(updated, *response)
+	}
+
+	return updated, nil
+}
+
+// MigrateOwnership rewrites the comments of records created before owner-id was configured.
+func MigrateOwnership(
+	ctx context.Context,
+	zonesToNames map[string]string,
+	ownerID string,
+) (int, error) {
+	migrated := 0
+	for _, zoneID := range zonesToNames {
+		listCtx, cancel := providers.WithListTimeout(ctx)
+		recordsIter := api(zoneID).DNS.Records.ListAutoPaging(listCtx, dns.RecordListParams{
+			ZoneID: cloudflare.F(zoneID),
 		})
 		for recordsIter.Next() {
-			record := recordsIter.Current()
-			if commentPattern.MatchString(record.Comment) {
-				newExistingRecords[record.Name] = record
-				log.Debug().Msgf("[CF Provider] Refresh Found record: %s (ID: %s)", record.Name, record.ID)
+			record := normalizeRecord(recordsIter.Current())
+			comment, ok := providers.MigrateComment(record.Comment, ownerID)
+			if !ok {
+				continue
+			}
+
+			if providers.DryRun() {
+				logger(ctx).Info().Msgf("[CF Provider] [%s] [dry-run] Would migrate %s record ownership", record.Name, record.Type)
+				audit.Record(ctx, commentEvent(record, comment, zoneID), nil)
+				migrated++
+				continue
 			}
+			_, err := editComment(ctx, record, comment, zoneID)
+			audit.Record(ctx, commentEvent(record, comment, zoneID), err)
+			if err != nil {
+				cancel()
+				logger(ctx).Error().Err(err).Msgf("[CF Provider] [%s] Failed to migrate record", record.Name)
+				return migrated, err
+			}
+			logger(ctx).Info().Msgf("[CF Provider] [%s] Migrated %s record ownership", record.Name, record.Type)
+			migrated++
+		}
+		err := recordsIter.Err()
+		cancel()
+		if err != nil {
+			return migrated, providerError(err)
 		}
-		if err := recordsIter.Err(); err != nil {
-			log.Fatal().Err(err).Msg("Failed to get records")
+	}
+
+	return migrated, nil
+}
+
+// RestoreRecordsCache rebuilds the cache from the registry instead of scanning every zone.
+func RestoreRecordsCache(entries []registry.Entry) map[string][]dns.RecordResponse {
+	newExistingRecords := make(map[string][]dns.RecordResponse)
+	for _, entry := range entries {
+		key := providers.RecordKey(entry.ZoneID, entry.Name, entry.Type)
+		for i, recordID := range entry.RecordIDs {
+			record := dns.RecordResponse{
+				ID:      recordID,
+				Name:    entry.Name,
+				Type:    dns.RecordResponseType(entry.Type),
+				TTL:     dns.TTL(entry.TTL),
+				Proxied: entry.Proxied,
+				Comment: entry.Comment,
+			}
+			if i < len(entry.Contents) {
+				record.Content = entry.Contents[i]
+			}
+			newExistingRecords[key] = append(newExistingRecords[key], record)
 		}
 	}
-	log.Info().Msgf("[CF Provider] Refresh found %d records", len(newExistingRecords))
+	log.Info().Msgf("[CF Provider] Restored %d records from the registry", len(newExistingRecords))
+	providers.SetManagedRecords(countRecords(newExistingRecords))
 	return newExistingRecords
 }
 
-func GetZoneNames() map[string]string {
+// GetZoneNames maps the names of the zones greydns manages to their IDs. With zones set only
+// the listed zone names or IDs are looked up, without account credentials the zones with a token
+// of their own, otherwise every zone the token can see is listed, limited to cloudflare-account-id
+// when it is set.
+func GetZoneNames(ctx context.Context) (map[string]string, error) {
+	if explicit := utils.SplitList(cfg.GetConfigValue("zones", "")); len(explicit) > 0 {
+		return lookupZones(ctx, explicit)
+	}
+	if !hasAccountCredentials.Load() {
+		return lookupZones(ctx, tokenZones())
+	}
+
+	params := zones.ZoneListParams{}
+	if accountID := cfg.GetConfigValue("cloudflare-account-id", ""); accountID != "" {
+		params.Account = cloudflare.F(zones.ZoneListParamsAccount{
+			ID: cloudflare.F(accountID),
+		})
+	}
+	zonesToNames, err := listZones(ctx, cloudflareAPI.Load(), params)
+	if err != nil {
+		return zonesToNames, err
+	}
+	logger(ctx).Info().Msgf("[CF Provider] Found %d zones", len(zonesToNames))
+
+	return zonesToNames, nil
+}
+
+func listZones(
+	ctx context.Context,
+	client *cloudflare.Client,
+	params zones.ZoneListParams,
+) (map[string]string, error) {
 	zonesToNames := make(map[string]string)
-	zonesIter := cloudflareAPI.Zones.ListAutoPaging(context.Background(), zones.ZoneListParams{})
-	for zonesIter.Next() {
-		zone := zonesIter.Current()
+	listCtx, cancel := providers.WithListTimeout(ctx)
+	defer cancel()
+	params.PerPage = cloudflare.F(float64(zonesPerPage))
+	found, err := listPages(listCtx, func(
+		ctx context.Context,
+		page int,
+	) (*pagination.V4PagePaginationArray[zones.Zone], error) {
+		pageParams := params
+		pageParams.Page = cloudflare.F(float64(page))
+		return client.Zones.List(ctx, pageParams)
+	})
+	for _, zone := range found {
 		zonesToNames[zone.Name] = zone.ID
-		log.Debug().Msgf("[CF Provider] Found zone: %s (ID: %s)", zone.Name, zone.ID)
+		rememberZoneName(zone.ID, zone.Name)
+		logger(ctx).Debug().Msgf("[CF Provider] Found zone: %s (ID: %s)", zone.Name, zone.ID)
 	}
-	if err := zonesIter.Err(); err != nil {
-		log.Fatal().Err(err).Msg("Failed to get zones")
+
+	return zonesToNames, err
+}
+
+// lookupZones resolves an explicit list of zone names and IDs in parallel, entries that fail
+// are reported in the error while the others are still returned.
+func lookupZones(
+	ctx context.Context,
+	names []string,
+) (map[string]string, error) {
+	var (
+		wg           sync.WaitGroup
+		lock         sync.Mutex
+		errs         []error
+		zonesToNames = make(map[string]string)
+	)
+	lookups := make(chan string)
+	for range min(zoneFetchConcurrency(), len(names)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range lookups {
+				found, err := lookupZone(ctx, name)
+				lock.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				}
+				maps.Copy(zonesToNames, found)
+				lock.Unlock()
+			}
+		}()
 	}
-	log.Info().Msgf("[CF Provider] Found %d zones", len(zonesToNames))
+	for _, name := range names {
+		lookups <- name
+	}
+	close(lookups)
+	wg.Wait()
+	logger(ctx).Info().Msgf("[CF Provider] Found %d of %d configured zones", len(zonesToNames), len(names))
 
-	return zonesToNames
+	return zonesToNames, errors.Join(errs...)
 }
 
-func CheckIfZoneExists(
-	zonesToNames map[string]string,
+func lookupZone(
+	ctx context.Context,
 	name string,
+) (found map[string]string, err error) {
+	defer utils.Recover("zones", &err)
+	if zoneIDPattern.MatchString(name) {
+		zone, err := GetZone(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		logger(ctx).Debug().Msgf("[CF Provider] Found zone: %s (ID: %s)", zone.Name, zone.ID)
+		return map[string]string{zone.Name: zone.ID}, nil
+	}
+
+	found, err = listZones(ctx, apiForName(name, *zoneAPIs.Load()), zones.ZoneListParams{Name: cloudflare.F(name)})
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("zone %s does not exist or is not accessible", name)
+	}
+
+	return found, nil
+}
+
+func GetZone(
+	ctx context.Context,
+	zoneID string,
 ) (*zones.Zone, error) {
-	zoneID := zonesToNames[name]
-	zone, err := cloudflareAPI.Zones.Get(context.Background(), zones.ZoneGetParams{
+	callCtx, cancel := providers.WithTimeout(ctx)
+	defer cancel()
+	zone, err := api(zoneID).Zones.Get(callCtx, zones.ZoneGetParams{
 		ZoneID: cloudflare.F(zoneID),
 	})
 	if err != nil {
-		log.Error().Err(err).Msg("[CF Provider] Failed to get zone")
-		return nil, err
+		logger(ctx).Error().Err(err).Msg("[CF Provider] Failed to get zone")
+		return nil, providerError(err)
 	}
+	rememberZoneName(zone.ID, zone.Name)
 	return zone, err
 }
+
+// GetDNSSECStatus returns the DNSSEC status of a zone, e.g. active or disabled.
+func GetDNSSECStatus(
+	ctx context.Context,
+	zoneID string,
+) (string, error) {
+	callCtx, cancel := providers.WithTimeout(ctx)
+	defer cancel()
+	dnssec, err := api(zoneID).DNS.DNSSEC.Get(callCtx, dns.DNSSECGetParams{
+		ZoneID: cloudflare.F(zoneID),
+	})
+	if err != nil {
+		return "", providerError(err)
+	}
+
+	return string(dnssec.Status), nil
+}
+
+func CheckIfZoneExists(
+	ctx context.Context,
+	zonesToNames map[string]string,
+	name string,
+) (*zones.Zone, error) {
+	return GetZone(ctx, zonesToNames[name])
+}
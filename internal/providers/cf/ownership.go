@@ -0,0 +1,154 @@
+package providers
+
+import (
+	"slices"
+	"strings"
+
+	cloudflare "github.com/cloudflare/cloudflare-go/v4"
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/rs/zerolog/log"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/providers"
+)
+
+const (
+	markerComment = "comment"
+	markerTags    = "tags"
+	markerBoth    = "both"
+
+	// Tags carrying the owning service and the owner-id of its greydns instance
+	ownerTag   = "greydns-owner"
+	ownerIDTag = "greydns-owner-id"
+)
+
+// ownershipMarker is where the ownership of a record is stored, in the comment, in tags or in both.
+func ownershipMarker() string {
+	switch marker := cfg.GetConfigValue("ownership-marker", markerComment); marker {
+	case markerComment, markerTags, markerBoth:
+		return marker
+	default:
+		log.Warn().Msgf("[CF Provider] Unknown ownership-marker %q, using comment", marker)
+		return markerComment
+	}
+}
+
+// ownerTags returns the tags marking the owner from the ownership marker of a comment.
+func ownerTags(comment string) []string {
+	owner, ok := providers.CommentOwner(comment)
+	if !ok || ownershipMarker() == markerComment {
+		return nil
+	}
+
+	ownerID, service, hasID := strings.Cut(owner, ":")
+	if !hasID {
+		return []string{ownerTag + ":" + owner}
+	}
+
+	return []string{ownerTag + ":" + service, ownerIDTag + ":" + ownerID}
+}
+
+// providerComment is the comment sent to CloudFlare, with tags only the note after the marker is kept.
+func providerComment(comment string) string {
+	if ownershipMarker() != markerTags {
+		return comment
+	}
+	if _, ok := providers.CommentOwner(comment); !ok {
+		return comment
+	}
+	_, note, _ := strings.Cut(strings.TrimPrefix(comment, providers.CommentPrefix), " ")
+
+	return note
+}
+
+// normalizeRecord turns owner tags back into the ownership marker greydns works with, so a
+// record stays owned when its comment is edited by hand. The owner tags are removed from the tags,
+// the name is normalized like the names of the services and TXT content is unquoted.
+func normalizeRecord(record dns.RecordResponse) dns.RecordResponse {
+	record.Name = providers.NormalizeName(record.Name)
+	if record.Type == dns.RecordResponseTypeTXT {
+		record.Content = unquoteTXT(record.Content)
+	}
+	var service, ownerID string
+	tags := make([]string, 0)
+	for _, tag := range RecordTags(record) {
+		if value, ok := strings.CutPrefix(tag, ownerTag+":"); ok {
+			service = value
+			continue
+		}
+		if value, ok := strings.CutPrefix(tag, ownerIDTag+":"); ok {
+			ownerID = value
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	record.Tags = tags
+	if service == "" {
+		return record
+	}
+
+	owner := service
+	if ownerID != "" {
+		owner = ownerID + ":" + service
+	}
+	note := record.Comment
+	if _, ok := providers.CommentOwner(note); ok {
+		_, note, _ = strings.Cut(strings.TrimPrefix(note, providers.CommentPrefix), " ")
+	}
+	record.Comment = providers.CommentPrefix + owner
+	if note != "" {
+		record.Comment += " " + note
+	}
+
+	return record
+}
+
+func normalizeRecords(records []dns.RecordResponse) []dns.RecordResponse {
+	normalized := make([]dns.RecordResponse, 0, len(records))
+	for _, record := range records {
+		normalized = append(normalized, normalizeRecord(record))
+	}
+
+	return normalized
+}
+
+// managedRecordFilter lists only records carrying the ownership marker, with both markers
+// records carrying either one are listed.
+func managedRecordFilter(params dns.RecordListParams) dns.RecordListParams {
+	marker := ownershipMarker()
+	if marker != markerTags {
+		params.Comment = cloudflare.F(dns.RecordListParamsComment{
+			Startswith: cloudflare.F(providers.CommentPrefix),
+		})
+	}
+	if marker != markerComment {
+		params.Tag = cloudflare.F(dns.RecordListParamsTag{
+			Present: cloudflare.F(ownerTag),
+		})
+	}
+	if marker == markerBoth {
+		params.Match = cloudflare.F(dns.RecordListParamsMatchAny)
+	}
+
+	return params
+}
+
+// RecordTags returns the tags of a record, the API leaves them untyped.
+func RecordTags(
+	record dns.RecordResponse,
+) []string {
+	switch tags := record.Tags.(type) {
+	case []string:
+		return slices.Clone(tags)
+	case []interface{}:
+		converted := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			if value, ok := tag.(string); ok {
+				converted = append(converted, value)
+			}
+		}
+		return converted
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,109 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+
+	"github.com/cloudflare/cloudflare-go/v4/packages/pagination"
+	"github.com/rs/zerolog/log"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	defaultPageFetchConcurrency = 4
+	// Largest pages CloudFlare returns for DNS records and zones
+	recordsPerPage = 5000
+	zonesPerPage   = 50
+)
+
+func pageFetchConcurrency() int {
+	concurrency, err := strconv.Atoi(cfg.GetConfigValue("page-fetch-concurrency", strconv.Itoa(defaultPageFetchConcurrency)))
+	if err != nil || concurrency <= 0 {
+		log.Warn().Msgf("[Config] page-fetch-concurrency must be a positive integer, using %d", defaultPageFetchConcurrency)
+		return defaultPageFetchConcurrency
+	}
+
+	return concurrency
+}
+
+// totalPages reads the page count the typed result info leaves out.
+func totalPages[T any](
+	page *pagination.V4PagePaginationArray[T],
+) int {
+	var info struct {
+		TotalPages int `json:"total_pages"`
+	}
+	if err := json.Unmarshal([]byte(page.ResultInfo.JSON.RawJSON()), &info); err != nil {
+		return 1
+	}
+
+	return info.TotalPages
+}
+
+// listPages fetches the first page, then the remaining pages in parallel by a bounded number
+// of workers. Results keep the order of the pages.
+func listPages[T any](
+	ctx context.Context,
+	fetch func(ctx context.Context, page int) (*pagination.V4PagePaginationArray[T], error),
+) ([]T, error) {
+	first, err := fetch(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	total := totalPages(first)
+	if total <= 1 {
+		return first.Result, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		firstErr error
+		pages    = make([][]T, total)
+	)
+	pages[0] = first.Result
+	numbers := make(chan int)
+	for range min(pageFetchConcurrency(), total-1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range numbers {
+				page, err := func() (page *pagination.V4PagePaginationArray[T], err error) {
+					defer utils.Recover("paging", &err)
+					return fetch(ctx, number)
+				}()
+				lock.Lock()
+				switch {
+				case err != nil && firstErr == nil:
+					firstErr = err
+					// A missing page makes the listing incomplete, the other pages are not needed
+					cancel()
+				case err == nil:
+					pages[number-1] = page.Result
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	for number := 2; number <= total; number++ {
+		numbers <- number
+	}
+	close(numbers)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	results := make([]T, 0, len(pages)*len(first.Result))
+	for _, page := range pages {
+		results = append(results, page...)
+	}
+
+	return results, nil
+}
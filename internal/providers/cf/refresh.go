@@ -0,0 +1,233 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/rs/zerolog/log"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/metrics"
+	"github.com/math280h/greydns/internal/providers"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	defaultZoneFetchConcurrency = 4
+	defaultFullRefreshSeconds   = 3600
+)
+
+var (
+	refreshLock sync.Mutex //nolint:gochecknoglobals // Required for the incremental refresh
+	// fetchedZones are zones whose records were fetched at least once
+	fetchedZones = make(map[string]bool) //nolint:gochecknoglobals // Required for the incremental refresh
+	// changedZones are zones greydns changed records in since their last refresh
+	changedZones = make(map[string]bool) //nolint:gochecknoglobals // Required for the incremental refresh
+	// zoneGenerations counts the changes greydns made in every zone, a fetch is only used when the
+	// generation of its zone did not change while it ran
+	zoneGenerations = make(map[string]uint64) //nolint:gochecknoglobals // Required for the incremental refresh
+	lastFullRefresh time.Time                 //nolint:gochecknoglobals // Required for the incremental refresh
+)
+
+// markZoneChanged makes the next refresh fetch the zone again.
+func markZoneChanged(zoneID string) {
+	refreshLock.Lock()
+	defer refreshLock.Unlock()
+	changedZones[zoneID] = true
+	zoneGenerations[zoneID]++
+}
+
+// refreshDue reports whether a zone has to be fetched again, zones greydns did not change since
+// their last fetch are only fetched on a full refresh.
+func refreshDue(
+	zoneID string,
+	full bool,
+) bool {
+	return full || !fetchedZones[zoneID] || changedZones[zoneID]
+}
+
+// RefreshRecordsCache fetches the managed records of every zone. Zones that fail to load are left
+// out and reported in the error.
+func RefreshRecordsCache(
+	ctx context.Context,
+	zonesToNames map[string]string,
+) (map[string][]dns.RecordResponse, error) {
+	refreshLock.Lock()
+	lastFullRefresh = time.Now()
+	refreshLock.Unlock()
+	byZone, failed, err := fetchZones(ctx, slices.Collect(maps.Values(zonesToNames)))
+
+	newExistingRecords := make(map[string][]dns.RecordResponse)
+	for name, id := range zonesToNames {
+		maps.Copy(newExistingRecords, byZone[id])
+		if !slices.Contains(failed, id) {
+			metrics.ZoneLastRefresh.WithLabelValues(name).SetToCurrentTime()
+		}
+	}
+	count := countRecords(newExistingRecords)
+	providers.SetManagedRecords(count)
+	logger(ctx).Info().Msgf("[CF Provider] Refreshed %d zones, found %d records", len(zonesToNames), count)
+
+	return newExistingRecords, err
+}
+
+// ZoneRefresh holds the records RefreshChangedZones fetched by zone ID, together with the
+// generation of every zone when its fetch started.
+type ZoneRefresh struct {
+	records     map[string]map[string][]dns.RecordResponse
+	generations map[string]uint64
+}
+
+// Current returns the fetched records of the zones greydns did not change since their fetch
+// started. Zones changed in the meantime are left out, the cache already holds their newer
+// records, and are fetched again next time. The caller must keep record changes out until the
+// records are in the cache, e.g. by holding the reconcile lock exclusively.
+func (r ZoneRefresh) Current() map[string]map[string][]dns.RecordResponse {
+	refreshLock.Lock()
+	defer refreshLock.Unlock()
+	current := make(map[string]map[string][]dns.RecordResponse, len(r.records))
+	for id, zoneRecords := range r.records {
+		if r.generations[id] == zoneGenerations[id] {
+			current[id] = zoneRecords
+		}
+	}
+
+	return current
+}
+
+// RefreshChangedZones fetches the zones greydns changed records in since their last fetch, and
+// every zone each cache-full-refresh-seconds to pick up changes made at the provider. Zones that
+// fail to load are fetched again next time and reported in the error.
+func RefreshChangedZones(
+	ctx context.Context,
+	zonesToNames map[string]string,
+) (ZoneRefresh, error) {
+	refreshLock.Lock()
+	full := time.Since(lastFullRefresh) >= fullRefreshInterval()
+	due := make([]string, 0, len(zonesToNames))
+	generations := make(map[string]uint64)
+	for _, id := range zonesToNames {
+		if refreshDue(id, full) {
+			due = append(due, id)
+			generations[id] = zoneGenerations[id]
+			// Changes made while the zone is fetched mark it again for the next refresh
+			delete(changedZones, id)
+		}
+	}
+	refreshLock.Unlock()
+
+	byZone, failed, err := fetchZones(ctx, due)
+
+	refreshLock.Lock()
+	if full && err == nil {
+		lastFullRefresh = time.Now()
+	}
+	refreshLock.Unlock()
+	count := 0
+	for name, id := range zonesToNames {
+		count += countRecords(byZone[id])
+		// Zones that were not due are up to date as well, nothing changed them since their last fetch
+		if !slices.Contains(failed, id) {
+			metrics.ZoneLastRefresh.WithLabelValues(name).SetToCurrentTime()
+		}
+	}
+	logger(ctx).Info().Msgf(
+		"[CF Provider] Refreshed %d of %d zones, found %d records in them",
+		len(byZone),
+		len(zonesToNames),
+		count,
+	)
+
+	return ZoneRefresh{records: byZone, generations: generations}, err
+}
+
+// fetchZones loads the managed records of zones in parallel by a bounded number of workers, all
+// sharing the rate limiter. It returns the records of the zones that loaded and the zones that
+// failed.
+func fetchZones(
+	ctx context.Context,
+	zoneIDs []string,
+) (map[string]map[string][]dns.RecordResponse, []string, error) {
+	var (
+		lock   sync.Mutex
+		wg     sync.WaitGroup
+		errs   []error
+		failed []string
+	)
+	byZone := make(map[string]map[string][]dns.RecordResponse)
+	queue := make(chan string)
+	for range zoneFetchConcurrency() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range queue {
+				zoneRecords := make(map[string][]dns.RecordResponse)
+				err := func() (err error) {
+					defer utils.Recover("refresh", &err)
+					return LoadZoneRecords(ctx, id, zoneRecords)
+				}()
+
+				lock.Lock()
+				if err != nil {
+					errs = append(errs, err)
+					failed = append(failed, id)
+				} else {
+					byZone[id] = zoneRecords
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, id := range zoneIDs {
+		queue <- id
+	}
+	close(queue)
+	wg.Wait()
+
+	refreshLock.Lock()
+	defer refreshLock.Unlock()
+	for id := range byZone {
+		fetchedZones[id] = true
+	}
+	for _, id := range failed {
+		changedZones[id] = true
+	}
+
+	return byZone, failed, errors.Join(errs...)
+}
+
+// countRecords counts the records of every record set in the cache.
+func countRecords(existingRecords map[string][]dns.RecordResponse) int {
+	count := 0
+	for _, recordSet := range existingRecords {
+		count += len(recordSet)
+	}
+
+	return count
+}
+
+func fullRefreshInterval() time.Duration {
+	seconds, err := strconv.Atoi(cfg.GetConfigValue("cache-full-refresh-seconds", strconv.Itoa(defaultFullRefreshSeconds)))
+	if err != nil || seconds < 0 {
+		log.Warn().Msgf("[Config] cache-full-refresh-seconds must be a non-negative integer, using %d", defaultFullRefreshSeconds)
+		seconds = defaultFullRefreshSeconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+func zoneFetchConcurrency() int {
+	concurrency, err := strconv.Atoi(cfg.GetConfigValue("zone-fetch-concurrency", strconv.Itoa(defaultZoneFetchConcurrency)))
+	if err != nil || concurrency <= 0 {
+		log.Warn().Msgf("[Config] zone-fetch-concurrency must be a positive integer, using %d", defaultZoneFetchConcurrency)
+		return defaultZoneFetchConcurrency
+	}
+
+	return concurrency
+}
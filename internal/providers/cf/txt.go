@@ -0,0 +1,37 @@
+package providers
+
+import (
+	"strings"
+)
+
+// quoteTXT turns the text of a TXT record into the quoted character string CloudFlare expects,
+// text longer than a character string is split by CloudFlare.
+func quoteTXT(text string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text) + `"`
+}
+
+// unquoteTXT returns the text of TXT content made of quoted character strings, joined like
+// resolvers return it. Content that is not quoted is returned as it is.
+func unquoteTXT(content string) string {
+	if !strings.HasPrefix(content, `"`) {
+		return content
+	}
+
+	var text strings.Builder
+	quoted, escaped := false, false
+	for _, char := range content {
+		switch {
+		case escaped:
+			text.WriteRune(char)
+			escaped = false
+		case quoted && char == '\\':
+			escaped = true
+		case char == '"':
+			quoted = !quoted
+		case quoted:
+			text.WriteRune(char)
+		}
+	}
+
+	return text.String()
+}
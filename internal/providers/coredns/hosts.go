@@ -0,0 +1,214 @@
+package coredns
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/math280h/greydns/internal/audit"
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/metrics"
+	"github.com/math280h/greydns/internal/providers"
+)
+
+const (
+	hostsKey     = "hosts"
+	ownerMarker  = "# greydns "
+	hostsDefault = "greydns-internal-hosts"
+)
+
+var (
+	clientset *kubernetes.Clientset //nolint:gochecknoglobals // Required for the hosts configmap
+
+	ErrOwnedByOther = errors.New("host is already owned by another service")
+)
+
+// hostEntry is a single line of the hosts file served by the CoreDNS hosts plugin.
+type hostEntry struct {
+	ip    string
+	host  string
+	owner string
+	raw   string
+}
+
+func Connect(
+	client *kubernetes.Clientset,
+) {
+	clientset = client
+}
+
+// logger returns the logger of the reconcile in ctx, tagged with this provider.
+func logger(ctx context.Context) *zerolog.Logger {
+	providerLogger := zerolog.Ctx(ctx).With().Str("provider", "coredns").Logger()
+	return &providerLogger
+}
+
+func parseHosts(data string) []hostEntry {
+	entries := make([]hostEntry, 0)
+	for _, line := range strings.Split(data, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry := hostEntry{raw: line}
+
+		// Lines without the greydns marker are kept untouched
+		content, owner, managed := strings.Cut(line, ownerMarker)
+		if managed {
+			entry.owner = strings.TrimSpace(owner)
+		}
+		if fields := strings.Fields(content); len(fields) >= 2 {
+			entry.ip = fields[0]
+			entry.host = fields[1]
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func formatHosts(entries []hostEntry) string {
+	var hosts strings.Builder
+	for _, entry := range entries {
+		if entry.raw != "" {
+			hosts.WriteString(entry.raw)
+		} else {
+			hosts.WriteString(entry.ip + " " + entry.host + " " + ownerMarker + entry.owner)
+		}
+		hosts.WriteString("\n")
+	}
+
+	return hosts.String()
+}
+
+func updateHosts(
+	ctx context.Context,
+	mutate func(entries []hostEntry) ([]hostEntry, error),
+) error {
+	name := cfg.GetConfigValue("internal-hosts-configmap", hostsDefault)
+	configMaps := clientset.CoreV1().ConfigMaps("default")
+
+	ctx, cancel := providers.WithTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) && providers.DryRun() {
+			configMap, err = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+		} else if k8serrors.IsNotFound(err) {
+			configMap, err = configMaps.Create(ctx, &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Data:       map[string]string{hostsKey: ""},
+			}, metav1.CreateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+
+		entries, err := mutate(parseHosts(configMap.Data[hostsKey]))
+		if err != nil {
+			return err
+		}
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		configMap.Data[hostsKey] = formatHosts(entries)
+
+		if providers.DryRun() {
+			logger(ctx).Info().Msgf("[CoreDNS Provider] [dry-run] Would update hosts in %s", name)
+			return nil
+		}
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+	result := metrics.ResultSuccess
+	if err != nil {
+		result = metrics.ResultError
+	}
+	metrics.ObserveProviderCall("coredns", "update_hosts", result, start)
+
+	return err
+}
+
+func UpsertHost(
+	ctx context.Context,
+	host string,
+	ips []string,
+	owner string,
+) error {
+	var old []string
+	err := updateHosts(ctx, func(entries []hostEntry) ([]hostEntry, error) {
+		old = nil
+		kept := make([]hostEntry, 0, len(entries)+len(ips))
+		for _, entry := range entries {
+			if entry.host != host {
+				kept = append(kept, entry)
+				continue
+			}
+			if entry.owner != owner {
+				return nil, ErrOwnedByOther
+			}
+			old = append(old, entry.ip)
+		}
+		for _, ip := range ips {
+			kept = append(kept, hostEntry{ip: ip, host: host, owner: owner})
+		}
+
+		return kept, nil
+	})
+	audit.Record(ctx, audit.Event{
+		Action:   audit.ActionUpdate,
+		Provider: "coredns",
+		Name:     host,
+		Old:      old,
+		New:      ips,
+	}, err)
+	if err != nil {
+		logger(ctx).Error().Err(err).Msgf("[CoreDNS Provider] [%s] Failed to update host", host)
+		return err
+	}
+	logger(ctx).Info().Msgf("[CoreDNS Provider] [%s] Host updated", host)
+
+	return nil
+}
+
+func DeleteHost(
+	ctx context.Context,
+	host string,
+	owner string,
+) error {
+	var old []string
+	err := updateHosts(ctx, func(entries []hostEntry) ([]hostEntry, error) {
+		old = nil
+		kept := make([]hostEntry, 0, len(entries))
+		for _, entry := range entries {
+			if entry.host == host && entry.owner == owner {
+				old = append(old, entry.ip)
+				continue
+			}
+			kept = append(kept, entry)
+		}
+
+		return kept, nil
+	})
+	audit.Record(ctx, audit.Event{
+		Action:   audit.ActionDelete,
+		Provider: "coredns",
+		Name:     host,
+		Old:      old,
+	}, err)
+	if err != nil {
+		logger(ctx).Error().Err(err).Msgf("[CoreDNS Provider] [%s] Failed to delete host", host)
+		return err
+	}
+	logger(ctx).Info().Msgf("[CoreDNS Provider] [%s] Host deleted", host)
+
+	return nil
+}
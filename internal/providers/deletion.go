@@ -0,0 +1,99 @@
+package providers
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	cfg "github.com/math280h/greydns/internal/config"
+)
+
+const (
+	defaultDeletionWindowSecs = 300
+)
+
+var (
+	ErrDeletionLimit = errors.New("mass-deletion threshold reached, deletions are paused")
+
+	deletionLock sync.Mutex //nolint:gochecknoglobals // Required for the deletion guard
+	// deletions holds the time of every record deleted within the deletion window
+	deletions        []time.Time //nolint:gochecknoglobals // Required for the deletion guard
+	managedRecords   int         //nolint:gochecknoglobals // Required for the deletion guard
+	deletionsBlocked bool        //nolint:gochecknoglobals // Required for the deletion guard
+)
+
+// SetManagedRecords updates the number of managed records the deletion percentage is based on.
+func SetManagedRecords(count int) {
+	deletionLock.Lock()
+	defer deletionLock.Unlock()
+	managedRecords = count
+}
+
+// ResetDeletionGuard allows deletions again after the threshold was reached.
+func ResetDeletionGuard() {
+	deletionLock.Lock()
+	defer deletionLock.Unlock()
+	if deletionsBlocked {
+		log.Info().Msg("[Core] Deletions are allowed again")
+	}
+	deletionsBlocked = false
+	deletions = nil
+}
+
+// ReserveDeletions must be called before deleting count records. Once more than max-deletions
+// records or max-deletion-percent of all managed records would be deleted within
+// deletion-window-seconds every further deletion is refused until the guard is reset.
+func ReserveDeletions(count int) error {
+	deletionLock.Lock()
+	defer deletionLock.Unlock()
+	if deletionsBlocked {
+		return ErrDeletionLimit
+	}
+
+	window := time.Duration(configInt("deletion-window-seconds", defaultDeletionWindowSecs)) * time.Second
+	recent := deletions[:0]
+	for _, deletedAt := range deletions {
+		if time.Since(deletedAt) < window {
+			recent = append(recent, deletedAt)
+		}
+	}
+	deletions = recent
+
+	total := len(deletions) + count
+	maxDeletions := configInt("max-deletions", 0)
+	maxPercent := configInt("max-deletion-percent", 0)
+	if (maxDeletions > 0 && total > maxDeletions) ||
+		(maxPercent > 0 && managedRecords > 0 && total*100 > maxPercent*managedRecords) {
+		deletionsBlocked = true
+		log.Error().Msgf(
+			"[Core] Refusing to delete %d records within %s out of %d managed records, deletions are paused until the config changes or greydns restarts",
+			total,
+			window,
+			managedRecords,
+		)
+		return ErrDeletionLimit
+	}
+
+	now := time.Now()
+	for range count {
+		deletions = append(deletions, now)
+	}
+
+	return nil
+}
+
+func configInt(
+	key string,
+	fallback int,
+) int {
+	value, err := strconv.Atoi(cfg.GetConfigValue(key, strconv.Itoa(fallback)))
+	if err != nil || value < 0 {
+		log.Warn().Msgf("[Config] %s must be a non-negative integer, using %d", key, fallback)
+		return fallback
+	}
+
+	return value
+}
@@ -0,0 +1,23 @@
+package providers
+
+import (
+	cfg "github.com/math280h/greydns/internal/config"
+)
+
+const (
+	// DryRunID is the record ID of records that were only pretended to be created in dry-run mode.
+	DryRunID = "dry-run"
+)
+
+var (
+	dryRunFlag bool //nolint:gochecknoglobals // Required for the dry-run flag
+)
+
+func SetDryRun(enabled bool) {
+	dryRunFlag = enabled
+}
+
+// DryRun reports whether provider mutations should only be logged instead of executed.
+func DryRun() bool {
+	return dryRunFlag || cfg.GetConfigValue("dry-run", "false") == "true"
+}
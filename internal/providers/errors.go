@@ -0,0 +1,51 @@
+package providers
+
+import (
+	"errors"
+)
+
+// ErrorCategory tells the reconcile loop whether a failed provider call is retried, dropped or alerted on.
+type ErrorCategory string
+
+const (
+	// CategoryAuth is a rejected or expired credential, retrying cannot help until it is replaced
+	CategoryAuth ErrorCategory = "auth"
+	// CategoryRateLimit is a throttled call, retried with backoff
+	CategoryRateLimit ErrorCategory = "rate-limit"
+	// CategoryNotFound is a record or zone gone at the provider, retried until the cache refresh catches up
+	CategoryNotFound ErrorCategory = "not-found"
+	// CategoryConflict is a record clashing with another one at the provider
+	CategoryConflict ErrorCategory = "conflict"
+	// CategoryTransient is a timeout, network or server error, retried with backoff
+	CategoryTransient ErrorCategory = "transient"
+	// CategoryPermanent is a request the provider will keep refusing as long as it is unchanged
+	CategoryPermanent ErrorCategory = "permanent"
+)
+
+// ProviderError is a failed provider call with its category.
+type ProviderError struct {
+	Category ErrorCategory
+	Err      error
+}
+
+func (e *ProviderError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// Classify returns the category of an error. Errors a provider did not classify are transient,
+// so they are retried as before.
+func Classify(err error) ErrorCategory {
+	var providerErr *ProviderError
+	switch {
+	case errors.As(err, &providerErr):
+		return providerErr.Category
+	case errors.Is(err, ErrDeletionLimit):
+		return CategoryPermanent
+	default:
+		return CategoryTransient
+	}
+}
@@ -0,0 +1,138 @@
+package providers
+
+import (
+	"strings"
+
+	cfg "github.com/math280h/greydns/internal/config"
+)
+
+const (
+	CommentPrefix = "[greydns - Do not manually edit]"
+	// RetainedMarker follows the owner in the comment of records kept by greydns.io/on-delete: retain
+	RetainedMarker = "(retained)"
+)
+
+// Owner identifies the service owning a record, prefixed with the owner-id when several
+// greydns instances share a zone.
+func Owner(
+	namespace string,
+	name string,
+) string {
+	owner := namespace + "/" + name
+	if ownerID := cfg.GetConfigValue("owner-id", ""); ownerID != "" {
+		owner = ownerID + ":" + owner
+	}
+
+	return owner
+}
+
+func OwnerComment(
+	namespace string,
+	name string,
+) string {
+	return CommentPrefix + Owner(namespace, name)
+}
+
+// RecordComment appends an optional human readable note after the ownership marker,
+// separated by a space so the owner can still be parsed back out.
+func RecordComment(
+	namespace string,
+	name string,
+	note string,
+) string {
+	comment := OwnerComment(namespace, name)
+	if note != "" {
+		comment += " " + note
+	}
+
+	return comment
+}
+
+func CommentOwner(comment string) (string, bool) {
+	if !strings.HasPrefix(comment, CommentPrefix) {
+		return "", false
+	}
+	owner, _, _ := strings.Cut(strings.TrimPrefix(comment, CommentPrefix), " ")
+
+	return owner, owner != ""
+}
+
+// OwnerService returns the service owning a record of this greydns instance, records owned
+// by an instance with a different owner-id are not reported.
+func OwnerService(comment string) (string, string, bool) {
+	owner, ok := CommentOwner(comment)
+	if !ok {
+		return "", "", false
+	}
+	ownerID, service, hasID := strings.Cut(owner, ":")
+	if !hasID {
+		service = owner
+		ownerID = ""
+	}
+	if ownerID != cfg.GetConfigValue("owner-id", "") {
+		return "", "", false
+	}
+	namespace, name, found := strings.Cut(service, "/")
+
+	return namespace, name, found
+}
+
+func IsOwnedBy(
+	comment string,
+	namespace string,
+	name string,
+) bool {
+	owner, ok := CommentOwner(comment)
+	return ok && owner == Owner(namespace, name)
+}
+
+// MigrateComment rewrites a legacy ownership marker without owner-id to include ownerID,
+// keeping the note that may follow it.
+func MigrateComment(
+	comment string,
+	ownerID string,
+) (string, bool) {
+	owner, ok := CommentOwner(comment)
+	if !ok || strings.Contains(owner, ":") {
+		return comment, false
+	}
+
+	return CommentPrefix + ownerID + ":" + strings.TrimPrefix(comment, CommentPrefix), true
+}
+
+// TransferComment replaces the owner of an ownership marker, keeping the note that may follow it.
+func TransferComment(
+	comment string,
+	namespace string,
+	name string,
+) string {
+	_, note, _ := strings.Cut(strings.TrimPrefix(comment, CommentPrefix), " ")
+
+	return RecordComment(namespace, name, note)
+}
+
+// RetainedComment marks the records a deleted service kept with greydns.io/on-delete: retain, so
+// they are never collected as orphans. The owner and note are kept, a recreated service takes the
+// records back and its next reconcile drops the marker.
+func RetainedComment(comment string) string {
+	if IsRetained(comment) {
+		return comment
+	}
+	owner, note, _ := strings.Cut(strings.TrimPrefix(comment, CommentPrefix), " ")
+	comment = CommentPrefix + owner + " " + RetainedMarker
+	if note != "" {
+		comment += " " + note
+	}
+
+	return comment
+}
+
+// IsRetained reports whether the ownership marker of a comment carries RetainedMarker.
+func IsRetained(comment string) bool {
+	if _, ok := CommentOwner(comment); !ok {
+		return false
+	}
+	_, note, _ := strings.Cut(strings.TrimPrefix(comment, CommentPrefix), " ")
+
+	return note == RetainedMarker || strings.HasPrefix(note, RetainedMarker+" ")
+}
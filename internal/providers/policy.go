@@ -0,0 +1,33 @@
+package providers
+
+import (
+	"github.com/rs/zerolog/log"
+
+	cfg "github.com/math280h/greydns/internal/config"
+)
+
+const (
+	PolicySync       = "sync"
+	PolicyUpsertOnly = "upsert-only"
+	PolicyCreateOnly = "create-only"
+)
+
+// Policy limits which changes greydns may make: sync allows everything, upsert-only never deletes
+// and create-only neither updates nor deletes existing records.
+func Policy() string {
+	switch policy := cfg.GetConfigValue("policy", PolicySync); policy {
+	case PolicySync, PolicyUpsertOnly, PolicyCreateOnly:
+		return policy
+	default:
+		log.Warn().Msgf("[Config] Unknown policy %q, using %s", policy, PolicyCreateOnly)
+		return PolicyCreateOnly
+	}
+}
+
+func DeletionsAllowed() bool {
+	return Policy() == PolicySync
+}
+
+func UpdatesAllowed() bool {
+	return Policy() != PolicyCreateOnly
+}
@@ -0,0 +1,33 @@
+package providers
+
+import (
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+
+	cfg "github.com/math280h/greydns/internal/config"
+)
+
+const (
+	// CloudFlare allows 1200 requests per 5 minutes
+	defaultRateLimit = 4
+	defaultRateBurst = 10
+)
+
+// NewRateLimiter builds the token bucket shared by all calls to a provider API.
+func NewRateLimiter() *rate.Limiter {
+	limit, err := strconv.ParseFloat(cfg.GetConfigValue("provider-rate-limit", strconv.Itoa(defaultRateLimit)), 64)
+	if err != nil || limit <= 0 {
+		log.Warn().Msgf("[Config] provider-rate-limit must be a positive number, using %d", defaultRateLimit)
+		limit = defaultRateLimit
+	}
+	burst, err := strconv.Atoi(cfg.GetConfigValue("provider-rate-burst", strconv.Itoa(defaultRateBurst)))
+	if err != nil || burst <= 0 {
+		log.Warn().Msgf("[Config] provider-rate-burst must be a positive integer, using %d", defaultRateBurst)
+		burst = defaultRateBurst
+	}
+	log.Info().Msgf("[Config] Limiting provider calls to %.2f requests per second, burst %d", limit, burst)
+
+	return rate.NewLimiter(rate.Limit(limit), burst)
+}
@@ -0,0 +1,45 @@
+package providers
+
+import (
+	"strings"
+)
+
+type HealthCheck struct {
+	Protocol string
+	Port     int
+	Path     string
+}
+
+// Record is the provider agnostic representation of a DNS record set managed by greydns,
+// every content value becomes its own record with the same name and type.
+type Record struct {
+	Name     string
+	Type     string
+	Contents []string
+	TTL      int
+	Proxied  bool
+	Comment  string
+	Tags     []string
+}
+
+// NormalizeName returns a record name the way greydns compares names, lowercase and without a
+// trailing dot, so API.example.com. and api.example.com are the same record.
+func NormalizeName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// RecordKey identifies a record in the cache, records of different types or in different zones can share a name.
+func RecordKey(
+	zoneID string,
+	name string,
+	recordType string,
+) string {
+	return zoneID + "/" + NormalizeName(name) + "/" + recordType
+}
+
+// KeyZone returns the zone ID of a cache key.
+func KeyZone(key string) string {
+	zoneID, _, _ := strings.Cut(key, "/")
+
+	return zoneID
+}
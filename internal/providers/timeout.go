@@ -0,0 +1,39 @@
+package providers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	cfg "github.com/math280h/greydns/internal/config"
+)
+
+const (
+	defaultTimeoutSeconds     = 30
+	defaultListTimeoutSeconds = 300
+)
+
+func timeoutSetting(
+	key string,
+	fallback int,
+) time.Duration {
+	seconds, err := strconv.Atoi(cfg.GetConfigValue(key, strconv.Itoa(fallback)))
+	if err != nil || seconds <= 0 {
+		log.Warn().Msgf("[Config] %s must be a positive integer, using %d", key, fallback)
+		seconds = fallback
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// WithTimeout bounds a single provider call so a hung API cannot stall reconciles.
+func WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, timeoutSetting("provider-timeout-seconds", defaultTimeoutSeconds))
+}
+
+// WithListTimeout bounds listing calls, which page through whole zones and take longer.
+func WithListTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, timeoutSetting("provider-list-timeout-seconds", defaultListTimeoutSeconds))
+}
@@ -0,0 +1,152 @@
+package records
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/rs/zerolog"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/math280h/greydns/internal/providers"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+// adoptRecord takes ownership of records created outside of greydns for services annotated
+// with greydns.io/adopt, it reports whether the record set is now managed by the service.
+func adoptRecord(
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
+	record providers.Record,
+	zoneID string,
+	service *v1.Service,
+) (bool, error) {
+	meta := service.ObjectMeta
+	if meta.Annotations["greydns.io/adopt"] != "true" {
+		return false, nil
+	}
+	if !providers.UpdatesAllowed() {
+		zerolog.Ctx(ctx).Info().Msg("[DNS] The create-only policy does not allow adopting records")
+		return false, nil
+	}
+
+	unmanaged, err := cf.FindUnmanagedRecords(ctx, record.Name, record.Type, zoneID)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msgf("[DNS] Failed to look up %s records to adopt", record.Type)
+		return false, err
+	}
+	if len(unmanaged) == 0 {
+		return false, nil
+	}
+	if err = adoptRecordSet(ctx, existingRecords, unmanaged, record, zoneID, service); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// adoptRecordSet rewrites unmanaged records with the ownership marker and the desired content.
+func adoptRecordSet(
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
+	unmanaged []dns.RecordResponse,
+	record providers.Record,
+	zoneID string,
+	service *v1.Service,
+) error {
+	recordSet, err := cf.UpdateRecord(
+		ctx,
+		unmanaged,
+		record,
+		zoneID,
+	)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msgf("[DNS] Failed to adopt %s record", record.Type)
+		reportProviderError(service, err)
+		return err
+	}
+	zerolog.Ctx(ctx).Info().Msgf("[DNS] Adopted %d existing %s records", len(unmanaged), record.Type)
+	utils.Recorder.Eventf(
+		service,
+		v1.EventTypeNormal,
+		"RecordAdopted",
+		"Adopted existing %s record %s",
+		record.Type,
+		record.Name,
+	)
+
+	existingRecords[providers.RecordKey(zoneID, record.Name, record.Type)] = recordSet
+	registerRecord(ctx, service, record, zoneID, recordSet)
+
+	return nil
+}
+
+// resolveExisting handles records CloudFlare refused to create because an identical record
+// exists, usually one created outside of greydns or missed by the cache. Records of the service
+// are corrected, records of another service go through the conflict policy and unmanaged records
+// are adopted with greydns.io/adopt or the takeover conflict policy. Records that turn out not to
+// exist are created again.
+func resolveExisting(
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
+	records []providers.Record,
+	zoneID string,
+	service *v1.Service,
+) error {
+	var errs []error
+	var missing []providers.Record
+	for _, record := range records {
+		found, err := cf.FindRecords(ctx, record.Name, record.Type, zoneID)
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msgf("[DNS] Failed to look up existing %s record", record.Type)
+			errs = append(errs, err)
+			continue
+		}
+		if len(found) == 0 {
+			missing = append(missing, record)
+			continue
+		}
+
+		_, managed := providers.CommentOwner(found[0].Comment)
+		switch {
+		case isOwner(found, service) && !recordDrifted(found, record):
+			// The cache missed the record, e.g. it was created by a reconcile that timed out
+			existingRecords[providers.RecordKey(zoneID, record.Name, record.Type)] = found
+			registerRecord(ctx, service, record, zoneID, found)
+		case isOwner(found, service):
+			errs = append(errs, correctRecord(ctx, existingRecords, found, record, zoneID, service))
+		case managed:
+			_, err = handleConflict(ctx, existingRecords, record, found, zoneID, service)
+			errs = append(errs, err)
+		case service.Annotations["greydns.io/adopt"] == "true" || conflictPolicy(ctx, service) == conflictTakeover:
+			if !providers.UpdatesAllowed() {
+				zerolog.Ctx(ctx).Info().Msg("[DNS] The create-only policy does not allow adopting records")
+				continue
+			}
+			errs = append(errs, adoptRecordSet(ctx, existingRecords, found, record, zoneID, service))
+		default:
+			zerolog.Ctx(ctx).Warn().Msgf("[DNS] %s record %s exists and is not managed by greydns", record.Type, record.Name)
+			utils.Recorder.Eventf(
+				service,
+				v1.EventTypeWarning,
+				"RecordExists",
+				"%s record %s already exists and is not managed by greydns, set greydns.io/adopt to take it over",
+				record.Type,
+				record.Name,
+			)
+		}
+	}
+
+	if len(missing) > 0 {
+		recordSets, err := cf.CreateRecords(ctx, missing, zoneID)
+		cacheCreated(ctx, existingRecords, missing, recordSets, zoneID, service)
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msgf("[DNS] Failed to create %d records", len(missing)-len(recordSets))
+			reportProviderError(service, err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,68 @@
+package records
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/rs/zerolog"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/providers"
+)
+
+func resolveApex(
+	ctx context.Context,
+	records []providers.Record,
+	zoneName string,
+) ([]providers.Record, error) {
+	resolved := make([]providers.Record, 0, len(records))
+	for _, record := range records {
+		// A CNAME at the zone apex is not valid DNS and needs special handling
+		if record.Type != "CNAME" || record.Name != zoneName {
+			resolved = append(resolved, record)
+			continue
+		}
+
+		switch mode := cfg.GetConfigValue("apex-cname-mode", "flatten"); mode {
+		case "flatten":
+			// Cloudflare flattens CNAME records at the apex and answers with the target's addresses
+			zerolog.Ctx(ctx).Debug().Msgf("[DNS] [%s] Apex CNAME will be flattened by the provider", record.Name)
+			resolved = append(resolved, record)
+		case "resolve":
+			zerolog.Ctx(ctx).Debug().Msgf("[DNS] [%s] Resolving apex CNAME target %s", record.Name, record.Contents[0])
+			ips, err := net.DefaultResolver.LookupIP(ctx, "ip", record.Contents[0])
+			if err != nil {
+				return nil, err
+			}
+
+			var v4, v6 []string
+			for _, ip := range ips {
+				if ip.To4() != nil {
+					v4 = append(v4, ip.String())
+				} else {
+					v6 = append(v6, ip.String())
+				}
+			}
+			if len(v4) > 0 {
+				aRecord := record
+				aRecord.Type = "A"
+				aRecord.Contents = v4
+				resolved = append(resolved, aRecord)
+			}
+			if len(v6) > 0 {
+				aaaaRecord := record
+				aaaaRecord.Type = "AAAA"
+				aaaaRecord.Contents = v6
+				resolved = append(resolved, aaaaRecord)
+			}
+			if len(v4) == 0 && len(v6) == 0 {
+				return nil, errors.New("apex CNAME target did not resolve to any address")
+			}
+		default:
+			return nil, errors.New("invalid apex-cname-mode: " + mode)
+		}
+	}
+
+	return resolved, nil
+}
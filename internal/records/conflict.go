@@ -0,0 +1,137 @@
+package records
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/rs/zerolog"
+	v1 "k8s.io/api/core/v1"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/providers"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+	"github.com/math280h/greydns/internal/registry"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	conflictSkip     = "skip"
+	conflictTakeover = "takeover"
+	conflictError    = "error"
+)
+
+// conflictPolicy decides what happens when a desired record is owned by another service,
+// the service annotation takes precedence over the global setting.
+func conflictPolicy(
+	ctx context.Context,
+	service *v1.Service,
+) string {
+	policy := cfg.GetConfigValue("conflict-policy", conflictSkip)
+	if value, ok := service.Annotations["greydns.io/conflict-policy"]; ok {
+		policy = value
+	}
+
+	switch policy {
+	case conflictSkip, conflictTakeover, conflictError:
+		return policy
+	default:
+		zerolog.Ctx(ctx).Warn().Msgf("[DNS] Unknown conflict policy %q, using skip", policy)
+		return conflictSkip
+	}
+}
+
+// handleConflict applies the conflict policy to a record set owned by another service and
+// reports whether the service now owns it, the error is set when a takeover failed and should be retried.
+func handleConflict(
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
+	record providers.Record,
+	existing []dns.RecordResponse,
+	zoneID string,
+	service *v1.Service,
+) (bool, error) {
+	owner, _ := providers.CommentOwner(existing[0].Comment)
+
+	policy := conflictPolicy(ctx, service)
+	if policy == conflictTakeover && !providers.UpdatesAllowed() {
+		zerolog.Ctx(ctx).Warn().Msg("[DNS] The create-only policy does not allow takeovers, skipping")
+		policy = conflictSkip
+	}
+
+	switch policy {
+	case conflictTakeover:
+		recordSet, err := cf.UpdateRecord(
+			ctx,
+			existing,
+			record,
+			zoneID,
+		)
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msgf("[DNS] Failed to take over %s record from %s", record.Type, owner)
+			reportProviderError(service, err)
+			return false, err
+		}
+		zerolog.Ctx(ctx).Warn().Msgf("[DNS] Took over %s record %s from %s", record.Type, record.Name, owner)
+		utils.Recorder.Eventf(
+			service,
+			v1.EventTypeWarning,
+			"DomainTakenOver",
+			"Took over %s record %s from %s as requested by the takeover conflict policy",
+			record.Type,
+			record.Name,
+			owner,
+		)
+
+		existingRecords[providers.RecordKey(zoneID, record.Name, record.Type)] = recordSet
+		unregisterPreviousOwner(ctx, owner, record)
+		registerRecord(ctx, service, record, zoneID, recordSet)
+		return true, nil
+	case conflictError:
+		trackConflict(zoneID, record, owner, service)
+		zerolog.Ctx(ctx).Error().Msgf("[DNS] %s record %s is owned by %s", record.Type, record.Name, owner)
+		utils.Recorder.Eventf(
+			service,
+			v1.EventTypeWarning,
+			"DomainConflict",
+			"%s record %s is owned by %s, no records were changed for this service",
+			record.Type,
+			record.Name,
+			owner,
+		)
+	default:
+		trackConflict(zoneID, record, owner, service)
+		zerolog.Ctx(ctx).Info().Msgf("[DNS] Skipping %s record %s owned by %s", record.Type, record.Name, owner)
+		utils.Recorder.Eventf(
+			service,
+			v1.EventTypeWarning,
+			"DuplicateDomain",
+			"Duplicate domain entry, %s record %s is already owned by %s",
+			record.Type,
+			record.Name,
+			owner,
+		)
+	}
+
+	return false, nil
+}
+
+// unregisterPreviousOwner drops the registry entry of the service a record was taken from.
+func unregisterPreviousOwner(
+	ctx context.Context,
+	owner string,
+	record providers.Record,
+) {
+	// The owner may be prefixed with the owner-id of its greydns instance
+	if _, rest, found := strings.Cut(owner, ":"); found {
+		owner = rest
+	}
+	namespace, _, found := strings.Cut(owner, "/")
+	if !found {
+		return
+	}
+
+	if err := registry.Remove(ctx, namespace, record.Name, record.Type); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msgf("[DNS] Failed to unregister %s record %s from %s", record.Type, record.Name, owner)
+	}
+}
@@ -1,209 +1,711 @@
 package records
 
 import (
-	"strconv"
+	"context"
+	"errors"
+	"strings"
 
 	"github.com/cloudflare/cloudflare-go/v4/dns"
-	"github.com/rs/zerolog/log"
+	"github.com/rs/zerolog"
 	v1 "k8s.io/api/core/v1"
 
 	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/discovery"
+	"github.com/math280h/greydns/internal/providers"
 	cf "github.com/math280h/greydns/internal/providers/cf"
 	"github.com/math280h/greydns/internal/utils"
 )
 
+func desiredRecord(
+	service *v1.Service,
+	ingressDestination string,
+	zoneName string,
+) (providers.Record, error) {
+	meta := service.ObjectMeta
+
+	domain, err := serviceDomain(service, zoneName)
+	if err != nil {
+		return providers.Record{}, err
+	}
+	if err = checkZone(domain, zoneName); err != nil {
+		return providers.Record{}, err
+	}
+
+	ttl, err := recordTTL(service, zoneName)
+	if err != nil {
+		return providers.Record{}, err
+	}
+
+	// The record type can be overridden per service, e.g. NS records for delegation
+	recordType, err := cfg.GetRequiredZoneConfigValue(zoneName, "record-type")
+	if err != nil {
+		return providers.Record{}, err
+	}
+	if value, ok := meta.Annotations["greydns.io/record-type"]; ok {
+		recordType = strings.ToUpper(value)
+	}
+
+	// Multiple comma separated targets result in a round-robin record set, the destination is
+	// only resolved without a target as discovering it can take a while
+	var contents []string
+	if value, ok := meta.Annotations["greydns.io/target"]; ok {
+		contents = utils.SplitList(value)
+	} else if recordType == "NS" {
+		return providers.Record{}, errors.New("NS records require the greydns.io/target annotation")
+	} else {
+		destination, resolveErr := discovery.Resolve(cfg.GetZoneConfigValue(zoneName, "ingress-destination", ingressDestination), false)
+		if resolveErr != nil {
+			return providers.Record{}, resolveErr
+		}
+		contents = utils.SplitList(destination)
+	}
+	if len(contents) == 0 {
+		return providers.Record{}, errors.New("record has no target")
+	}
+	if recordType == "CNAME" && len(contents) > 1 {
+		return providers.Record{}, errors.New("CNAME records can only have a single target")
+	}
+
+	proxied, err := cfg.GetRequiredZoneConfigValue(zoneName, "proxy-enabled")
+	if err != nil {
+		return providers.Record{}, err
+	}
+	if value, ok := meta.Annotations["greydns.io/proxied"]; ok {
+		proxied = value
+	}
+
+	return providers.Record{
+		Name:     domain,
+		Type:     recordType,
+		Contents: contents,
+		TTL:      ttl,
+		Proxied:  proxied == "true",
+		Comment:  providers.RecordComment(meta.Namespace, meta.Name, meta.Annotations["greydns.io/comment"]),
+		Tags:     utils.SplitList(meta.Annotations["greydns.io/tags"]),
+	}, nil
+}
+
+func desiredRecords(
+	ctx context.Context,
+	service *v1.Service,
+	ingressDestination string,
+	zoneName string,
+) ([]providers.Record, error) {
+	record, err := desiredRecord(service, ingressDestination, zoneName)
+	if err != nil {
+		return nil, err
+	}
+	records := []providers.Record{record}
+
+	// Dual-stack clusters get an AAAA record next to the A record
+	if record.Type == "A" {
+		destinationV6 := cfg.GetZoneConfigValue(zoneName, "ingress-destination-v6", "")
+		if value, ok := service.ObjectMeta.Annotations["greydns.io/ingress-destination-v6"]; ok {
+			destinationV6 = value
+		}
+		resolvedV6, err := discovery.Resolve(destinationV6, true)
+		if err != nil {
+			return nil, err
+		}
+		if contentsV6 := utils.SplitList(resolvedV6); len(contentsV6) > 0 {
+			aaaaRecord := record
+			aaaaRecord.Type = "AAAA"
+			aaaaRecord.Contents = contentsV6
+			records = append(records, aaaaRecord)
+		}
+	}
+
+	// TXT records share the name of the record, e.g. for domain verification, one per line
+	if value := service.ObjectMeta.Annotations["greydns.io/txt"]; value != "" {
+		if record.Type == "CNAME" {
+			return nil, errors.New("a CNAME record cannot share its name with the TXT records of greydns.io/txt")
+		}
+		txtRecord := record
+		txtRecord.Type = "TXT"
+		txtRecord.Contents = txtContents(value)
+		txtRecord.Proxied = false
+		records = append(records, txtRecord)
+	}
+
+	return resolveApex(ctx, records, zoneName)
+}
+
+func txtContents(
+	value string,
+) []string {
+	contents := make([]string, 0)
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			contents = append(contents, line)
+		}
+	}
+
+	return contents
+}
+
+func isOwner(
+	recordSet []dns.RecordResponse,
+	service *v1.Service,
+) bool {
+	for _, record := range recordSet {
+		if !providers.IsOwnedBy(record.Comment, service.Namespace, service.Name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func reportProviderError(
+	service *v1.Service,
+	err error,
+) {
+	if errors.Is(err, providers.ErrDeletionLimit) {
+		utils.Recorder.Eventf(
+			service,
+			v1.EventTypeWarning,
+			"MassDeletionBlocked",
+			"Records were not deleted because the mass-deletion threshold was reached",
+		)
+	}
+	if cf.IsAuthError(err) {
+		utils.Recorder.Eventf(
+			service,
+			v1.EventTypeWarning,
+			"ProviderAuthFailed",
+			"The DNS provider rejected the API token, check the greydns-secret",
+		)
+	}
+}
+
+func reportInvalidRecord(
+	service *v1.Service,
+	err error,
+) {
+	if errors.Is(err, errDomainNotInZone) {
+		utils.Recorder.Eventf(
+			service,
+			v1.EventTypeWarning,
+			"DomainNotInZone",
+			"%v, no records were changed",
+			err,
+		)
+	}
+}
+
+// withRecordFields adds the zone and domain to every log line of the rest of the reconcile.
+func withRecordFields(
+	ctx context.Context,
+	zone string,
+	domain string,
+) context.Context {
+	return zerolog.Ctx(ctx).With().Str("zone", zone).Str("domain", domain).Logger().WithContext(ctx)
+}
+
+// reportDryRun tells the service owner what would have changed, dry-run mutations are only logged otherwise.
+func reportDryRun(
+	service *v1.Service,
+	action string,
+	recordType string,
+	name string,
+) {
+	if !providers.DryRun() {
+		return
+	}
+	utils.Recorder.Eventf(
+		service,
+		v1.EventTypeNormal,
+		"DryRun",
+		"Would %s %s record %s, dry-run is enabled",
+		action,
+		recordType,
+		name,
+	)
+}
+
+func createRecords(
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
+	records []providers.Record,
+	zoneID string,
+	service *v1.Service,
+) error {
+	for _, record := range records {
+		zerolog.Ctx(ctx).Info().Msgf("[DNS] %s record does not exist, attempting to create", record.Type)
+	}
+
+	recordSets, cfErr := cf.CreateRecords(
+		ctx,
+		records,
+		zoneID,
+	)
+	cacheCreated(ctx, existingRecords, records, recordSets, zoneID, service)
+	// Retrying a create refused for an identical record can never succeed, the record is looked up instead
+	if cf.IsIdenticalRecordError(cfErr) {
+		zerolog.Ctx(ctx).Warn().Msg("[DNS] An identical record already exists, looking it up")
+		return resolveExisting(ctx, existingRecords, records[len(recordSets):], zoneID, service)
+	}
+	if cfErr != nil {
+		zerolog.Ctx(ctx).Error().Err(cfErr).Msgf("[DNS] Failed to create %d records", len(records)-len(recordSets))
+		reportProviderError(service, cfErr)
+		return cfErr
+	}
+
+	return nil
+}
+
+// cacheCreated adds created record sets to the cache, record sets created before a failed batch are kept.
+func cacheCreated(
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
+	records []providers.Record,
+	recordSets [][]dns.RecordResponse,
+	zoneID string,
+	service *v1.Service,
+) {
+	for i, recordSet := range recordSets {
+		record := records[i]
+		zerolog.Ctx(ctx).Info().Msgf("[DNS] %s record created", record.Type)
+		reportDryRun(service, "create", record.Type, record.Name)
+
+		existingRecords[providers.RecordKey(zoneID, record.Name, record.Type)] = recordSet
+		registerRecord(ctx, service, record, zoneID, recordSet)
+		verifyRecord(ctx, record, zoneID, service)
+	}
+}
+
+func correctRecord(
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
+	existing []dns.RecordResponse,
+	record providers.Record,
+	zoneID string,
+	service *v1.Service,
+) error {
+	zerolog.Ctx(ctx).Info().Msgf("[DNS] %s record differs from the service, attempting to correct", record.Type)
+
+	recordSet, cfErr := cf.UpdateRecord(
+		ctx,
+		existing,
+		record,
+		zoneID,
+	)
+	if cfErr != nil {
+		zerolog.Ctx(ctx).Error().Err(cfErr).Msgf("[DNS] Failed to correct %s record", record.Type)
+		reportProviderError(service, cfErr)
+		return cfErr
+	}
+	zerolog.Ctx(ctx).Info().Msgf("[DNS] %s record corrected", record.Type)
+	reportDryRun(service, "update", record.Type, record.Name)
+
+	existingRecords[providers.RecordKey(zoneID, record.Name, record.Type)] = recordSet
+	registerRecord(ctx, service, record, zoneID, recordSet)
+	verifyRecord(ctx, record, zoneID, service)
+
+	return nil
+}
+
+// HandleAnnotations creates the records a service asks for, the returned error is set when
+// a provider call failed and the service should be retried.
 func HandleAnnotations(
-	existingRecords map[string]dns.RecordResponse,
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
 	ingressDestination string,
 	zonesToNames map[string]string,
 	service *v1.Service,
-) {
+) error {
+	forgetConflicts(service)
 	meta := service.ObjectMeta
 	enabled := meta.Annotations["greydns.io/dns"]
 	if enabled == "true" {
-		log.Info().Msgf("[DNS] Service %s has DNS enabled", meta.Name)
+		zerolog.Ctx(ctx).Info().Msg("[DNS] Service has DNS enabled")
 	} else {
-		return
+		return nil
+	}
+
+	// A service handing its records over to another service no longer manages them
+	if transferred, err := transferOwnership(ctx, existingRecords, zonesToNames, service); transferred {
+		return err
 	}
 
+	// The internal domain is served by a separate provider with its own target
+	handleInternal(ctx, service)
+
 	// Check if the zone exists
 	// TODO:: Support multiple zones
-	zone, err := cf.CheckIfZoneExists(zonesToNames, meta.Annotations["greydns.io/zone"])
+	zone, err := resolveZone(ctx, existingRecords, zonesToNames, service)
 	if err != nil {
-		log.Error().Err(err).Msgf("[DNS] [%s] Zone does not exist", meta.Name)
-		return
+		zerolog.Ctx(ctx).Error().Err(err).Msg("[DNS] Zone does not exist")
+		return err
 	}
-	log.Debug().Msgf("[DNS] [%s] Belongs to zone: %s", meta.Name, zone.Name)
+	zerolog.Ctx(ctx).Debug().Msgf("[DNS] Belongs to zone: %s", zone.Name)
 
-	// Check if the record exists
-	_, exists := existingRecords[meta.Annotations["greydns.io/domain"]]
-	if !exists { //nolint:nestif // TODO:: Refactor
-		log.Info().Msgf("[DNS] [%s] Record does not exist, attempting to create", meta.Name)
+	records, err := desiredRecords(ctx, service, ingressDestination, zone.Name)
+	if errors.Is(err, discovery.ErrUnresolved) {
+		// The records are kept as they are, a failed lookup says nothing about the destination
+		zerolog.Ctx(ctx).Error().Err(err).Msg("[DNS] Failed to resolve the ingress destination")
+		return err
+	}
+	if err != nil {
+		// Invalid annotations will not fix themselves, retrying is pointless
+		zerolog.Ctx(ctx).Error().Err(err).Msg("[DNS] Invalid record")
+		reportInvalidRecord(service, err)
+		return nil
+	}
+	ctx = withRecordFields(ctx, zone.Name, records[0].Name)
+	if !domainAllowed(ctx, service, zone.Name, records[0].Name) {
+		return nil
+	}
 
-		ttl, ttlErr := strconv.Atoi(cfg.GetRequiredConfigValue("record-ttl"))
-		if ttlErr != nil {
-			log.Fatal().Err(ttlErr).Msg("[DNS] TTL is not a valid integer")
+	// Ensure this service is the owner of the existing records
+	for _, record := range records {
+		existing, exists := existingRecords[providers.RecordKey(zone.ID, record.Name, record.Type)]
+		if !exists || isOwner(existing, service) {
+			continue
 		}
+		if owned, conflictErr := handleConflict(ctx, existingRecords, record, existing, zone.ID, service); !owned {
+			return conflictErr
+		}
+	}
 
-		// Create the record
-		// TODO:: Support multiple record types
-		dnsRecord, cfErr := cf.CreateRecord(
-			meta.Annotations["greydns.io/domain"],
-			ingressDestination,
-			ttl,
-			zone.ID,
-			service,
-			existingRecords,
-		)
-		if cfErr != nil {
-			log.Error().Err(cfErr).Msgf("[DNS] [%s] Failed to create record", meta.Name)
-		} else {
-			log.Info().Msgf("[DNS] [%s] Record created", meta.Name)
+	// Remove records this service owns but no longer wants before creating new ones
+	var errs []error
+	if providers.DeletionsAllowed() {
+		removed, cleanupErr := cf.CleanupRecords(ctx, existingRecords, service, records, zone.ID)
+		for _, record := range removed {
+			unregisterRecord(ctx, service, record.Name, string(record.Type))
+		}
+		if cleanupErr != nil {
+			reportProviderError(service, cleanupErr)
+		}
+		errs = append(errs, cleanupErr)
+	}
 
-			// Add the record to the cache
-			existingRecords[meta.Annotations["greydns.io/domain"]] = *dnsRecord
+	// Each record type has its own lifecycle, create what is missing and correct what drifted.
+	// Missing record types are created together in one batch
+	var missing []providers.Record
+	for _, record := range records {
+		if existing, exists := existingRecords[providers.RecordKey(zone.ID, record.Name, record.Type)]; exists {
+			if !recordDrifted(existing, record) {
+				zerolog.Ctx(ctx).Debug().Msgf("[DNS] %s record exists", record.Type)
+				continue
+			}
+			if !providers.UpdatesAllowed() {
+				zerolog.Ctx(ctx).Info().Msgf("[DNS] %s record differs from the service, the policy does not allow updates", record.Type)
+				continue
+			}
+			errs = append(errs, correctRecord(ctx, existingRecords, existing, record, zone.ID, service))
+			continue
 		}
-	} else {
-		// Ensure this service is the owner of the record
-		if existingRecords[meta.Annotations["greydns.io/domain"]].Comment !=
-			"[greydns - Do not manually edit]"+
-				meta.Namespace+"/"+meta.Name {
-			utils.Recorder.Eventf(
-				service,
-				v1.EventTypeWarning,
-				"DuplicateDomain",
-				"Duplicate domain entry, this domain is already owned by another service",
-			)
-			return
+		adopted, adoptErr := adoptRecord(ctx, existingRecords, record, zone.ID, service)
+		if adoptErr != nil {
+			errs = append(errs, adoptErr)
+			continue
+		}
+		if adopted {
+			continue
 		}
-		log.Debug().Msgf("[DNS] [%s] Record exists", meta.Name)
-		cf.CleanupRecords(existingRecords, service, meta.Name, zone.ID)
+		missing = append(missing, record)
+	}
+	if len(missing) > 0 {
+		errs = append(errs, createRecords(ctx, existingRecords, missing, zone.ID, service))
 	}
+
+	return errors.Join(errs...)
 }
 
 func HandleUpdates(
-	existingRecords map[string]dns.RecordResponse,
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
 	ingressDestination string,
 	zonesToNames map[string]string,
 	service *v1.Service,
 	oldService *v1.Service,
-) {
+) error {
+	forgetConflicts(service)
 	meta := service.ObjectMeta
-	oldMeta := oldService.ObjectMeta
 	enabled := meta.Annotations["greydns.io/dns"]
 	if enabled == "true" {
-		log.Info().Msgf("[DNS] Service %s has DNS enabled", meta.Name)
+		zerolog.Ctx(ctx).Info().Msg("[DNS] Service has DNS enabled")
 	} else {
-		return
+		// Disabling DNS or removing the annotations removes the previously managed records
+		if oldService.Annotations["greydns.io/dns"] == "true" {
+			zerolog.Ctx(ctx).Info().Msg("[DNS] DNS was disabled, removing records")
+			return HandleDeletions(
+				ctx,
+				existingRecords,
+				zonesToNames,
+				oldService,
+			)
+		}
+		return nil
+	}
+
+	if transferred, err := transferOwnership(ctx, existingRecords, zonesToNames, service); transferred {
+		return err
+	}
+
+	if oldService.Annotations["greydns.io/internal-domain"] != meta.Annotations["greydns.io/internal-domain"] {
+		deleteInternal(ctx, oldService)
 	}
 
 	// Check if the zone exists
 	// TODO:: Support multiple zones
-	zone, err := cf.CheckIfZoneExists(zonesToNames, meta.Annotations["greydns.io/zone"])
+	zone, err := resolveZone(ctx, existingRecords, zonesToNames, service)
 	if err != nil {
-		log.Error().Err(err).Msgf("[DNS] [%s] Zone does not exist", meta.Name)
-		return
+		zerolog.Ctx(ctx).Error().Err(err).Msg("[DNS] Zone does not exist")
+		return err
+	}
+	zerolog.Ctx(ctx).Debug().Msgf("[DNS] Belongs to zone: %s", zone.Name)
+
+	records, err := desiredRecords(ctx, service, ingressDestination, zone.Name)
+	if errors.Is(err, discovery.ErrUnresolved) {
+		// The records are kept as they are, a failed lookup says nothing about the destination
+		zerolog.Ctx(ctx).Error().Err(err).Msg("[DNS] Failed to resolve the ingress destination")
+		return err
+	}
+	if err != nil {
+		// Invalid annotations will not fix themselves, retrying is pointless
+		zerolog.Ctx(ctx).Error().Err(err).Msg("[DNS] Invalid record")
+		reportInvalidRecord(service, err)
+		return nil
+	}
+	ctx = withRecordFields(ctx, zone.Name, records[0].Name)
+	if !domainAllowed(ctx, service, zone.Name, records[0].Name) {
+		return nil
 	}
-	log.Debug().Msgf("[DNS] [%s] Belongs to zone: %s", meta.Name, zone.Name)
 
-	// Check if the record exists
-	_, exists := existingRecords[oldMeta.Annotations["greydns.io/domain"]]
-	if !exists { //nolint:nestif // TODO:: Refactor
-		log.Info().Msgf("[DNS] [%s] Record does not exist, attempting to create", meta.Name)
+	oldDomain, err := serviceDomain(oldService, zone.Name)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Err(err).Msg("[DNS] Previous version had no domain")
+	}
 
-		HandleAnnotations(
-			existingRecords,
-			ingressDestination,
-			zonesToNames,
-			service,
-		)
-	} else {
-		// Ensure this service is the owner of the record
-		if existingRecords[oldMeta.Annotations["greydns.io/domain"]].Comment !=
-			"[greydns - Do not manually edit]"+
-				meta.Namespace+"/"+meta.Name {
-			utils.Recorder.Eventf(
-				service,
-				v1.EventTypeWarning,
-				"DuplicateDomain",
-				"Duplicate domain entry, this domain is already owned by another service",
-			)
-			return
+	// Update the records that already exist for the old domain in place
+	var errs []error
+	for _, record := range records {
+		oldKey := providers.RecordKey(zone.ID, oldDomain, record.Type)
+		existing, exists := existingRecords[oldKey]
+		if !exists {
+			continue
 		}
-		log.Debug().Msgf("[DNS] [%s] Record exists attempting to update", meta.Name)
 
-		ttl, ttlErr := strconv.Atoi(cfg.GetRequiredConfigValue("record-ttl"))
-		if ttlErr != nil {
-			log.Fatal().Err(ttlErr).Msg("[DNS] TTL is not a valid integer")
+		// Records owned by another service are left alone, conflicts on the new domain
+		// are resolved by the conflict policy below
+		if !isOwner(existing, service) {
+			continue
+		}
+		if recordUnchanged(existing, record) {
+			zerolog.Ctx(ctx).Debug().Msgf("[DNS] %s record is already up to date", record.Type)
+			continue
+		}
+		if !providers.UpdatesAllowed() {
+			zerolog.Ctx(ctx).Info().Msgf("[DNS] Not updating %s record, the policy does not allow updates", record.Type)
+			continue
 		}
+		zerolog.Ctx(ctx).Debug().Msgf("[DNS] %s record exists attempting to update", record.Type)
 
-		// Create the record
-		// TODO:: Support multiple record types
-		dnsRecord, cfErr := cf.UpdateRecord(
-			existingRecords[oldMeta.Annotations["greydns.io/domain"]].ID,
-			meta.Annotations["greydns.io/domain"],
-			ingressDestination,
-			ttl,
+		recordSet, cfErr := cf.UpdateRecord(
+			ctx,
+			existing,
+			record,
 			zone.ID,
-			service,
 		)
 		if cfErr != nil {
-			log.Error().Err(cfErr).Msgf("[DNS] [%s] Failed to update record", meta.Name)
-		} else {
-			log.Info().Msgf("[DNS] [%s] Record updated", meta.Name)
+			zerolog.Ctx(ctx).Error().Err(cfErr).Msgf("[DNS] Failed to update %s record", record.Type)
+			reportProviderError(service, cfErr)
+			errs = append(errs, cfErr)
+			continue
+		}
+		zerolog.Ctx(ctx).Info().Msgf("[DNS] %s record updated", record.Type)
+		reportDryRun(service, "update", record.Type, record.Name)
 
-			// Add the record to the cache
-			existingRecords[meta.Annotations["greydns.io/domain"]] = *dnsRecord
+		// Move the record to its new key in the cache
+		delete(existingRecords, oldKey)
+		existingRecords[providers.RecordKey(zone.ID, record.Name, record.Type)] = recordSet
+		if oldDomain != record.Name {
+			unregisterRecord(ctx, service, oldDomain, record.Type)
 		}
+		registerRecord(ctx, service, record, zone.ID, recordSet)
 	}
+
+	// Anything that could not be updated in place is handled like a new service
+	errs = append(errs, HandleAnnotations(
+		ctx,
+		existingRecords,
+		ingressDestination,
+		zonesToNames,
+		service,
+	))
+
+	return errors.Join(errs...)
+}
+
+// HandleZoneChange removes the records a service that moved to another zone left behind in the
+// zone of existingRecords, its records in the new zone are created by its next reconcile.
+func HandleZoneChange(
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
+	zoneID string,
+	service *v1.Service,
+) error {
+	if !providers.DeletionsAllowed() {
+		zerolog.Ctx(ctx).Info().Msgf("[DNS] Policy is %s, keeping the records in the previous zone", providers.Policy())
+		return nil
+	}
+
+	zerolog.Ctx(ctx).Info().Msg("[DNS] Service moved to another zone, removing its records from the previous zone")
+	removed, err := cf.CleanupRecords(ctx, existingRecords, service, nil, zoneID)
+	for _, record := range removed {
+		unregisterRecord(ctx, service, record.Name, string(record.Type))
+	}
+	if err != nil {
+		reportProviderError(service, err)
+	}
+
+	return err
 }
 
 func HandleDeletions(
-	existingRecords map[string]dns.RecordResponse,
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
 	zonesToNames map[string]string,
 	service *v1.Service,
-) {
+) error {
+	forgetConflicts(service)
+	forgetTTLEvents(service)
 	meta := service.ObjectMeta
 	enabled := meta.Annotations["greydns.io/dns"]
 	if enabled == "true" {
-		log.Info().Msgf("[DNS] Service %s has DNS enabled", meta.Name)
+		zerolog.Ctx(ctx).Info().Msg("[DNS] Service has DNS enabled")
 	} else {
-		return
+		return nil
+	}
+
+	// Records can be kept around when the service goes away, e.g. during cluster migrations
+	switch policy := meta.Annotations["greydns.io/on-delete"]; policy {
+	case "retain":
+		zerolog.Ctx(ctx).Info().Msg("[DNS] Deletion policy is retain, keeping records")
+		return retainRecords(ctx, existingRecords, zonesToNames, service)
+	case "", "delete":
+	default:
+		zerolog.Ctx(ctx).Warn().Msgf("[DNS] Unknown deletion policy %s, deleting records", policy)
+	}
+
+	if !providers.DeletionsAllowed() {
+		zerolog.Ctx(ctx).Info().Msgf("[DNS] Policy is %s, keeping records", providers.Policy())
+		return nil
 	}
 
+	deleteInternal(ctx, service)
+
 	// Check if the zone exists
-	log.Debug().Msgf("[DNS] [%s] Checking if zone exists", meta.Name)
-	zone, err := cf.CheckIfZoneExists(zonesToNames, meta.Annotations["greydns.io/zone"])
+	zerolog.Ctx(ctx).Debug().Msg("[DNS] Checking if zone exists")
+	zone, err := resolveZone(ctx, existingRecords, zonesToNames, service)
 	if err != nil {
-		log.Error().Err(err).Msgf("[DNS] [%s] Zone does not exist", meta.Name)
-		return
+		zerolog.Ctx(ctx).Error().Err(err).Msg("[DNS] Zone does not exist")
+		return err
 	}
 
-	// Check if the record exists
-	log.Debug().Msgf("[DNS] [%s] Checking if record exists", meta.Name)
-	record, exists := existingRecords[meta.Annotations["greydns.io/domain"]]
-	if exists {
+	domain, err := serviceDomain(service, zone.Name)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("[DNS] Invalid domain")
+		return nil
+	}
+	ctx = withRecordFields(ctx, zone.Name, domain)
+	if !domainAllowed(ctx, service, zone.Name, domain) {
+		return nil
+	}
+
+	// Check if the records exist, every record type for the domain is removed
+	zerolog.Ctx(ctx).Debug().Msg("[DNS] Checking if records exist")
+	found := false
+	var errs []error
+	for key, recordSet := range existingRecords {
+		record := recordSet[0]
+		if record.Name != domain || providers.KeyZone(key) != zone.ID {
+			continue
+		}
+		found = true
+
 		// Ensure this service is the owner of the record
-		if record.Comment != "[greydns - Do not manually edit]"+meta.Namespace+"/"+meta.Name {
-			log.Debug().Msgf("[DNS] [%s] Record does not belong to this service", meta.Name)
-			return
+		if !isOwner(recordSet, service) {
+			zerolog.Ctx(ctx).Debug().Msgf("[DNS] %s record does not belong to this service", record.Type)
+			continue
 		}
 
-		log.Info().Msgf("[DNS] [%s] Record exists, attempting to delete", meta.Name)
+		zerolog.Ctx(ctx).Info().Msgf("[DNS] %s record exists, attempting to delete", record.Type)
 
-		cfErr := cf.DeleteRecord(
-			record.ID,
+		cfErr := cf.DeleteRecordSet(
+			ctx,
+			recordSet,
 			zone.ID,
 		)
 		if cfErr != nil {
-			log.Error().Err(cfErr).Msgf("[DNS] [%s] Failed to delete record", meta.Name)
+			zerolog.Ctx(ctx).Error().Err(cfErr).Msgf("[DNS] Failed to delete %s record", record.Type)
+			reportProviderError(service, cfErr)
+			errs = append(errs, cfErr)
 		} else {
-			log.Info().Msgf("[DNS] [%s] Record deleted", meta.Name)
+			zerolog.Ctx(ctx).Info().Msgf("[DNS] %s record deleted", record.Type)
+			reportDryRun(service, "delete", string(record.Type), record.Name)
 
 			// Remove the record from the cache
-			delete(existingRecords, meta.Annotations["greydns.io/domain"])
+			delete(existingRecords, key)
+			unregisterRecord(ctx, service, record.Name, string(record.Type))
 		}
-	} else {
-		log.Debug().Msgf("[DNS] [%s] Record does not exist", meta.Name)
 	}
+	if !found {
+		zerolog.Ctx(ctx).Debug().Msg("[DNS] Record does not exist")
+	}
+
+	return errors.Join(errs...)
+}
+
+// retainRecords marks the records a deleted service keeps with greydns.io/on-delete: retain, so
+// orphan collection and greydnsctl cleanup leave them alone. The service stays their owner.
+func retainRecords(
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
+	zonesToNames map[string]string,
+	service *v1.Service,
+) error {
+	if !providers.UpdatesAllowed() {
+		zerolog.Ctx(ctx).Info().Msgf("[DNS] Policy is %s, not marking records as retained", providers.Policy())
+		return nil
+	}
+
+	zone, err := resolveZone(ctx, existingRecords, zonesToNames, service)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("[DNS] Zone does not exist")
+		return err
+	}
+	domain, err := serviceDomain(service, zone.Name)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("[DNS] Invalid domain")
+		return nil
+	}
+	ctx = withRecordFields(ctx, zone.Name, domain)
+
+	var errs []error
+	for key, recordSet := range existingRecords {
+		if len(recordSet) == 0 || recordSet[0].Name != domain || providers.KeyZone(key) != zone.ID {
+			continue
+		}
+		if !isOwner(recordSet, service) || providers.IsRetained(recordSet[0].Comment) {
+			continue
+		}
+		recordType := string(recordSet[0].Type)
+
+		comment := providers.RetainedComment(recordSet[0].Comment)
+		updated, cfErr := cf.SetRecordSetComment(ctx, recordSet, comment, zone.ID)
+		if cfErr != nil {
+			zerolog.Ctx(ctx).Error().Err(cfErr).Msgf("[DNS] Failed to mark %s record as retained", recordType)
+			errs = append(errs, cfErr)
+			continue
+		}
+		existingRecords[key] = updated
+		zerolog.Ctx(ctx).Info().Msgf("[DNS] Marked %s record as retained", recordType)
+		transferRegistryEntry(ctx, service.Namespace, service.Name, zone.ID, updated)
+	}
+
+	return errors.Join(errs...)
 }
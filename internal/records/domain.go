@@ -0,0 +1,228 @@
+package records
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/cloudflare/cloudflare-go/v4/zones"
+	"golang.org/x/net/idna"
+	v1 "k8s.io/api/core/v1"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/providers"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+)
+
+const (
+	defaultHostnameTemplate = "{{ .Name }}.{{ .Namespace }}.{{ .Zone }}"
+	maxDomainLength         = 253
+	maxLabelLength          = 63
+)
+
+var (
+	errDomainNotInZone = errors.New("the domain must be the zone or a subdomain of it")
+	// ErrZoneNotFound is a zone of a service that is not known to greydns or gone at the provider
+	ErrZoneNotFound = errors.New("zone not found")
+
+	// Unicode labels are converted with the non-transitional IDNA2008 rules browsers use
+	idnaProfile = idna.New(idna.MapForLookup(), idna.Transitional(false), idna.BidiRule()) //nolint:gochecknoglobals // Required for IDN
+)
+
+type hostnameValues struct {
+	Name      string
+	Namespace string
+	Zone      string
+}
+
+func serviceZone(service *v1.Service) string {
+	zone, ok := service.Annotations["greydns.io/zone"]
+	if !ok {
+		// Fall back to the namespace default before the global base zone
+		if zone, ok = cfg.GetConfigMapping("namespace-zones")[service.Namespace]; !ok {
+			zone = cfg.GetConfigValue("base-zone", "")
+		}
+	}
+
+	// An invalid name is kept, it is then reported as a zone that does not exist
+	zone = providers.NormalizeName(zone)
+	if converted, err := ASCIIDomain(zone); err == nil {
+		return converted
+	}
+
+	return zone
+}
+
+// ASCIIDomain converts the Unicode labels of a domain to punycode, e.g. bücher.example.com to
+// xn--bcher-kva.example.com, the form the provider returns and the cache is keyed by. ASCII
+// labels are kept as they are so wildcards and underscore labels pass through.
+func ASCIIDomain(
+	domain string,
+) (string, error) {
+	labels := strings.Split(domain, ".")
+	for i, label := range labels {
+		if strings.IndexFunc(label, func(char rune) bool { return char >= utf8.RuneSelf }) < 0 {
+			continue
+		}
+		converted, err := idnaProfile.ToASCII(label)
+		if err != nil {
+			return "", fmt.Errorf("domain %q is not a valid internationalized domain name: %w", domain, err)
+		}
+		labels[i] = converted
+	}
+
+	return strings.Join(labels, "."), nil
+}
+
+// ServiceZoneID is the ID of the zone the records of a service live in, or its zone name when
+// the zone is not known.
+func ServiceZoneID(
+	service *v1.Service,
+	zonesToNames map[string]string,
+) string {
+	if zoneID, ok := service.Annotations["greydns.io/zone-id"]; ok {
+		return zoneID
+	}
+	name := serviceZone(service)
+	if zoneID, ok := zonesToNames[name]; ok {
+		return zoneID
+	}
+
+	return name
+}
+
+func serviceDomain(
+	service *v1.Service,
+	zone string,
+) (string, error) {
+	if domain := service.Annotations["greydns.io/domain"]; domain != "" {
+		return ASCIIDomain(providers.NormalizeName(domain))
+	}
+
+	// Without a domain annotation the domain is generated from the hostname template
+	if zone == "" {
+		return "", errors.New("no domain annotation and no zone to generate one in")
+	}
+
+	tmpl, err := template.New("hostname").Parse(cfg.GetConfigValue("hostname-template", defaultHostnameTemplate))
+	if err != nil {
+		return "", err
+	}
+
+	var domain strings.Builder
+	err = tmpl.Execute(&domain, hostnameValues{
+		Name:      service.Name,
+		Namespace: service.Namespace,
+		Zone:      zone,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return ASCIIDomain(providers.NormalizeName(domain.String()))
+}
+
+// ServiceDomain returns the domain of the records of a service.
+func ServiceDomain(
+	ctx context.Context,
+	service *v1.Service,
+) (string, error) {
+	zoneName := serviceZone(service)
+	if zoneID, ok := service.Annotations["greydns.io/zone-id"]; ok {
+		zone, err := cf.GetZone(ctx, zoneID)
+		if err != nil {
+			return "", err
+		}
+		zoneName = zone.Name
+	}
+
+	return serviceDomain(service, zoneName)
+}
+
+// validDomain reports whether a domain is a valid hostname, optionally with a leading wildcard label.
+func validDomain(
+	domain string,
+) bool {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" || len(domain) > maxDomainLength {
+		return false
+	}
+	labels := strings.Split(strings.TrimPrefix(domain, "*."), ".")
+	for _, label := range labels {
+		if label == "" || len(label) > maxLabelLength || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, char := range label {
+			if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' || char == '-' || char == '_') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// checkZone rejects domains outside the zone, the provider would otherwise fail opaquely or
+// append the zone to the name.
+func checkZone(
+	domain string,
+	zone string,
+) error {
+	if !inZone(domain, zone) {
+		return fmt.Errorf("domain %s is not in zone %s: %w", domain, zone, errDomainNotInZone)
+	}
+
+	return nil
+}
+
+// inZone reports whether a domain is the zone apex or one of its subdomains.
+func inZone(
+	domain string,
+	zone string,
+) bool {
+	domain = providers.NormalizeName(domain)
+	zone = providers.NormalizeName(zone)
+
+	return domain == zone || strings.HasSuffix(domain, "."+zone)
+}
+
+func resolveZone(
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
+	zonesToNames map[string]string,
+	service *v1.Service,
+) (*zones.Zone, error) {
+	zoneID, ok := service.Annotations["greydns.io/zone-id"]
+	if !ok {
+		name := serviceZone(service)
+		if _, known := zonesToNames[name]; !known {
+			return nil, fmt.Errorf("%w: %s", ErrZoneNotFound, name)
+		}
+		zone, err := cf.CheckIfZoneExists(ctx, zonesToNames, name)
+		if err != nil && providers.Classify(err) == providers.CategoryNotFound {
+			return nil, fmt.Errorf("%w: %w", ErrZoneNotFound, err)
+		}
+		return zone, err
+	}
+
+	// A zone ID skips the name lookup, for tokens that are not allowed to list zones
+	zone, err := cf.GetZone(ctx, zoneID)
+	if err != nil && providers.Classify(err) == providers.CategoryNotFound {
+		return nil, fmt.Errorf("%w: %w", ErrZoneNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, known := zonesToNames[zone.Name]; !known {
+		if err = cf.LoadZoneRecords(ctx, zone.ID, existingRecords); err != nil {
+			return nil, err
+		}
+		zonesToNames[zone.Name] = zone.ID
+	}
+
+	return zone, nil
+}
@@ -0,0 +1,66 @@
+package records
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+
+	"github.com/math280h/greydns/internal/providers"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+)
+
+// recordDrifted reports whether a record set at the provider no longer matches what the service asks for,
+// e.g. because it was edited by hand.
+func recordDrifted(
+	existing []dns.RecordResponse,
+	record providers.Record,
+) bool {
+	if len(existing) != len(record.Contents) {
+		return true
+	}
+
+	contents := make([]string, 0, len(existing))
+	for _, dnsRecord := range existing {
+		if !strings.EqualFold(dnsRecord.Name, record.Name) || string(dnsRecord.Type) != record.Type {
+			return true
+		}
+		if dnsRecord.Comment != record.Comment || dnsRecord.Proxied != record.Proxied {
+			return true
+		}
+		// Proxied records always report an automatic TTL
+		if !record.Proxied && int(dnsRecord.TTL) != record.TTL {
+			return true
+		}
+		contents = append(contents, dnsRecord.Content)
+	}
+
+	desired := slices.Clone(record.Contents)
+	slices.Sort(contents)
+	slices.Sort(desired)
+
+	return !slices.Equal(contents, desired)
+}
+
+// recordUnchanged reports whether updating the record set would be a no-op. Unlike drift,
+// tags are compared as well since they are only ever changed through the annotation.
+func recordUnchanged(
+	existing []dns.RecordResponse,
+	record providers.Record,
+) bool {
+	if recordDrifted(existing, record) {
+		return false
+	}
+
+	desired := slices.Clone(record.Tags)
+	slices.Sort(desired)
+	for _, dnsRecord := range existing {
+		tags := cf.RecordTags(dnsRecord)
+		slices.Sort(tags)
+		if !slices.Equal(tags, desired) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,103 @@
+package records
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/math280h/greydns/internal/metrics"
+	"github.com/math280h/greydns/internal/providers"
+)
+
+var (
+	conflictLock sync.Mutex //nolint:gochecknoglobals // Required for conflict tracking
+	// conflicts holds the records services were refused because another service owns them, by cache key
+	conflicts = make(map[string]*DomainConflict) //nolint:gochecknoglobals // Required for conflict tracking
+)
+
+// DomainConflict is a record claimed by several services, only Owner has it.
+type DomainConflict struct {
+	ZoneID string    `json:"zoneId"`
+	Name   string    `json:"name"`
+	Type   string    `json:"type"`
+	Owner  string    `json:"owner"`
+	Since  time.Time `json:"since"`
+	// Rejected are the namespace/name of the services that did not get the record
+	Rejected []string `json:"rejected"`
+}
+
+// trackConflict remembers that a service did not get a record because owner has it.
+func trackConflict(
+	zoneID string,
+	record providers.Record,
+	owner string,
+	service *v1.Service,
+) {
+	// The owner may be prefixed with the owner-id of its greydns instance
+	if _, rest, found := strings.Cut(owner, ":"); found {
+		owner = rest
+	}
+	rejected := service.Namespace + "/" + service.Name
+
+	conflictLock.Lock()
+	defer conflictLock.Unlock()
+	key := providers.RecordKey(zoneID, record.Name, record.Type)
+	conflict, ok := conflicts[key]
+	if !ok || conflict.Owner != owner {
+		if ok {
+			metrics.DomainConflicts.DeleteLabelValues(conflict.Name, conflict.Type, conflict.Owner)
+		}
+		conflict = &DomainConflict{ZoneID: zoneID, Name: record.Name, Type: record.Type, Owner: owner, Since: time.Now()}
+		conflicts[key] = conflict
+	}
+	if !slices.Contains(conflict.Rejected, rejected) {
+		conflict.Rejected = append(conflict.Rejected, rejected)
+	}
+	metrics.DomainConflicts.WithLabelValues(conflict.Name, conflict.Type, conflict.Owner).Set(float64(len(conflict.Rejected)))
+}
+
+// forgetConflicts drops the claims of a service before it is reconciled, claims it still
+// makes are tracked again.
+func forgetConflicts(
+	service *v1.Service,
+) {
+	rejected := service.Namespace + "/" + service.Name
+
+	conflictLock.Lock()
+	defer conflictLock.Unlock()
+	for key, conflict := range conflicts {
+		if !slices.Contains(conflict.Rejected, rejected) {
+			continue
+		}
+		conflict.Rejected = slices.DeleteFunc(conflict.Rejected, func(other string) bool {
+			return other == rejected
+		})
+		if len(conflict.Rejected) == 0 {
+			metrics.DomainConflicts.DeleteLabelValues(conflict.Name, conflict.Type, conflict.Owner)
+			delete(conflicts, key)
+			continue
+		}
+		metrics.DomainConflicts.WithLabelValues(conflict.Name, conflict.Type, conflict.Owner).Set(float64(len(conflict.Rejected)))
+	}
+}
+
+// Conflicts returns the records currently claimed by several services, sorted by name and type.
+func Conflicts() []DomainConflict {
+	conflictLock.Lock()
+	current := make([]DomainConflict, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		copied := *conflict
+		copied.Rejected = slices.Sorted(slices.Values(conflict.Rejected))
+		current = append(current, copied)
+	}
+	conflictLock.Unlock()
+
+	slices.SortFunc(current, func(a, b DomainConflict) int {
+		return strings.Compare(a.Name+"/"+a.Type, b.Name+"/"+b.Type)
+	})
+
+	return current
+}
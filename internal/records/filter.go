@@ -0,0 +1,92 @@
+package records
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rs/zerolog"
+	v1 "k8s.io/api/core/v1"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/providers"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+// matchesFilter reports whether name equals or is a subdomain of an entry in the filter list,
+// an empty filter allows everything.
+func matchesFilter(
+	name string,
+	filter []string,
+) bool {
+	if len(filter) == 0 {
+		return true
+	}
+
+	name = providers.NormalizeName(name)
+	for _, allowed := range filter {
+		allowed = providers.NormalizeName(allowed)
+		if converted, err := ASCIIDomain(allowed); err == nil {
+			allowed = converted
+		}
+		if name == allowed || strings.HasSuffix(name, "."+allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// domainAllowed applies zone-filter and domain-filter so a typo in an annotation can never
+// touch records outside the zones greydns is meant to manage.
+func domainAllowed(
+	ctx context.Context,
+	service *v1.Service,
+	zoneName string,
+	domain string,
+) bool {
+	if !zoneInFilter(utils.SplitList(cfg.GetConfigValue("zone-filter", "")), zoneName) {
+		zerolog.Ctx(ctx).Warn().Msgf("[DNS] Zone %s is not allowed by zone-filter", zoneName)
+		utils.Recorder.Eventf(
+			service,
+			v1.EventTypeWarning,
+			"ZoneNotAllowed",
+			"Zone %s is not in the zone-filter of greydns, no records were changed",
+			zoneName,
+		)
+		return false
+	}
+
+	if !matchesFilter(domain, utils.SplitList(cfg.GetConfigValue("domain-filter", ""))) {
+		zerolog.Ctx(ctx).Warn().Msgf("[DNS] Domain %s is not allowed by domain-filter", domain)
+		utils.Recorder.Eventf(
+			service,
+			v1.EventTypeWarning,
+			"DomainNotAllowed",
+			"Domain %s is not in the domain-filter of greydns, no records were changed",
+			domain,
+		)
+		return false
+	}
+
+	return true
+}
+
+func zoneInFilter(
+	filter []string,
+	name string,
+) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, allowed := range filter {
+		allowed = strings.TrimSuffix(allowed, ".")
+		if converted, err := ASCIIDomain(allowed); err == nil {
+			allowed = converted
+		}
+		if strings.EqualFold(allowed, name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,50 @@
+package records
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/math280h/greydns/internal/providers"
+)
+
+const (
+	defaultHTTPPort = 80
+)
+
+func recordHealthCheck(service *v1.Service) (*providers.HealthCheck, error) {
+	healthCheck := &providers.HealthCheck{
+		Protocol: strings.ToUpper(service.Annotations["greydns.io/health-check-protocol"]),
+		Port:     defaultHTTPPort,
+		Path:     service.Annotations["greydns.io/health-check-path"],
+	}
+	if healthCheck.Protocol == "" {
+		healthCheck.Protocol = "HTTP"
+	}
+	if healthCheck.Path == "" {
+		healthCheck.Path = "/"
+	}
+
+	if value, ok := service.Annotations["greydns.io/health-check-port"]; ok {
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		healthCheck.Port = port
+	}
+
+	switch healthCheck.Protocol {
+	case "HTTP", "HTTPS", "TCP":
+	default:
+		return nil, errors.New("health check protocol must be HTTP, HTTPS or TCP")
+	}
+
+	return healthCheck, nil
+}
+
+// ServiceHealthCheck returns the health check the greydns.io/health-check-* annotations of a service ask for.
+func ServiceHealthCheck(service *v1.Service) (*providers.HealthCheck, error) {
+	return recordHealthCheck(service)
+}
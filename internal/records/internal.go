@@ -0,0 +1,72 @@
+package records
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rs/zerolog"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/math280h/greydns/internal/providers"
+	"github.com/math280h/greydns/internal/providers/coredns"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+func internalTargets(service *v1.Service) []string {
+	if targets := utils.SplitList(service.Annotations["greydns.io/internal-target"]); len(targets) > 0 {
+		return targets
+	}
+
+	// Inside the cluster the service can be reached directly on its cluster IPs
+	targets := make([]string, 0, len(service.Spec.ClusterIPs))
+	for _, ip := range service.Spec.ClusterIPs {
+		if ip != "" && ip != v1.ClusterIPNone {
+			targets = append(targets, ip)
+		}
+	}
+
+	return targets
+}
+
+func handleInternal(
+	ctx context.Context,
+	service *v1.Service,
+) {
+	domain := providers.NormalizeName(service.Annotations["greydns.io/internal-domain"])
+	if domain == "" {
+		return
+	}
+
+	targets := internalTargets(service)
+	if len(targets) == 0 {
+		zerolog.Ctx(ctx).Error().Msgf("[DNS] No internal target for %s", domain)
+		return
+	}
+
+	err := coredns.UpsertHost(ctx, domain, targets, providers.Owner(service.Namespace, service.Name))
+	if errors.Is(err, coredns.ErrOwnedByOther) {
+		utils.Recorder.Eventf(
+			service,
+			v1.EventTypeWarning,
+			"DuplicateDomain",
+			"Duplicate internal domain entry, this domain is already owned by another service",
+		)
+	}
+}
+
+func deleteInternal(
+	ctx context.Context,
+	service *v1.Service,
+) {
+	domain := providers.NormalizeName(service.Annotations["greydns.io/internal-domain"])
+	if domain == "" {
+		return
+	}
+	if !providers.DeletionsAllowed() {
+		zerolog.Ctx(ctx).Info().Msgf("[DNS] Keeping internal domain %s, the policy does not allow deletions", domain)
+		return
+	}
+
+	zerolog.Ctx(ctx).Info().Msgf("[DNS] Removing internal domain %s", domain)
+	_ = coredns.DeleteHost(ctx, domain, providers.Owner(service.Namespace, service.Name))
+}
@@ -0,0 +1,237 @@
+package records
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/math280h/greydns/internal/providers"
+)
+
+const (
+	ActionCreate   = "create"
+	ActionUpdate   = "update"
+	ActionDelete   = "delete"
+	ActionConflict = "conflict"
+)
+
+// Desired is a record a service asks for, Service is its namespace/name.
+type Desired struct {
+	Record  providers.Record
+	Service string
+}
+
+// State is what the services of a cluster ask for, by cache key.
+type State struct {
+	Desired map[string]Desired
+	// Services holds the namespace/name of every service, records of other services are orphaned
+	Services map[string]bool
+}
+
+// Change is a difference between a record at the provider and what its service asks for.
+// Before is empty for creates, After is nil for deletes.
+type Change struct {
+	Action  string
+	ZoneID  string
+	Name    string
+	Type    string
+	Owner   string
+	Orphan  bool
+	Before  []dns.RecordResponse
+	After   *providers.Record
+	Desired string
+}
+
+// DesiredState collects the records of every service, services whose records cannot be
+// worked out are reported in the error and left out.
+func DesiredState(
+	ctx context.Context,
+	services []v1.Service,
+	ingressDestination string,
+	zonesToNames map[string]string,
+) (State, error) {
+	state := State{
+		Desired:  make(map[string]Desired),
+		Services: make(map[string]bool, len(services)),
+	}
+	var errs []error
+	for i := range services {
+		service := &services[i]
+		owner := service.Namespace + "/" + service.Name
+		state.Services[owner] = true
+		zoneID, records, err := DesiredRecords(ctx, service, ingressDestination, zonesToNames)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", owner, err))
+			continue
+		}
+		for _, record := range records {
+			state.Desired[providers.RecordKey(zoneID, record.Name, record.Type)] = Desired{
+				Record:  record,
+				Service: owner,
+			}
+		}
+	}
+
+	return state, errors.Join(errs...)
+}
+
+// Plan lists the changes a reconcile of every service would make, sorted by zone, name and type.
+// Records of another owner-id are never changed and left out.
+func Plan(
+	existingRecords map[string][]dns.RecordResponse,
+	state State,
+) []Change {
+	changes := make([]Change, 0)
+	for key, recordSet := range existingRecords {
+		namespace, name, ok := providers.OwnerService(recordSet[0].Comment)
+		if !ok {
+			continue
+		}
+		change := Change{
+			ZoneID: providers.KeyZone(key),
+			Name:   recordSet[0].Name,
+			Type:   string(recordSet[0].Type),
+			Owner:  namespace + "/" + name,
+			Before: recordSet,
+		}
+		want, desired := state.Desired[key]
+		switch {
+		case !desired:
+			change.Action = ActionDelete
+			change.Orphan = !state.Services[change.Owner]
+		case want.Service != change.Owner:
+			change.Action = ActionConflict
+			change.Desired = want.Service
+		case recordDrifted(recordSet, want.Record):
+			change.Action = ActionUpdate
+			change.After = &want.Record
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	for key, want := range state.Desired {
+		if _, exists := existingRecords[key]; exists {
+			continue
+		}
+		changes = append(changes, Change{
+			Action: ActionCreate,
+			ZoneID: providers.KeyZone(key),
+			Name:   want.Record.Name,
+			Type:   want.Record.Type,
+			Owner:  want.Service,
+			After:  &want.Record,
+		})
+	}
+
+	slices.SortFunc(changes, func(a, b Change) int {
+		return strings.Compare(a.ZoneID+"/"+a.Name+"/"+a.Type, b.ZoneID+"/"+b.Name+"/"+b.Type)
+	})
+
+	return changes
+}
+
+// WritePlan prints the changes as a terraform-style diff, grouped by zone.
+func WritePlan(
+	out io.Writer,
+	changes []Change,
+	zoneName func(zoneID string) string,
+) error {
+	var b strings.Builder
+	counts := make(map[string]int)
+	currentZone := ""
+	for _, change := range changes {
+		if change.ZoneID != currentZone {
+			currentZone = change.ZoneID
+			fmt.Fprintf(&b, "\n  # %s\n", zoneName(change.ZoneID))
+		}
+		counts[change.Action]++
+		switch change.Action {
+		case ActionCreate:
+			fmt.Fprintf(&b, "  + %s %s\n", change.Type, change.Name)
+			writeAttribute(&b, "contents", "", strings.Join(change.After.Contents, ","))
+			writeAttribute(&b, "ttl", "", strconv.Itoa(change.After.TTL))
+			writeAttribute(&b, "proxied", "", strconv.FormatBool(change.After.Proxied))
+			writeAttribute(&b, "owner", "", change.Owner)
+		case ActionUpdate:
+			fmt.Fprintf(&b, "  ~ %s %s\n", change.Type, change.Name)
+			before := change.Before[0]
+			writeAttribute(&b, "contents", strings.Join(recordContents(change.Before), ","), strings.Join(sortedContents(change.After.Contents), ","))
+			writeAttribute(&b, "ttl", strconv.Itoa(int(before.TTL)), strconv.Itoa(change.After.TTL))
+			writeAttribute(&b, "proxied", strconv.FormatBool(before.Proxied), strconv.FormatBool(change.After.Proxied))
+			writeAttribute(&b, "comment", before.Comment, change.After.Comment)
+		case ActionDelete:
+			fmt.Fprintf(&b, "  - %s %s\n", change.Type, change.Name)
+			writeAttribute(&b, "contents", strings.Join(recordContents(change.Before), ","), "")
+			if change.Orphan {
+				writeAttribute(&b, "owner", "", change.Owner+" no longer exists, removed with orphan-policy: delete")
+			} else {
+				writeAttribute(&b, "owner", "", change.Owner)
+			}
+		case ActionConflict:
+			fmt.Fprintf(&b, "  ! %s %s\n", change.Type, change.Name)
+			writeAttribute(&b, "owner", "", change.Owner)
+			writeAttribute(&b, "wanted by", "", change.Desired+" (handled by conflict-policy)")
+		}
+	}
+	if len(changes) == 0 {
+		b.WriteString("No changes, the records match the services.\n")
+	} else {
+		fmt.Fprintf(
+			&b,
+			"\nPlan: %d to create, %d to update, %d to delete, %d conflicts.\n",
+			counts[ActionCreate],
+			counts[ActionUpdate],
+			counts[ActionDelete],
+			counts[ActionConflict],
+		)
+	}
+	_, err := io.WriteString(out, b.String())
+
+	return err
+}
+
+// writeAttribute prints an attribute of a change, only the new value when nothing is compared
+// and nothing at all when an update leaves it unchanged.
+func writeAttribute(
+	b *strings.Builder,
+	name string,
+	before string,
+	after string,
+) {
+	switch {
+	case before == "":
+		fmt.Fprintf(b, "      %-9s %s\n", name+":", after)
+	case after == "":
+		fmt.Fprintf(b, "      %-9s %s\n", name+":", before)
+	case before != after:
+		fmt.Fprintf(b, "      %-9s %s -> %s\n", name+":", before, after)
+	}
+}
+
+func recordContents(
+	recordSet []dns.RecordResponse,
+) []string {
+	contents := make([]string, 0, len(recordSet))
+	for _, record := range recordSet {
+		contents = append(contents, record.Content)
+	}
+
+	return sortedContents(contents)
+}
+
+func sortedContents(
+	contents []string,
+) []string {
+	sorted := slices.Clone(contents)
+	slices.Sort(sorted)
+
+	return sorted
+}
@@ -0,0 +1,59 @@
+package records
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/rs/zerolog"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/math280h/greydns/internal/audit"
+	"github.com/math280h/greydns/internal/providers"
+	"github.com/math280h/greydns/internal/registry"
+)
+
+func registerRecord(
+	ctx context.Context,
+	service *v1.Service,
+	record providers.Record,
+	zoneID string,
+	recordSet []dns.RecordResponse,
+) {
+	recordIDs := make([]string, 0, len(recordSet))
+	contents := make([]string, 0, len(recordSet))
+	for _, dnsRecord := range recordSet {
+		recordIDs = append(recordIDs, dnsRecord.ID)
+		contents = append(contents, dnsRecord.Content)
+	}
+
+	err := registry.Upsert(ctx, registry.Entry{
+		Namespace: service.Namespace,
+		Service:   service.Name,
+		Name:      record.Name,
+		Type:      record.Type,
+		ZoneID:    zoneID,
+		Contents:  contents,
+		RecordIDs: recordIDs,
+		TTL:       record.TTL,
+		Proxied:   record.Proxied,
+		Comment:   record.Comment,
+		LastSync:  time.Now(),
+		History:   audit.RecordHistory(record.Name, record.Type),
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msgf("[DNS] Failed to register %s record", record.Type)
+	}
+}
+
+func unregisterRecord(
+	ctx context.Context,
+	service *v1.Service,
+	name string,
+	recordType string,
+) {
+	err := registry.Remove(ctx, service.Namespace, name, recordType)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msgf("[DNS] Failed to unregister %s record", recordType)
+	}
+}
@@ -0,0 +1,52 @@
+package records
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/math280h/greydns/internal/providers"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+)
+
+// DesiredRecords returns the zone ID and the records a service asks for without changing anything
+// at the provider, services without DNS enabled ask for nothing.
+func DesiredRecords(
+	ctx context.Context,
+	service *v1.Service,
+	ingressDestination string,
+	zonesToNames map[string]string,
+) (string, []providers.Record, error) {
+	if service.Annotations["greydns.io/dns"] != "true" {
+		return "", nil, nil
+	}
+
+	zoneName := serviceZone(service)
+	zoneID, ok := service.Annotations["greydns.io/zone-id"]
+	if ok {
+		zone, err := cf.GetZone(ctx, zoneID)
+		if err != nil {
+			return "", nil, err
+		}
+		zoneName = zone.Name
+	} else if zoneID, ok = zonesToNames[zoneName]; !ok {
+		return "", nil, fmt.Errorf("zone %s does not exist", zoneName)
+	}
+
+	records, err := desiredRecords(ctx, service, ingressDestination, zoneName)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return zoneID, records, nil
+}
+
+// Drifted reports whether a record set at the provider no longer matches the desired record.
+func Drifted(
+	existing []dns.RecordResponse,
+	record providers.Record,
+) bool {
+	return recordDrifted(existing, record)
+}
@@ -0,0 +1,129 @@
+package records
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	"github.com/rs/zerolog"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/math280h/greydns/internal/audit"
+	"github.com/math280h/greydns/internal/providers"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+	"github.com/math280h/greydns/internal/registry"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+// transferOwnership hands the records of a service annotated with greydns.io/transfer-to over
+// to the named service by rewriting only their ownership marker, so they keep resolving.
+// It reports whether a transfer was requested, in which case the service manages no records,
+// and the error of transfers that failed and should be retried.
+func transferOwnership(
+	ctx context.Context,
+	existingRecords map[string][]dns.RecordResponse,
+	zonesToNames map[string]string,
+	service *v1.Service,
+) (bool, error) {
+	meta := service.ObjectMeta
+	target, ok := meta.Annotations["greydns.io/transfer-to"]
+	if !ok {
+		return false, nil
+	}
+
+	if !providers.UpdatesAllowed() {
+		zerolog.Ctx(ctx).Info().Msg("[DNS] The create-only policy does not allow transferring records")
+		return true, nil
+	}
+
+	namespace, name, found := strings.Cut(target, "/")
+	if !found || namespace == "" || name == "" {
+		zerolog.Ctx(ctx).Error().Msgf("[DNS] Invalid transfer target %q, expected namespace/name", target)
+		utils.Recorder.Eventf(
+			service,
+			v1.EventTypeWarning,
+			"InvalidTransferTarget",
+			"greydns.io/transfer-to must be namespace/name, got %q",
+			target,
+		)
+		return true, nil
+	}
+
+	zone, err := resolveZone(ctx, existingRecords, zonesToNames, service)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("[DNS] Zone does not exist")
+		return true, err
+	}
+	domain, err := serviceDomain(service, zone.Name)
+	if err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msg("[DNS] Invalid domain")
+		return true, nil
+	}
+
+	var errs []error
+	for key, recordSet := range existingRecords {
+		if len(recordSet) == 0 || recordSet[0].Name != domain || providers.KeyZone(key) != zone.ID {
+			continue
+		}
+		if !isOwner(recordSet, service) {
+			continue
+		}
+		recordType := string(recordSet[0].Type)
+
+		comment := providers.TransferComment(recordSet[0].Comment, namespace, name)
+		updated, cfErr := cf.SetRecordSetComment(ctx, recordSet, comment, zone.ID)
+		if cfErr != nil {
+			zerolog.Ctx(ctx).Error().Err(cfErr).Msgf("[DNS] Failed to transfer %s record to %s", recordType, target)
+			errs = append(errs, cfErr)
+			continue
+		}
+		existingRecords[key] = updated
+		zerolog.Ctx(ctx).Info().Msgf("[DNS] Transferred %s record %s to %s", recordType, domain, target)
+		utils.Recorder.Eventf(
+			service,
+			v1.EventTypeNormal,
+			"OwnershipTransferred",
+			"Transferred %s record %s to %s",
+			recordType,
+			domain,
+			target,
+		)
+
+		unregisterRecord(ctx, service, domain, recordType)
+		transferRegistryEntry(ctx, namespace, name, zone.ID, updated)
+	}
+
+	return true, errors.Join(errs...)
+}
+
+// transferRegistryEntry registers a transferred record set under its new owner.
+func transferRegistryEntry(
+	ctx context.Context,
+	namespace string,
+	name string,
+	zoneID string,
+	recordSet []dns.RecordResponse,
+) {
+	entry := registry.Entry{
+		Namespace: namespace,
+		Service:   name,
+		Name:      recordSet[0].Name,
+		Type:      string(recordSet[0].Type),
+		ZoneID:    zoneID,
+		TTL:       int(recordSet[0].TTL),
+		Proxied:   recordSet[0].Proxied,
+		Comment:   recordSet[0].Comment,
+		LastSync:  time.Now(),
+		History:   audit.RecordHistory(recordSet[0].Name, string(recordSet[0].Type)),
+	}
+	for _, record := range recordSet {
+		entry.RecordIDs = append(entry.RecordIDs, record.ID)
+		entry.Contents = append(entry.Contents, record.Content)
+	}
+
+	if err := registry.Upsert(ctx, entry); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Msgf("[DNS] Failed to register %s record for %s/%s", entry.Type, namespace, name)
+	}
+}
@@ -0,0 +1,83 @@
+package records
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+var (
+	ttlEventLock sync.Mutex //nolint:gochecknoglobals // Required for TTL events
+	// ttlEvents holds the TTL event last emitted for every service, by UID, so the desired state
+	// can be worked out on every reconcile without repeating it
+	ttlEvents = make(map[types.UID]string) //nolint:gochecknoglobals // Required for TTL events
+)
+
+// reportTTL emits a TTL event on the service unless the same one was emitted last, it is repeated
+// once the TTL annotation or the configured TTL changes.
+func reportTTL(
+	service *v1.Service,
+	reason string,
+	messageFmt string,
+	args ...interface{},
+) {
+	message := fmt.Sprintf(messageFmt, args...)
+	ttlEventLock.Lock()
+	reported := ttlEvents[service.UID] == reason+": "+message
+	ttlEvents[service.UID] = reason + ": " + message
+	ttlEventLock.Unlock()
+	if !reported {
+		utils.Recorder.Event(service, v1.EventTypeWarning, reason, message)
+	}
+}
+
+// forgetTTLEvents drops the TTL event of a service, a valid TTL or a deleted service has none.
+func forgetTTLEvents(
+	service *v1.Service,
+) {
+	ttlEventLock.Lock()
+	defer ttlEventLock.Unlock()
+	delete(ttlEvents, service.UID)
+}
+
+func recordTTL(
+	service *v1.Service,
+	zoneName string,
+) (int, error) {
+	value, err := cfg.GetRequiredZoneConfigValue(zoneName, "record-ttl")
+	if err != nil {
+		return 0, err
+	}
+	if annotation, ok := service.Annotations["greydns.io/ttl"]; ok {
+		value = annotation
+	}
+
+	ttl, err := strconv.Atoi(value)
+	if err != nil {
+		reportTTL(service, "InvalidTTL", "TTL %s is not a valid integer", value)
+		return 0, err
+	}
+
+	clamped := cf.ClampTTL(ttl)
+	if clamped == ttl {
+		forgetTTLEvents(service)
+		return ttl, nil
+	}
+
+	// Depending on the policy an out of range TTL is either rejected or clamped to the provider limits
+	if cfg.GetConfigValue("ttl-policy", "clamp") == "reject" {
+		reportTTL(service, "InvalidTTL", "TTL %d is outside the range supported by the provider", ttl)
+		return 0, errors.New("TTL is outside the range supported by the provider")
+	}
+	reportTTL(service, "TTLClamped", "TTL %d is outside the range supported by the provider, using %d instead", ttl, clamped)
+
+	return clamped, nil
+}
@@ -0,0 +1,226 @@
+package records
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go/v4/dns"
+	v1 "k8s.io/api/core/v1"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/providers"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+var (
+	// Service annotations greydns reads or writes, anything else under greydns.io/ is a typo
+	knownAnnotations = []string{ //nolint:gochecknoglobals // Required for annotation validation
+		"greydns.io/dns",
+		"greydns.io/domain",
+		"greydns.io/zone",
+		"greydns.io/zone-id",
+		"greydns.io/record-type",
+		"greydns.io/target",
+		"greydns.io/ttl",
+		"greydns.io/proxied",
+		"greydns.io/tags",
+		"greydns.io/comment",
+		"greydns.io/on-delete",
+		"greydns.io/health-check",
+		"greydns.io/health-check-protocol",
+		"greydns.io/health-check-port",
+		"greydns.io/health-check-path",
+		"greydns.io/internal-domain",
+		"greydns.io/internal-target",
+		"greydns.io/ingress-destination-v6",
+		"greydns.io/txt",
+		"greydns.io/adopt",
+		"greydns.io/transfer-to",
+		"greydns.io/conflict-policy",
+		"greydns.io/certificate",
+		"greydns.io/certificate-issuer",
+		"greydns.io/certificate-issuer-kind",
+	}
+	annotationValues = map[string][]string{ //nolint:gochecknoglobals // Required for annotation validation
+		"greydns.io/dns":             {"true", "false"},
+		"greydns.io/record-type":     {"A", "AAAA", "CNAME", "NS"},
+		"greydns.io/proxied":         {"true", "false"},
+		"greydns.io/on-delete":       {"delete", "retain"},
+		"greydns.io/conflict-policy": {conflictSkip, conflictTakeover, conflictError},
+		"greydns.io/adopt":           {"true", "false"},
+		"greydns.io/health-check":    {"true", "false"},
+		"greydns.io/certificate":     {"true", "false"},
+	}
+)
+
+// Problem is a mistake in the greydns annotations of a service.
+type Problem struct {
+	Service  string
+	Severity string
+	Message  string
+}
+
+// Validate checks the greydns annotations of services without changing anything: malformed
+// values and domains, unknown zones, invalid TTLs and domains claimed by several services or
+// owned by another service at the provider. Problems are sorted by service.
+func Validate(
+	ctx context.Context,
+	services []v1.Service,
+	ingressDestination string,
+	zonesToNames map[string]string,
+	existingRecords map[string][]dns.RecordResponse,
+) []Problem {
+	var problems []Problem
+	// Services asking for every record, by cache key
+	claims := make(map[string][]Desired)
+	for i := range services {
+		service := &services[i]
+		owner := service.Namespace + "/" + service.Name
+		report := func(severity string, format string, args ...any) {
+			problems = append(problems, Problem{Service: owner, Severity: severity, Message: fmt.Sprintf(format, args...)})
+		}
+
+		found := validateAnnotations(ctx, service, zonesToNames, report)
+		if found || service.Annotations["greydns.io/dns"] != "true" {
+			continue
+		}
+		zoneID, records, err := DesiredRecords(ctx, service, ingressDestination, zonesToNames)
+		if err != nil {
+			report(SeverityError, "%v", err)
+			continue
+		}
+		for _, record := range records {
+			key := providers.RecordKey(zoneID, record.Name, record.Type)
+			claims[key] = append(claims[key], Desired{Record: record, Service: owner})
+			recordSet, exists := existingRecords[key]
+			if !exists {
+				continue
+			}
+			if namespace, name, ok := providers.OwnerService(recordSet[0].Comment); ok && namespace+"/"+name != owner {
+				report(SeverityWarning, "%s record %s is owned by %s/%s, handled by conflict-policy", record.Type, record.Name, namespace, name)
+			}
+		}
+	}
+
+	for _, claimants := range claims {
+		if len(claimants) < 2 {
+			continue
+		}
+		for _, claimant := range claimants {
+			others := make([]string, 0, len(claimants)-1)
+			for _, other := range claimants {
+				if other.Service != claimant.Service {
+					others = append(others, other.Service)
+				}
+			}
+			problems = append(problems, Problem{
+				Service:  claimant.Service,
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("%s record %s is also claimed by %s", claimant.Record.Type, claimant.Record.Name, strings.Join(others, ", ")),
+			})
+		}
+	}
+
+	slices.SortStableFunc(problems, func(a, b Problem) int {
+		return strings.Compare(a.Service, b.Service)
+	})
+
+	return problems
+}
+
+// validateAnnotations reports problems the records of a service cannot be worked out with and
+// whether it found an error.
+func validateAnnotations(
+	ctx context.Context,
+	service *v1.Service,
+	zonesToNames map[string]string,
+	report func(severity string, format string, args ...any),
+) bool {
+	found := false
+	reportError := func(format string, args ...any) {
+		found = true
+		report(SeverityError, format, args...)
+	}
+
+	annotated := false
+	for key, value := range service.Annotations {
+		// The propagation status is written by greydns itself
+		if !strings.HasPrefix(key, "greydns.io/") || key == "greydns.io/propagation" {
+			continue
+		}
+		annotated = true
+		if !slices.Contains(knownAnnotations, key) {
+			report(SeverityWarning, "unknown annotation %s", key)
+			continue
+		}
+		if allowed, ok := annotationValues[key]; ok && !slices.Contains(allowed, strings.ToUpper(value)) && !slices.Contains(allowed, value) {
+			reportError("%s must be one of %s, not %q", key, strings.Join(allowed, ", "), value)
+		}
+	}
+	if service.Annotations["greydns.io/dns"] != "true" {
+		if annotated && service.Annotations["greydns.io/dns"] == "" {
+			report(SeverityWarning, "greydns.io annotations are ignored without greydns.io/dns: \"true\"")
+		}
+		return found
+	}
+
+	zoneName := serviceZone(service)
+	if zoneID, ok := service.Annotations["greydns.io/zone-id"]; ok {
+		zone, err := cf.GetZone(ctx, zoneID)
+		if err != nil {
+			reportError("zone ID %s does not exist or is not accessible", zoneID)
+			return found
+		}
+		zoneName = zone.Name
+	} else if zoneName == "" {
+		reportError("no zone, set greydns.io/zone, namespace-zones or base-zone")
+		return found
+	} else if _, ok = zonesToNames[zoneName]; !ok {
+		reportError("zone %s does not exist or is not accessible", zoneName)
+		return found
+	}
+
+	if value, ok := service.Annotations["greydns.io/ttl"]; ok {
+		validateTTL(value, report, reportError)
+	}
+
+	domain, err := serviceDomain(service, zoneName)
+	switch {
+	case err != nil:
+		reportError("%v", err)
+	case !validDomain(domain):
+		reportError("domain %q is not a valid hostname", domain)
+	case !inZone(domain, zoneName):
+		reportError("domain %s is not in zone %s", domain, zoneName)
+	}
+
+	return found
+}
+
+func validateTTL(
+	value string,
+	report func(severity string, format string, args ...any),
+	reportError func(format string, args ...any),
+) {
+	ttl, err := strconv.Atoi(value)
+	if err != nil {
+		reportError("TTL %q is not a valid integer", value)
+		return
+	}
+	clamped := cf.ClampTTL(ttl)
+	switch {
+	case clamped == ttl:
+	case cfg.GetConfigValue("ttl-policy", "clamp") == "reject":
+		reportError("TTL %d is outside the range supported by the provider", ttl)
+	default:
+		report(SeverityWarning, "TTL %d is outside the range supported by the provider, %d is used", ttl, clamped)
+	}
+}
@@ -0,0 +1,205 @@
+package records
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	v1 "k8s.io/api/core/v1"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/providers"
+	cf "github.com/math280h/greydns/internal/providers/cf"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	defaultVerifyDelaySeconds = 10
+	verifyAttempts            = 3
+	verifyTimeout             = 5 * time.Second
+)
+
+var (
+	// nameServers caches the authoritative nameservers of every zone, they practically never change
+	nameServers sync.Map //nolint:gochecknoglobals // Required for record verification
+)
+
+// verifyRecord checks in the background that the authoritative nameservers of the zone answer
+// with the record once it was created or updated, when verify-records is enabled. The outcome
+// is reported as an event on the service.
+func verifyRecord(
+	ctx context.Context,
+	record providers.Record,
+	zoneID string,
+	service *v1.Service,
+) {
+	if cfg.GetConfigValue("verify-records", "false") != "true" || providers.DryRun() {
+		return
+	}
+	switch record.Type {
+	case "A", "AAAA", "CNAME", "TXT":
+	default:
+		return
+	}
+
+	// The reconcile is done by the time the record is checked
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer utils.Recover("verify", nil)
+		servers, err := zoneNameServers(ctx, zoneID)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msgf("[DNS] Not verifying %s record, failed to get the nameservers", record.Type)
+			return
+		}
+
+		var answer []string
+		for attempt := range verifyAttempts {
+			// Give the provider time to publish the change, and more after every miss
+			time.Sleep(time.Duration(attempt+1) * verifyDelay())
+			answer, err = Resolve(ctx, net.JoinHostPort(servers[0], "53"), record)
+			if err == nil && Resolved(answer, record) {
+				zerolog.Ctx(ctx).Info().Msgf("[DNS] %s record verified on %s", record.Type, servers[0])
+				utils.Recorder.Eventf(
+					service,
+					v1.EventTypeNormal,
+					"RecordVerified",
+					"%s record %s resolves to %s on %s",
+					record.Type,
+					record.Name,
+					strings.Join(answer, ","),
+					servers[0],
+				)
+				return
+			}
+		}
+
+		message := "resolves to " + strings.Join(answer, ",")
+		if err != nil {
+			message = "does not resolve: " + err.Error()
+		}
+		zerolog.Ctx(ctx).Warn().Msgf("[DNS] %s record %s on %s", record.Type, message, servers[0])
+		utils.Recorder.Eventf(
+			service,
+			v1.EventTypeWarning,
+			"VerificationFailed",
+			"%s record %s %s on %s, expected %s",
+			record.Type,
+			record.Name,
+			message,
+			servers[0],
+			expectedAnswer(record),
+		)
+	}()
+}
+
+func verifyDelay() time.Duration {
+	seconds, err := strconv.Atoi(cfg.GetConfigValue("verify-delay-seconds", strconv.Itoa(defaultVerifyDelaySeconds)))
+	if err != nil || seconds <= 0 {
+		return defaultVerifyDelaySeconds * time.Second
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+func zoneNameServers(
+	ctx context.Context,
+	zoneID string,
+) ([]string, error) {
+	if servers, ok := nameServers.Load(zoneID); ok {
+		return servers.([]string), nil //nolint:forcetypeassert // Only []string is stored
+	}
+	zone, err := cf.GetZone(ctx, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	if len(zone.NameServers) == 0 {
+		return nil, errors.New("zone has no nameservers")
+	}
+	nameServers.Store(zoneID, zone.NameServers)
+
+	return zone.NameServers, nil
+}
+
+// Resolve looks a record up at the nameserver or resolver at address (host:port) only.
+func Resolve(
+	ctx context.Context,
+	address string,
+	record providers.Record,
+) ([]string, error) {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+	ctx, cancel := context.WithTimeout(ctx, verifyTimeout)
+	defer cancel()
+
+	switch {
+	case record.Proxied || record.Type == "A" || record.Type == "AAAA":
+		// Proxied records answer with the addresses of the provider's proxy
+		network := "ip4"
+		if record.Type == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := resolver.LookupNetIP(ctx, network, record.Name)
+		if err != nil {
+			return nil, err
+		}
+		answer := make([]string, 0, len(ips))
+		for _, ip := range ips {
+			answer = append(answer, ip.String())
+		}
+		return answer, nil
+	case record.Type == "CNAME":
+		target, err := resolver.LookupCNAME(ctx, record.Name)
+		if err != nil {
+			return nil, err
+		}
+		return []string{strings.TrimSuffix(target, ".")}, nil
+	case record.Type == "TXT":
+		return resolver.LookupTXT(ctx, record.Name)
+	default:
+		return nil, fmt.Errorf("%s records are not verified", record.Type)
+	}
+}
+
+// Resolved compares an answer with the record contents, proxied records only have to resolve.
+func Resolved(
+	answer []string,
+	record providers.Record,
+) bool {
+	if record.Proxied {
+		return len(answer) > 0
+	}
+	expected := make([]string, 0, len(record.Contents))
+	for _, content := range record.Contents {
+		expected = append(expected, strings.TrimSuffix(strings.ToLower(content), "."))
+	}
+	got := make([]string, 0, len(answer))
+	for _, value := range answer {
+		got = append(got, strings.ToLower(value))
+	}
+	slices.Sort(expected)
+	slices.Sort(got)
+
+	return slices.Equal(expected, got)
+}
+
+func expectedAnswer(
+	record providers.Record,
+) string {
+	if record.Proxied {
+		return "the proxy addresses"
+	}
+
+	return strings.Join(record.Contents, ",")
+}
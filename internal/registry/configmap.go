@@ -0,0 +1,205 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/math280h/greydns/internal/audit"
+	cfg "github.com/math280h/greydns/internal/config"
+)
+
+const (
+	configMapDefault = "greydns-registry"
+	// Older versions kept the whole index in a single ConfigMap in this namespace
+	legacyConfigMapNamespace = "default"
+	registryLabel            = "greydns.io/registry"
+)
+
+// configMapBackend keeps the index in ConfigMaps next to greydns, one per namespace of the owning
+// services with one key per entry, so no single ConfigMap has to hold every record.
+type configMapBackend struct {
+	clientset *kubernetes.Clientset
+	name      string
+	namespace string
+}
+
+func ConnectConfigMap(
+	clientset *kubernetes.Clientset,
+) {
+	active = &configMapBackend{
+		clientset: clientset,
+		name:      cfg.GetConfigValue("registry-configmap", configMapDefault),
+		namespace: cfg.GetConfigValue("registry-namespace", cfg.ControllerNamespace()),
+	}
+}
+
+// entryKey is a valid ConfigMap key for an entry, e.g. api.example.com-a.
+func entryKey(
+	name string,
+	recordType string,
+) string {
+	return objectName(name, recordType, maxNameLength)
+}
+
+// shardName is the ConfigMap holding the entries of the services in a namespace, e.g.
+// greydns-registry-shop.
+func (b *configMapBackend) shardName(
+	namespace string,
+) string {
+	return b.name + "-" + namespace
+}
+
+func (b *configMapBackend) update(
+	ctx context.Context,
+	namespace string,
+	mutate func(data map[string]string) error,
+) error {
+	configMaps := b.clientset.CoreV1().ConfigMaps(b.namespace)
+	name := b.shardName(namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			configMap, err = configMaps.Create(ctx, &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   name,
+					Labels: map[string]string{registryLabel: b.name},
+				},
+			}, metav1.CreateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		if err = mutate(configMap.Data); err != nil {
+			return err
+		}
+
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (b *configMapBackend) upsert(
+	ctx context.Context,
+	entry Entry,
+) error {
+	return b.update(ctx, entry.Namespace, func(data map[string]string) error {
+		key := entryKey(entry.Name, entry.Type)
+		var stored Entry
+		if existing, ok := data[key]; ok && json.Unmarshal([]byte(existing), &stored) == nil {
+			entry.History = audit.MergeHistory(stored.History, entry.History)
+		}
+		content, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		data[key] = string(content)
+		return nil
+	})
+}
+
+func (b *configMapBackend) remove(
+	ctx context.Context,
+	namespace string,
+	name string,
+	recordType string,
+) error {
+	return b.update(ctx, namespace, func(data map[string]string) error {
+		delete(data, entryKey(name, recordType))
+		return nil
+	})
+}
+
+// setSynced does nothing, entries in the ConfigMap carry no status.
+func (b *configMapBackend) setSynced(
+	_ context.Context,
+	_ string,
+	_ string,
+	_ error,
+) error {
+	return nil
+}
+
+func (b *configMapBackend) list(ctx context.Context) ([]Entry, error) {
+	if err := b.migrate(ctx); err != nil {
+		return nil, err
+	}
+	configMaps, err := b.clientset.CoreV1().ConfigMaps(b.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: registryLabel + "=" + b.name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0)
+	for _, configMap := range configMaps.Items {
+		entries = append(entries, parseEntries(configMap)...)
+	}
+
+	return entries, nil
+}
+
+// migrate moves the entries of the single ConfigMap older versions kept in the default namespace
+// into the per-namespace ConfigMaps and deletes it.
+func (b *configMapBackend) migrate(ctx context.Context) error {
+	configMaps := b.clientset.CoreV1().ConfigMaps(legacyConfigMapNamespace)
+	legacy, err := configMaps.Get(ctx, b.name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	byNamespace := make(map[string][]Entry)
+	for _, entry := range parseEntries(*legacy) {
+		byNamespace[entry.Namespace] = append(byNamespace[entry.Namespace], entry)
+	}
+	for namespace, entries := range byNamespace {
+		err = b.update(ctx, namespace, func(data map[string]string) error {
+			for _, entry := range entries {
+				content, marshalErr := json.Marshal(entry)
+				if marshalErr != nil {
+					return marshalErr
+				}
+				data[entryKey(entry.Name, entry.Type)] = string(content)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	log.Info().Msgf("[Registry] Moved %d entries from %s/%s to %s", len(legacy.Data), legacyConfigMapNamespace, b.name, b.namespace)
+
+	err = configMaps.Delete(ctx, b.name, metav1.DeleteOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+func parseEntries(configMap v1.ConfigMap) []Entry {
+	entries := make([]Entry, 0, len(configMap.Data))
+	for key, content := range configMap.Data {
+		var entry Entry
+		if err := json.Unmarshal([]byte(content), &entry); err != nil {
+			log.Error().Err(err).Msgf("[Registry] Failed to parse %s of %s", key, configMap.Name)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
@@ -0,0 +1,309 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	"github.com/math280h/greydns/internal/audit"
+)
+
+const (
+	maxNameLength  = 253
+	nameHashLength = 8
+
+	// Conditions in the status of a ManagedRecord, kstatus and GitOps tools compute health from them
+	conditionReady  = "Ready"
+	conditionSynced = "Synced"
+)
+
+var (
+	managedRecordResource = schema.GroupVersionResource{ //nolint:gochecknoglobals // Required for the registry
+		Group:    "greydns.io",
+		Version:  "v1alpha1",
+		Resource: "managedrecords",
+	}
+	// Anything but what RFC 1123 allows in object names, e.g. the underscores of _acme-challenge
+	invalidNameCharacters = regexp.MustCompile(`[^a-z0-9.-]+`) //nolint:gochecknoglobals // Required for the registry
+)
+
+type managedRecordSpec struct {
+	Domain    string   `json:"domain"`
+	Type      string   `json:"type"`
+	Service   string   `json:"service"`
+	ZoneID    string   `json:"zoneId"`
+	Contents  []string `json:"contents"`
+	RecordIDs []string `json:"recordIds"`
+	TTL       int      `json:"ttl"`
+	Proxied   bool     `json:"proxied"`
+	Comment   string   `json:"comment"`
+}
+
+type managedRecordStatus struct {
+	LastSyncTime       metav1.Time        `json:"lastSyncTime"`
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+	History            []audit.Event      `json:"history,omitempty"`
+}
+
+type managedRecord struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   managedRecordSpec   `json:"spec"`
+	Status managedRecordStatus `json:"status,omitempty"`
+}
+
+// crdBackend stores every entry as a ManagedRecord object next to the owning service.
+type crdBackend struct {
+	client dynamic.Interface
+}
+
+func ConnectCRD(
+	config *rest.Config,
+) error {
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+	active = &crdBackend{client: client}
+
+	return nil
+}
+
+// objectName turns a record into a valid object name of at most maxLength characters, e.g.
+// api.example.com-a. Names that had to be changed or cut off end in a hash of the record, e.g.
+// acme-challenge.example.com-1a2b3c4d-txt, so they stay unique.
+func objectName(
+	name string,
+	recordType string,
+	maxLength int,
+) string {
+	// The type is kept when the name is cut off, records of different types share their name
+	suffix := "-" + strings.ToLower(recordType)
+	readable := strings.ToLower(strings.ReplaceAll(name, "*", "wildcard"))
+	// Every label has to start and end with a letter or digit
+	labels := strings.Split(invalidNameCharacters.ReplaceAllString(readable, "-"), ".")
+	for i, label := range labels {
+		labels[i] = strings.Trim(label, "-")
+	}
+	objectName := strings.Join(slices.DeleteFunc(labels, func(label string) bool {
+		return label == ""
+	}), ".")
+	if objectName == readable && len(objectName)+len(suffix) <= maxLength {
+		return objectName + suffix
+	}
+
+	sum := sha256.Sum256([]byte(name + "/" + recordType))
+	hash := hex.EncodeToString(sum[:])[:nameHashLength]
+	if length := maxLength - len(suffix) - len(hash) - 1; len(objectName) > length {
+		objectName = strings.TrimRight(objectName[:length], "-.")
+	}
+	if objectName == "" {
+		return hash + suffix
+	}
+
+	return objectName + "-" + hash + suffix
+}
+
+func (b *crdBackend) upsert(
+	ctx context.Context,
+	entry Entry,
+) error {
+	record := managedRecord{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: managedRecordResource.GroupVersion().String(),
+			Kind:       "ManagedRecord",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      objectName(entry.Name, entry.Type, maxNameLength),
+			Namespace: entry.Namespace,
+			Labels: map[string]string{
+				"greydns.io/service": entry.Service,
+			},
+		},
+		Spec: managedRecordSpec{
+			Domain:    entry.Name,
+			Type:      entry.Type,
+			Service:   entry.Service,
+			ZoneID:    entry.ZoneID,
+			Contents:  entry.Contents,
+			RecordIDs: entry.RecordIDs,
+			TTL:       entry.TTL,
+			Proxied:   entry.Proxied,
+			Comment:   entry.Comment,
+		},
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&record)
+	if err != nil {
+		return err
+	}
+	object := &unstructured.Unstructured{Object: content}
+
+	resource := b.client.Resource(managedRecordResource).Namespace(entry.Namespace)
+	existing, err := resource.Get(ctx, record.Name, metav1.GetOptions{})
+	switch {
+	case k8serrors.IsNotFound(err):
+		object, err = resource.Create(ctx, object, metav1.CreateOptions{})
+	case err == nil:
+		object.SetResourceVersion(existing.GetResourceVersion())
+		object, err = resource.Update(ctx, object, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	// The status is a subresource, it is only written through UpdateStatus
+	var stored managedRecord
+	if err = runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, &stored); err != nil {
+		return err
+	}
+	stored.Status.LastSyncTime = metav1.NewTime(entry.LastSync)
+	stored.Status.History = audit.MergeHistory(stored.Status.History, entry.History)
+	stored.Status.ObservedGeneration = stored.Generation
+	meta.SetStatusCondition(&stored.Status.Conditions, metav1.Condition{
+		Type:               conditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Provisioned",
+		Message:            "The record set exists at the provider",
+		ObservedGeneration: stored.Generation,
+	})
+	// Synced follows the reconciles of the service, a failing one may still write some records
+	if meta.FindStatusCondition(stored.Status.Conditions, conditionSynced) == nil {
+		meta.SetStatusCondition(&stored.Status.Conditions, metav1.Condition{
+			Type:               conditionSynced,
+			Status:             metav1.ConditionTrue,
+			Reason:             "Synced",
+			Message:            "The record set matches the service",
+			ObservedGeneration: stored.Generation,
+		})
+	}
+
+	return b.updateStatus(ctx, &stored)
+}
+
+func (b *crdBackend) updateStatus(
+	ctx context.Context,
+	record *managedRecord,
+) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(record)
+	if err != nil {
+		return err
+	}
+	_, err = b.client.Resource(managedRecordResource).Namespace(record.Namespace).UpdateStatus(
+		ctx,
+		&unstructured.Unstructured{Object: content},
+		metav1.UpdateOptions{},
+	)
+
+	return err
+}
+
+// setSynced sets the Synced condition of every record of a service to the outcome of its last reconcile.
+func (b *crdBackend) setSynced(
+	ctx context.Context,
+	namespace string,
+	service string,
+	syncErr error,
+) error {
+	list, err := b.client.Resource(managedRecordResource).Namespace(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "greydns.io/service=" + service,
+	})
+	if err != nil {
+		return err
+	}
+
+	condition := metav1.Condition{
+		Type:    conditionSynced,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Synced",
+		Message: "The record set matches the service",
+	}
+	if syncErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "SyncFailed"
+		condition.Message = syncErr.Error()
+	}
+	var errs []error
+	for _, item := range list.Items {
+		var record managedRecord
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &record); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		condition.ObservedGeneration = record.Generation
+		if !meta.SetStatusCondition(&record.Status.Conditions, condition) {
+			continue
+		}
+		if err = b.updateStatus(ctx, &record); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (b *crdBackend) remove(
+	ctx context.Context,
+	namespace string,
+	name string,
+	recordType string,
+) error {
+	err := b.client.Resource(managedRecordResource).Namespace(namespace).Delete(
+		ctx,
+		objectName(name, recordType, maxNameLength),
+		metav1.DeleteOptions{},
+	)
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (b *crdBackend) list(ctx context.Context) ([]Entry, error) {
+	list, err := b.client.Resource(managedRecordResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(list.Items))
+	for _, item := range list.Items {
+		var record managedRecord
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &record)
+		if err != nil {
+			log.Error().Err(err).Msgf("[Registry] Failed to parse %s/%s", item.GetNamespace(), item.GetName())
+			continue
+		}
+		entries = append(entries, Entry{
+			Namespace: record.Namespace,
+			Service:   record.Spec.Service,
+			Name:      record.Spec.Domain,
+			Type:      record.Spec.Type,
+			ZoneID:    record.Spec.ZoneID,
+			Contents:  record.Spec.Contents,
+			RecordIDs: record.Spec.RecordIDs,
+			TTL:       record.Spec.TTL,
+			Proxied:   record.Spec.Proxied,
+			Comment:   record.Spec.Comment,
+			LastSync:  record.Status.LastSyncTime.Time,
+			History:   record.Status.History,
+		})
+	}
+
+	return entries, nil
+}
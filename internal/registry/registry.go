@@ -0,0 +1,99 @@
+package registry
+
+import (
+	"context"
+	"time"
+
+	"github.com/math280h/greydns/internal/audit"
+	"github.com/math280h/greydns/internal/providers"
+)
+
+// backend persists the index of records greydns manages.
+type backend interface {
+	upsert(ctx context.Context, entry Entry) error
+	remove(ctx context.Context, namespace string, name string, recordType string) error
+	list(ctx context.Context) ([]Entry, error)
+	setSynced(ctx context.Context, namespace string, service string, err error) error
+}
+
+var (
+	active backend //nolint:gochecknoglobals // Required for the registry
+)
+
+// Entry describes a record set greydns provisioned at the provider.
+type Entry struct {
+	Namespace string    `json:"namespace"`
+	Service   string    `json:"service"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	ZoneID    string    `json:"zoneId"`
+	Contents  []string  `json:"contents"`
+	RecordIDs []string  `json:"recordIds"`
+	TTL       int       `json:"ttl"`
+	Proxied   bool      `json:"proxied"`
+	Comment   string    `json:"comment"`
+	LastSync  time.Time `json:"lastSync"`
+	// History holds the last history-size changes of the record set, backends merge it with the
+	// history they already store
+	History []audit.Event `json:"history,omitempty"`
+}
+
+func Enabled() bool {
+	return active != nil
+}
+
+func Upsert(
+	ctx context.Context,
+	entry Entry,
+) error {
+	if !Enabled() {
+		return nil
+	}
+
+	// Dry-run records were never created, registering them would poison the cache on restart
+	if providers.DryRun() {
+		return nil
+	}
+
+	return active.upsert(ctx, entry)
+}
+
+func Remove(
+	ctx context.Context,
+	namespace string,
+	name string,
+	recordType string,
+) error {
+	if !Enabled() {
+		return nil
+	}
+
+	if providers.DryRun() {
+		return nil
+	}
+
+	return active.remove(ctx, namespace, name, recordType)
+}
+
+// SetSynced reports the outcome of the last reconcile of a service on its entries, a nil err
+// marks them synced.
+func SetSynced(
+	ctx context.Context,
+	namespace string,
+	service string,
+	err error,
+) error {
+	if !Enabled() || providers.DryRun() {
+		return nil
+	}
+
+	return active.setSynced(ctx, namespace, service, err)
+}
+
+func List(ctx context.Context) ([]Entry, error) {
+	if !Enabled() {
+		return nil, nil
+	}
+
+	return active.list(ctx)
+}
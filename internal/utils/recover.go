@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/math280h/greydns/internal/metrics"
+)
+
+// Recover logs and counts a panic instead of letting it stop the controller, it has to be
+// deferred directly. When err is set the panic is returned through it, so the work is retried.
+func Recover(
+	component string,
+	err *error,
+) {
+	value := recover()
+	if value == nil {
+		return
+	}
+
+	metrics.Panics.WithLabelValues(component).Inc()
+	log.Error().Str("component", component).Str("stack", string(debug.Stack())).Msgf("[Core] Recovered from panic: %v", value)
+	if err != nil {
+		*err = fmt.Errorf("panic: %v", value)
+	}
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+)
+
+var (
+	// StartupBackoff retries a dependency needed on startup for about a minute before giving up.
+	StartupBackoff = wait.Backoff{ //nolint:gochecknoglobals // Required for startup retries
+		Duration: 1 * time.Second,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    7,
+		Cap:      30 * time.Second,
+	}
+)
+
+// RetryStartup calls fn until it succeeds, retriable returns false or StartupBackoff is exhausted.
+func RetryStartup(
+	what string,
+	retriable func(error) bool,
+	fn func() error,
+) error {
+	attempt := 0
+	return retry.OnError(StartupBackoff, retriable, func() error {
+		attempt++
+		err := fn()
+		if err != nil && retriable(err) {
+			log.Warn().Err(err).Msgf("[Core] Failed to %s, attempt %d of %d", what, attempt, StartupBackoff.Steps)
+		}
+		return err
+	})
+}
+
+// Always retries every error.
+func Always(error) bool {
+	return true
+}
@@ -0,0 +1,169 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	cfg "github.com/math280h/greydns/internal/config"
+	"github.com/math280h/greydns/internal/providers"
+	"github.com/math280h/greydns/internal/utils"
+)
+
+const (
+	maxRequestBytes = 1 << 20
+)
+
+var (
+	// Namespace annotations holding the default of each service annotation
+	namespaceDefaults = map[string]string{ //nolint:gochecknoglobals // Required for the webhook
+		"greydns.io/default-zone":        "greydns.io/zone",
+		"greydns.io/default-ttl":         "greydns.io/ttl",
+		"greydns.io/default-record-type": "greydns.io/record-type",
+	}
+)
+
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Start serves the mutating webhook on webhook-addr when it is set.
+func Start(
+	ctx context.Context,
+	clientset *kubernetes.Clientset,
+) {
+	addr := cfg.GetConfigValue("webhook-addr", "")
+	if addr == "" {
+		return
+	}
+	certFile := cfg.GetConfigValue("webhook-cert-file", "/tls/tls.crt")
+	keyFile := cfg.GetConfigValue("webhook-key-file", "/tls/tls.key")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mutate", func(w http.ResponseWriter, r *http.Request) {
+		defer utils.Recover("webhook", nil)
+		serveMutate(clientset, w, r)
+	})
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		log.Info().Msgf("[Webhook] Serving on %s", addr)
+		if err := server.ListenAndServeTLS(certFile, keyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("[Webhook] Server stopped")
+		}
+	}()
+}
+
+func serveMutate(
+	clientset *kubernetes.Clientset,
+	w http.ResponseWriter,
+	r *http.Request,
+) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var review admissionv1.AdmissionReview
+	if err = json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, "invalid admission review", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = admit(r.Context(), clientset, review.Request)
+	review.Response.UID = review.Request.UID
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(review); err != nil {
+		log.Error().Err(err).Msg("[Webhook] Failed to write response")
+	}
+}
+
+// admit always allows the service, a failure to look up the defaults only skips the mutation.
+func admit(
+	ctx context.Context,
+	clientset *kubernetes.Clientset,
+	request *admissionv1.AdmissionRequest,
+) *admissionv1.AdmissionResponse {
+	response := &admissionv1.AdmissionResponse{Allowed: true}
+
+	var service v1.Service
+	if err := json.Unmarshal(request.Object.Raw, &service); err != nil {
+		log.Error().Err(err).Msg("[Webhook] Failed to decode service")
+		return response
+	}
+	if service.Annotations["greydns.io/dns"] != "true" {
+		return response
+	}
+
+	callCtx, cancel := providers.WithTimeout(ctx)
+	defer cancel()
+	namespace, err := clientset.CoreV1().Namespaces().Get(callCtx, request.Namespace, metav1.GetOptions{})
+	if err != nil {
+		log.Error().Err(err).Msgf("[Webhook] Failed to get namespace %s", request.Namespace)
+		return response
+	}
+
+	patch := defaultsPatch(namespace, &service)
+	if len(patch) == 0 {
+		return response
+	}
+	raw, err := json.Marshal(patch)
+	if err != nil {
+		log.Error().Err(err).Msg("[Webhook] Failed to encode patch")
+		return response
+	}
+	patchType := admissionv1.PatchTypeJSONPatch
+	response.Patch = raw
+	response.PatchType = &patchType
+	log.Info().Msgf("[Webhook] [%s/%s] Added %d default annotations", request.Namespace, service.Name, len(patch))
+
+	return response
+}
+
+// defaultsPatch adds every namespace default the service does not set itself.
+func defaultsPatch(
+	namespace *v1.Namespace,
+	service *v1.Service,
+) []patchOperation {
+	patch := make([]patchOperation, 0, len(namespaceDefaults))
+	for namespaceKey, serviceKey := range namespaceDefaults {
+		value, ok := namespace.Annotations[namespaceKey]
+		if !ok {
+			continue
+		}
+		if _, set := service.Annotations[serviceKey]; set {
+			continue
+		}
+		// JSON pointers escape / as ~1
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  "/metadata/annotations/" + strings.ReplaceAll(serviceKey, "/", "~1"),
+			Value: value,
+		})
+	}
+
+	return patch
+}